@@ -0,0 +1,258 @@
+// Package audit keeps an append-only json lines journal of cluster mutations:
+// who applied or destroyed which groups, on which hosts and etags, and what
+// capi answered.
+package audit
+
+import (
+	"bufio"
+	"capi_tools/clusterapi"
+	"capi_tools/history"
+	"capi_tools/localuser"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Actions of journal entries.
+const (
+	Apply   = "apply"
+	Destroy = "destroy"
+)
+
+// Results of a group in an entry. Unknown means request failed before capi
+// answered, the group may or may not have been changed.
+const (
+	OK      = "ok"
+	Failed  = "failed"
+	Unknown = "unknown"
+)
+
+// Group is one group of a mutation request.
+type Group struct {
+	GroupId     string           `json:"groupId"`
+	OperationId string           `json:"operationId,omitempty"`
+	Owner       string           `json:"owner,omitempty"`
+	Project     string           `json:"project,omitempty"`
+	Hosts       []string         `json:"hosts,omitempty"`
+	Etags       map[string]int64 `json:"etags,omitempty"`
+	Result      string           `json:"result"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// Entry is one mutation request and its response.
+type Entry struct {
+	Time        time.Time `json:"time"`
+	DurationMs  int64     `json:"durationMs"`
+	Action      string    `json:"action"`
+	Operator    string    `json:"operator"`
+	Endpoint    string    `json:"endpoint"`
+	SchedulerId string    `json:"schedulerId,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	Groups      []*Group  `json:"groups"`
+	Error       string    `json:"error,omitempty"`
+}
+
+func newEntry(action, endpoint string, s *clusterapi.SchedulerSignature) *Entry {
+	e := &Entry{
+		Time:     time.Now(),
+		Action:   action,
+		Operator: history.Author(),
+		Endpoint: endpoint,
+		Groups:   make([]*Group, 0),
+	}
+	if s != nil {
+		e.SchedulerId = s.SchedulerId
+		e.Message = s.Message
+	}
+	return e
+}
+
+func setOwner(g *Group, o *clusterapi.Owner) {
+	if o != nil {
+		g.Owner = o.OwnerId
+		g.Project = o.ProjectId
+	}
+}
+
+// NewApply starts entry of apply request sent to endpoint.
+func NewApply(endpoint string, req *clusterapi.ApplyGroupTransitionRequest) *Entry {
+	e := newEntry(Apply, endpoint, req.SchedulerSignature)
+	for _, t := range req.GroupTransitions {
+		g := &Group{
+			GroupId:     t.GroupId,
+			OperationId: t.GroupOperationId,
+			Hosts:       make([]string, 0, len(t.Transitions)),
+			Etags:       make(map[string]int64, len(t.Transitions)),
+			Result:      Unknown,
+		}
+		setOwner(g, t.Owner)
+		for _, tr := range t.Transitions {
+			g.Hosts = append(g.Hosts, tr.HostId)
+			g.Etags[tr.HostId] = tr.HostStateEtag
+		}
+		sort.Strings(g.Hosts)
+		e.Groups = append(e.Groups, g)
+	}
+	return e
+}
+
+// NewDestroy starts entry of destroy request sent to endpoint.
+func NewDestroy(endpoint string, req *clusterapi.DestroyRequest) *Entry {
+	e := newEntry(Destroy, endpoint, req.SchedulerSignature)
+	for _, d := range req.GroupsToDestroy {
+		g := &Group{GroupId: d.GroupId, Result: Unknown}
+		setOwner(g, d.Owner)
+		e.Groups = append(e.Groups, g)
+	}
+	return e
+}
+
+// Finish records duration and results. failed maps group ids to decoded
+// exceptions, err is the request error if capi did not answer.
+func (e *Entry) Finish(failed map[string]string, err error) {
+	e.DurationMs = int64(time.Since(e.Time) / time.Millisecond)
+	if err != nil {
+		e.Error = err.Error()
+		return
+	}
+	for _, g := range e.Groups {
+		if reason, ok := failed[g.GroupId]; ok {
+			g.Result = Failed
+			g.Error = reason
+		} else {
+			g.Result = OK
+		}
+	}
+}
+
+// Failed reports whether any group of the entry was not applied.
+func (e *Entry) Failed() bool {
+	for _, g := range e.Groups {
+		if g.Result != OK {
+			return true
+		}
+	}
+	return e.Error != ""
+}
+
+// DefaultPath is ~/.capi_tools/audit.jsonl.
+func DefaultPath() string {
+	return localuser.Path("audit.jsonl")
+}
+
+type Journal struct {
+	path string
+	mu   sync.Mutex
+}
+
+func Open(path string) *Journal {
+	return &Journal{path: path}
+}
+
+// Path returns file the journal is kept in.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Append writes e as one line, lines are never rewritten.
+func (j *Journal) Append(e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Filter selects journal entries, zero fields match everything.
+type Filter struct {
+	// group id glob
+	Group    string
+	Operator string
+	Action   string
+	Since    time.Time
+	Until    time.Time
+	Failed   bool
+}
+
+// Match reports whether e passes the filter.
+func (f *Filter) Match(e *Entry) bool {
+	if f.Operator != "" && e.Operator != f.Operator {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+	if f.Failed && !e.Failed() {
+		return false
+	}
+	if f.Group == "" {
+		return true
+	}
+	for _, g := range e.Groups {
+		if ok, _ := path.Match(f.Group, g.GroupId); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Read returns entries matching f in journal order. Missing journal has no
+// entries.
+func (j *Journal) Read(f Filter) ([]*Entry, error) {
+	if f.Group != "" {
+		if _, err := path.Match(f.Group, ""); err != nil {
+			return nil, fmt.Errorf("bad group pattern %q: %v", f.Group, err)
+		}
+	}
+	entries := make([]*Entry, 0)
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	s := bufio.NewScanner(file)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; s.Scan(); n++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		e := new(Entry)
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", j.path, n, err)
+		}
+		if f.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, s.Err()
+}
@@ -0,0 +1,161 @@
+package audit
+
+import (
+	"capi_tools/clusterapi"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func applyRequest(groups ...string) *clusterapi.ApplyGroupTransitionRequest {
+	req := &clusterapi.ApplyGroupTransitionRequest{
+		SchedulerSignature: &clusterapi.SchedulerSignature{SchedulerId: "capictl", Message: "deploy"},
+	}
+	for _, g := range groups {
+		req.GroupTransitions = append(req.GroupTransitions, &clusterapi.GroupTransition{
+			GroupId:          g,
+			GroupOperationId: "op-" + g,
+			Owner:            &clusterapi.Owner{OwnerId: "o", ProjectId: "P"},
+			Transitions: []*clusterapi.Transition{
+				{HostId: "b", HostStateEtag: 2},
+				{HostId: "a", HostStateEtag: 1},
+			},
+		})
+	}
+	return req
+}
+
+func TestNewApply(t *testing.T) {
+	e := NewApply("http://capi", applyRequest("g1"))
+	if e.Action != Apply || e.SchedulerId != "capictl" || e.Message != "deploy" || e.Endpoint != "http://capi" {
+		t.Errorf("entry = %+v", e)
+	}
+	want := &Group{
+		GroupId:     "g1",
+		OperationId: "op-g1",
+		Owner:       "o",
+		Project:     "P",
+		Hosts:       []string{"a", "b"},
+		Etags:       map[string]int64{"a": 1, "b": 2},
+		Result:      Unknown,
+	}
+	if len(e.Groups) != 1 || !reflect.DeepEqual(e.Groups[0], want) {
+		t.Errorf("groups = %+v, want %+v", e.Groups, want)
+	}
+}
+
+func TestFinish(t *testing.T) {
+	tests := []struct {
+		name    string
+		failed  map[string]string
+		err     error
+		results []string
+		failure bool
+	}{
+		{"applied", nil, nil, []string{OK, OK}, false},
+		{"one group refused", map[string]string{"g2": "etag changed"}, nil, []string{OK, Failed}, true},
+		{"no answer", nil, errors.New("connection refused"), []string{Unknown, Unknown}, true},
+	}
+	for _, tt := range tests {
+		e := NewApply("http://capi", applyRequest("g1", "g2"))
+		e.Finish(tt.failed, tt.err)
+		results := []string{e.Groups[0].Result, e.Groups[1].Result}
+		if !reflect.DeepEqual(results, tt.results) {
+			t.Errorf("%s: results = %v, want %v", tt.name, results, tt.results)
+		}
+		if e.Failed() != tt.failure {
+			t.Errorf("%s: Failed() = %v", tt.name, e.Failed())
+		}
+	}
+	e := NewApply("http://capi", applyRequest("g1", "g2"))
+	e.Finish(map[string]string{"g2": "etag changed"}, nil)
+	if e.Groups[1].Error != "etag changed" {
+		t.Errorf("error of refused group = %q", e.Groups[1].Error)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Now()
+	e := NewDestroy("http://capi", &clusterapi.DestroyRequest{GroupsToDestroy: []*clusterapi.DestroyGroupRequest{{GroupId: "web-prod"}}})
+	e.Time = now
+	e.Operator = "alice"
+	e.Finish(nil, nil)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"empty", Filter{}, true},
+		{"operator", Filter{Operator: "alice"}, true},
+		{"other operator", Filter{Operator: "bob"}, false},
+		{"action", Filter{Action: Destroy}, true},
+		{"other action", Filter{Action: Apply}, false},
+		{"group glob", Filter{Group: "web-*"}, true},
+		{"other group", Filter{Group: "db-*"}, false},
+		{"since", Filter{Since: now}, true},
+		{"before since", Filter{Since: now.Add(time.Second)}, false},
+		{"until is exclusive", Filter{Until: now}, false},
+		{"before until", Filter{Until: now.Add(time.Second)}, true},
+		{"failed only", Filter{Failed: true}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Match(e); got != tt.want {
+			t.Errorf("%s: Match() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	j := Open(filepath.Join(dir, "sub", "audit.jsonl"))
+
+	entries, err := j.Read(Filter{})
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Read() of missing journal = %v, %v", entries, err)
+	}
+
+	applied := NewApply("http://capi", applyRequest("g1"))
+	applied.Finish(nil, nil)
+	refused := NewApply("http://capi", applyRequest("g2"))
+	refused.Finish(map[string]string{"g2": "etag changed"}, nil)
+	for _, e := range []*Entry{applied, refused} {
+		if err := j.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if entries, err = j.Read(Filter{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Groups[0].GroupId != "g1" || entries[1].Groups[0].GroupId != "g2" {
+		t.Errorf("Read() = %+v, want entries in journal order", entries)
+	}
+	if !reflect.DeepEqual(entries[1].Groups[0], refused.Groups[0]) {
+		t.Errorf("read group = %+v, want %+v", entries[1].Groups[0], refused.Groups[0])
+	}
+	if entries, err = j.Read(Filter{Failed: true}); err != nil || len(entries) != 1 || entries[0].Groups[0].GroupId != "g2" {
+		t.Errorf("Read(failed) = %+v, %v", entries, err)
+	}
+	if _, err := j.Read(Filter{Group: "["}); err == nil {
+		t.Error("bad group pattern accepted")
+	}
+
+	f, err := os.OpenFile(j.Path(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{broken\n")
+	f.Close()
+	if _, err := j.Read(Filter{}); err == nil {
+		t.Error("broken journal line accepted")
+	}
+}
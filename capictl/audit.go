@@ -0,0 +1,100 @@
+package main
+
+import (
+	"capi_tools/audit"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var auditPath = flag.String("audit", audit.DefaultPath(), "journal of applies and destroys, empty disables it")
+
+func auditJournal() *audit.Journal {
+	if *auditPath == "" {
+		return nil
+	}
+	return audit.Open(*auditPath)
+}
+
+// parseTime accepts RFC3339 time, date or duration back from now.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("bad time %q, want duration like 24h, 2006-01-02 or RFC3339", s)
+}
+
+func auditCmd(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	group := fs.String("group", "", "only entries touching groups matching glob")
+	operator := fs.String("user", "", "only entries of operator")
+	since := fs.String("since", "", "only entries after time or duration ago")
+	until := fs.String("until", "", "only entries before time or duration ago")
+	action := fs.String("action", "", "only apply or destroy entries")
+	failed := fs.Bool("failed", false, "only entries with failed groups")
+	asJSON := fs.Bool("json", false, "print entries as json lines")
+	fs.Parse(args)
+	if fs.NArg() != 0 || *auditPath == "" {
+		fmt.Fprintf(os.Stderr, "usage: capictl [-audit journal] audit [flags]\n")
+		os.Exit(2)
+	}
+
+	f := audit.Filter{Group: *group, Operator: *operator, Action: *action, Failed: *failed}
+	var err error
+	if f.Since, err = parseTime(*since); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	if f.Until, err = parseTime(*until); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	entries, err := auditJournal().Read(f)
+	if err != nil {
+		log.Fatalf("Failed to read audit journal %s: %v", *auditPath, err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			enc.Encode(e)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "TIME\tACTION\tUSER\tGROUP\tPROJECT\tHOSTS\tOPERATION\tDURATION\tRESULT\n")
+	for _, e := range entries {
+		operation := ""
+		if i := strings.Index(e.Message, "]"); strings.HasPrefix(e.Message, "[") && i > 0 {
+			operation = e.Message[1:i]
+		}
+		for _, g := range e.Groups {
+			if g.OperationId != "" {
+				operation = g.OperationId
+			}
+			result := g.Result
+			switch {
+			case g.Error != "":
+				result += ": " + g.Error
+			case e.Error != "":
+				result += ": " + e.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", e.Time.Format("2006-01-02 15:04:05"),
+				e.Action, e.Operator, g.GroupId, g.Project, len(g.Hosts), operation,
+				time.Duration(e.DurationMs)*time.Millisecond, result)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"capi_tools/rest"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func convertCmd(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	to := fs.String("to", "proto", "output format: proto or rest json")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintf(os.Stderr, "usage: capictl convert -to proto|rest <state.json> [out.json]\n")
+		os.Exit(2)
+	}
+
+	cstate, err := clusterState(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	var data []byte
+	switch *to {
+	case "proto":
+		data, err = json.MarshalIndent(cstate, "", "    ")
+	case "rest":
+		data, err = rest.Encode(cstate)
+	default:
+		log.Fatalf("unknown format %s, want proto or rest", *to)
+	}
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	if fs.NArg() == 1 {
+		os.Stdout.Write(append(data, '\n'))
+		return
+	}
+	if err := ioutil.WriteFile(fs.Arg(1), append(data, '\n'), 0644); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+}
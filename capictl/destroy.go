@@ -0,0 +1,153 @@
+package main
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/prompt"
+	"capi_tools/selector"
+	"capi_tools/spec"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// propertyFlags collects repeated -property name=glob flags.
+type propertyFlags struct {
+	s *selector.Selector
+}
+
+func (p propertyFlags) String() string {
+	if p.s == nil {
+		return ""
+	}
+	props := make([]string, 0, len(p.s.Properties))
+	for name, v := range p.s.Properties {
+		props = append(props, name+"="+v)
+	}
+	return strings.Join(props, ",")
+}
+
+func (p propertyFlags) Set(v string) error {
+	return p.s.ParseProperty(v)
+}
+
+// releaseGroups frees ip-broker endpoints of destroyed groups.
+func releaseGroups(groups []*selector.Group) {
+	if *ipBrokerURL == "" {
+		return
+	}
+	ib := ipBroker()
+	for _, g := range groups {
+		if g.Owner == nil {
+			continue
+		}
+		if err := ib.Release(g.Owner.ProjectId, g.GroupId, g.Hosts); err != nil {
+			log.Printf("failed to release endpoints of group %s: %v", g.GroupId, err)
+		}
+	}
+}
+
+func destroyCmd(args []string) {
+	sel := &selector.Selector{}
+	fs := flag.NewFlagSet("destroy", flag.ExitOnError)
+	taskF := fs.String("task", "", "destroy group of task.yaml")
+	fs.StringVar(&sel.Group, "group", "", "group id glob")
+	fs.StringVar(&sel.Owner, "owner", "", "owner id of workloads")
+	fs.StringVar(&sel.Project, "project", "", "project of workloads")
+	fs.Var(propertyFlags{sel}, "property", "workload property as name=glob, may repeat")
+	fs.StringVar(&sel.Filter, "filter", "", "capi workload filter expression")
+	dryRun := fs.Bool("dry-run", false, "only print groups that would be destroyed")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	confirmAbove := fs.Int("confirm-above", 1, "ask for confirmation when more groups are selected")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "usage: capictl destroy -task task.yaml | [-group glob] [-owner o] [-project p] [-property name=glob]... [-filter expr] [-dry-run] [-yes]\n")
+		os.Exit(2)
+	}
+	if *taskF != "" {
+		task, err := spec.Load(*taskF)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		sel.Group = task.GroupId()
+	}
+	if sel.Empty() {
+		log.Fatalf("refusing to destroy every group, give -task or a selector")
+	}
+	if err := sel.Validate(); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	c := newClient()
+	cstate, err := c.GetState(&clusterapi.GetStateRequest{WorkloadFilter: sel.Filter})
+	if err != nil {
+		log.Fatalf("Failed to get state on capi %s, reason: %v", *capiURL, err)
+	}
+	groups := selector.Select(cstate, sel)
+	if len(groups) == 0 {
+		log.Printf("no groups selected")
+		return
+	}
+	// capi filtered out workloads of selected groups the expression does not
+	// match, their hosts still need their endpoints released
+	if sel.Filter != "" {
+		full, err := c.GetState(&clusterapi.GetStateRequest{})
+		if err != nil {
+			log.Fatalf("Failed to get state on capi %s, reason: %v", *capiURL, err)
+		}
+		selector.Complete(full, groups)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "GROUP\tOWNER\tPROJECT\tHOSTS\tWORKLOADS\n")
+	for _, g := range groups {
+		owner, project := "", ""
+		if g.Owner != nil {
+			owner, project = g.Owner.OwnerId, g.Owner.ProjectId
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", g.GroupId, owner, project, len(g.Hosts), g.Workloads)
+	}
+	w.Flush()
+	if *dryRun {
+		return
+	}
+	if len(groups) > *confirmAbove && !*yes && !prompt.Confirm(fmt.Sprintf("destroy %d groups?", len(groups))) {
+		log.Fatalf("aborted")
+	}
+
+	operation := client.NewOperationId()
+	req := selector.DestroyRequest(groups)
+	req.SchedulerSignature = c.Sign(operation, fmt.Sprintf("destroy %d groups selected by %s", len(groups), sel))
+	resp, err := c.Destroy(req)
+	if err != nil {
+		log.Fatalf("Failed to destroy %d groups on capi %s, reason: %v", len(groups), *capiURL, err)
+	}
+
+	failed := make(map[string]bool)
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "GROUP\tRESULT\n")
+	for _, r := range resp.Results {
+		result := "destroyed"
+		if r.Exception != nil {
+			failed[r.GroupId] = true
+			result = "failed: " + client.DescribeException(r.Exception)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", r.GroupId, result)
+	}
+	w.Flush()
+
+	destroyed := make([]*selector.Group, 0, len(groups))
+	for _, g := range groups {
+		if !failed[g.GroupId] {
+			destroyed = append(destroyed, g)
+		}
+	}
+	releaseGroups(destroyed)
+	if len(failed) > 0 {
+		log.Fatalf("%d of %d groups were not destroyed, operation %s", len(failed), len(groups), operation)
+	}
+	log.Printf("%d groups destroyed, operation %s", len(groups), operation)
+}
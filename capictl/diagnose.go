@@ -0,0 +1,57 @@
+package main
+
+import (
+	"capi_tools/diagnosis"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func diagnoseCmd(args []string) {
+	fs := flag.NewFlagSet("diagnose", flag.ExitOnError)
+	host := fs.String("host", "", "only workloads on host")
+	all := fs.Bool("all", false, "show healthy workloads too")
+	asJSON := fs.Bool("json", false, "print json")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: capictl diagnose [-host h] [-all] [-json] <group>\n")
+		os.Exit(2)
+	}
+	group := fs.Arg(0)
+
+	current, err := newClient().GroupState(group)
+	if err != nil {
+		log.Fatalf("Failed to get state of group %s on capi %s, reason: %v", group, *capiURL, err)
+	}
+
+	result := make([]*diagnosis.Diagnosis, 0)
+	for _, d := range diagnosis.Group(group, current) {
+		if *host != "" && d.Host != *host {
+			continue
+		}
+		if !*all && d.Cause == diagnosis.Healthy {
+			continue
+		}
+		result = append(result, d)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		return
+	}
+	for _, d := range result {
+		fmt.Printf("%s [%s] %s: %s\n", d.Host, d.Severity, d.Cause, d.Explanation)
+		if d.Fix != "" {
+			fmt.Printf("    fix: %s\n", d.Fix)
+		}
+	}
+	if len(result) == 0 {
+		fmt.Printf("no problems found in group %s\n", group)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/disk"
+	"capi_tools/feedback"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func diskCmd(args []string) {
+	fs := flag.NewFlagSet("disk", flag.ExitOnError)
+	group := fs.String("group", "", "only workloads of group, whole cluster if empty")
+	asJSON := fs.Bool("json", false, "print json")
+	fs.Parse(args)
+
+	c := newClient()
+	var hosts []*clusterapi.Host
+	var err error
+	if *group != "" {
+		hosts, err = c.GroupState(*group)
+	} else {
+		var cstate *clusterapi.ClusterState
+		if cstate, err = c.GetState(&clusterapi.GetStateRequest{}); err == nil {
+			hosts = cstate.Hosts
+		}
+	}
+	if err != nil {
+		log.Fatalf("Failed to get state on capi %s, reason: %v", *capiURL, err)
+	}
+
+	r := disk.Build(hosts)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		return
+	}
+
+	if len(r.Hosts) == 0 {
+		fmt.Printf("no disk pressure\n")
+		return
+	}
+	fmt.Printf("%d hosts stuck on disk space, %s missing\n\n", r.StuckHosts, feedback.HumanBytes(r.Missing))
+	for _, h := range r.Hosts {
+		fmt.Printf("%s missing %s\n", h.Host, feedback.HumanBytes(h.Missing))
+		for _, b := range h.Blocked {
+			fmt.Printf("  waiting  %s %s: reserver %s needs %s, consumed %s, available %s of %s, %s must stay free\n",
+				b.Group, b.Service, b.Reserver, feedback.HumanBytes(b.Required), feedback.HumanBytes(b.Consumed),
+				feedback.HumanBytes(b.Available), feedback.HumanBytes(b.Total), feedback.HumanBytes(b.Reserve))
+		}
+		for _, cr := range h.Cached {
+			fmt.Printf("  cached   %s %s: reserver %s holds space, available %s\n",
+				cr.Group, cr.Service, cr.Reserver, feedback.HumanBytes(cr.Available))
+		}
+	}
+	fmt.Printf("\n%-30s %8s %8s %10s %12s\n", "RESERVER", "HOSTS", "BLOCKED", "MISSING", "CACHED_HOSTS")
+	for _, rs := range r.Reservers {
+		fmt.Printf("%-30s %8d %8d %10s %12d\n", rs.Reserver, rs.Hosts, rs.Blocked, feedback.HumanBytes(rs.Missing), rs.CachedHosts)
+	}
+}
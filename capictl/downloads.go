@@ -0,0 +1,85 @@
+package main
+
+import (
+	"capi_tools/client"
+	"capi_tools/downloads"
+	"capi_tools/feedback"
+	"capi_tools/watch"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+func downloadsCmd(args []string) {
+	fs := flag.NewFlagSet("downloads", flag.ExitOnError)
+	group := fs.String("group", "", "only workloads of group, whole cluster if empty")
+	follow := fs.Bool("f", false, "keep following and estimate throughput")
+	interval := fs.Duration("interval", 10*time.Second, "how often to report when following")
+	failing := fs.Int("failing", 3, "highlight resources failed on at least this many hosts")
+	asJSON := fs.Bool("json", false, "print json")
+	fs.Parse(args)
+
+	workloadFilter := ""
+	if *group != "" {
+		workloadFilter = client.GroupFilter(*group)
+	}
+	m := watch.New(newClient(), "", workloadFilter)
+	m.Timeout = *interval
+	tracker := downloads.NewTracker()
+	// keep the mirror in sync on every delta, report once per interval so
+	// rates cover the whole of it
+	var reported time.Time
+	for {
+		if _, err := m.Sync(); err != nil {
+			if !*follow {
+				log.Fatalf("Failed to get state on capi %s, reason: %v", *capiURL, err)
+			}
+			log.Printf("downloads: %v", err)
+			time.Sleep(*interval)
+			continue
+		}
+		now := time.Now()
+		if !reported.IsZero() && now.Sub(reported) < *interval {
+			continue
+		}
+		reported = now
+		ds := downloads.Collect(m.Workloads())
+		tracker.Observe(now, ds)
+		printDownloads(downloads.Summarize(ds), *failing, *asJSON)
+		if !*follow {
+			return
+		}
+	}
+}
+
+func printDownloads(r *downloads.Report, failing int, asJSON bool) {
+	if asJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(r); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		return
+	}
+
+	fmt.Printf("--- %s: %d in flight, %d failed, %d not ready, %s/s\n", time.Now().Format("15:04:05"),
+		len(r.InFlight), len(r.Failed), len(r.NotReady), feedback.HumanBytes(uint64(r.Rate)))
+	for _, res := range r.FailingOn(failing) {
+		fmt.Printf("! %s failed on %d hosts: %s\n", res.Resource, res.Failed, strings.Join(res.Reasons, "; "))
+	}
+	for _, d := range r.InFlight {
+		rate := ""
+		if d.Rate > 0 {
+			rate = fmt.Sprintf(", %s/s", feedback.HumanBytes(uint64(d.Rate)))
+		}
+		fmt.Printf("  %s %s %s: %s %s%s\n", d.Host, d.Group, d.Resource, d.State, feedback.Percent(d.Done, d.Total), rate)
+	}
+	for _, d := range r.Failed {
+		fmt.Printf("  %s %s %s: FAILED %s\n", d.Host, d.Group, d.Resource, d.Reason)
+	}
+	for _, d := range r.NotReady {
+		fmt.Printf("  %s %s %s: not ready, %s\n", d.Host, d.Group, d.Resource, d.State)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/drain"
+	"capi_tools/placement"
+	"capi_tools/rollout"
+	"capi_tools/watch"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// drainHosts resolves host ids and capi host filter expressions to host ids.
+func drainHosts(c *client.Client, args []string) ([]string, error) {
+	hosts := make([]string, 0, len(args))
+	for _, a := range args {
+		if !strings.ContainsAny(a, "'=|&") {
+			hosts = append(hosts, a)
+			continue
+		}
+		cstate, err := c.GetState(&clusterapi.GetStateRequest{HostFilter: a})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hosts matching %s: %v", a, err)
+		}
+		for _, h := range cstate.Hosts {
+			if h.Metadata != nil {
+				hosts = append(hosts, h.Metadata.Id)
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// evacuate plans and rolls out moves of our groups off hosts, returns number
+// of groups which could not be moved. Every group is planned right before its
+// rollout on its current state, so etags changed by the previous rollouts do
+// not fail it, while hosts taken by them stay reserved on one cluster.
+func evacuate(c *client.Client, cstate *clusterapi.ClusterState, hosts []string, s placement.Strategy,
+	timeout time.Duration, dryRun bool) int {
+	cluster := drain.NewCluster(cstate, hosts)
+	failed := 0
+	for _, group := range drain.Groups(cstate, hosts, *schedulerId) {
+		current := cstate
+		if !dryRun {
+			var err error
+			// new hosts have no workloads of the group, so the state is not
+			// filtered by it
+			current, err = c.GetState(&clusterapi.GetStateRequest{})
+			if err != nil {
+				log.Printf("Failed to get state on capi %s, reason: %v", *capiURL, err)
+				failed++
+				continue
+			}
+		}
+		e, err := drain.PlanGroup(cluster, current, group, hosts, s)
+		if err != nil {
+			log.Printf("error: %v", err)
+			failed++
+			continue
+		}
+		log.Printf("%s", e)
+		e.Plan.Endpoint = c.URL()
+		e.Plan.Print(os.Stdout)
+		if dryRun {
+			continue
+		}
+		if err := moveNetwork(e.Plan, e.Moves); err != nil {
+			log.Printf("Failed to allocate addresses for group %s: %v", e.Group, err)
+			failed++
+			continue
+		}
+		r := rollout.New(c, e.Plan, rollout.Options{
+			MaxUnavailable: 1,
+			MaxSurge:       len(e.Moves),
+			Timeout:        timeout,
+			OnFailure:      rollout.Abort,
+		})
+		if err := r.Run(); err != nil {
+			log.Printf("Failed to evacuate group %s on capi %s, reason: %v", e.Group, *capiURL, err)
+			failed++
+			continue
+		}
+		record(e.Plan)
+		releaseNetwork(e.Plan)
+		log.Printf("group %s evacuated, operation %s", e.Group, e.Plan.Transition.GroupOperationId)
+	}
+	return failed
+}
+
+func drainCmd(args []string) {
+	fs := flag.NewFlagSet("drain", flag.ExitOnError)
+	strategy := fs.String("strategy", "best_fit", "placement of moved replicas: first_fit, best_fit, worst_fit or random")
+	timeout := fs.Duration("timeout", 10*time.Minute, "time for moved replicas to become ACTIVE")
+	dryRun := fs.Bool("dry-run", false, "only print evacuation plans")
+	follow := fs.Bool("f", false, "keep running and drain hosts entering PREPARE_MAINTENANCE")
+	fs.Parse(args)
+	if *follow && fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "usage: capictl drain [-strategy s] [-dry-run] [<host|filter>... | -f]\n")
+		os.Exit(2)
+	}
+	strategies, err := placement.Strategies([]string{*strategy}, time.Now().UnixNano())
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	s := strategies[0]
+	c := newClient()
+
+	if !*follow {
+		cstate, err := c.GetState(&clusterapi.GetStateRequest{})
+		if err != nil {
+			log.Fatalf("Failed to get state on capi %s, reason: %v", *capiURL, err)
+		}
+		hosts := drain.Draining(cstate)
+		if fs.NArg() > 0 {
+			if hosts, err = drainHosts(c, fs.Args()); err != nil {
+				log.Fatalf("error: %v", err)
+			}
+		}
+		if len(drain.Groups(cstate, hosts, *schedulerId)) == 0 {
+			log.Printf("no groups to move off %d hosts", len(hosts))
+			return
+		}
+		if failed := evacuate(c, cstate, hosts, s, *timeout, *dryRun); failed > 0 {
+			log.Fatalf("%d groups were not evacuated", failed)
+		}
+		return
+	}
+
+	m := watch.New(c, "", "")
+	for {
+		changed, err := m.Sync()
+		if err != nil {
+			log.Printf("error: %v", err)
+			time.Sleep(m.Timeout)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		cstate := m.State()
+		hosts := drain.Draining(cstate)
+		if len(drain.Groups(cstate, hosts, *schedulerId)) == 0 {
+			continue
+		}
+		log.Printf("draining %s", strings.Join(hosts, ", "))
+		if failed := evacuate(c, cstate, hosts, s, *timeout, *dryRun); failed > 0 {
+			log.Printf("%d groups were not evacuated, retrying on next change", failed)
+		}
+	}
+}
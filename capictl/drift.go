@@ -0,0 +1,103 @@
+package main
+
+import (
+	"capi_tools/drift"
+	"capi_tools/reconcile"
+	"capi_tools/spec"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// loadSpecs reads task files and directories of task files by group id.
+func loadSpecs(paths []string) (map[string]*spec.Spec, map[string]string) {
+	specs := make(map[string]*spec.Spec)
+	files := make(map[string]string)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		if !info.IsDir() {
+			s, err := loadSpec(path)
+			if err != nil {
+				log.Fatalf("error: %v", err)
+			}
+			specs[s.GroupId()], files[s.GroupId()] = s, path
+			continue
+		}
+		dirSpecs, dirFiles, errs := reconcile.LoadDir(path)
+		for file, err := range errs {
+			log.Fatalf("error: %s: %v", file, err)
+		}
+		for g, s := range dirSpecs {
+			s.Scheduler = *schedulerId
+			specs[g], files[g] = s, dirFiles[g]
+		}
+	}
+	return specs, files
+}
+
+func driftCmd(args []string) {
+	fs := flag.NewFlagSet("drift", flag.ExitOnError)
+	statePath := fs.String("state", "", "snapshot or rest url instead of live state")
+	asJSON := fs.Bool("json", false, "print reports as json")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: capictl drift [-state snapshot] [-json] <task.yaml|dir>...\n")
+		os.Exit(2)
+	}
+
+	specs, files := loadSpecs(fs.Args())
+	cstate, err := clusterState(*statePath)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	groups := make([]string, 0, len(specs))
+	for g := range specs {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	reports := make([]*drift.Report, 0, len(groups))
+	drifted := 0
+	for _, g := range groups {
+		r := drift.Check(specs[g], files[g], cstate)
+		if r.Drifted() {
+			drifted++
+		}
+		reports = append(reports, r)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	} else {
+		for _, r := range reports {
+			if !r.Drifted() {
+				fmt.Printf("group %s (%s): in sync, %d hosts\n", r.Group, r.File, r.Hosts)
+				continue
+			}
+			fmt.Printf("group %s (%s): drifted\n", r.Group, r.File)
+			if r.Error != "" {
+				fmt.Printf("  error: %s\n", r.Error)
+			}
+			for _, f := range r.Findings {
+				fmt.Printf("  %s\n", f)
+				for _, c := range f.Fields {
+					fmt.Printf("      %s\n", c)
+				}
+			}
+		}
+	}
+	if drifted > 0 {
+		log.Printf("%d of %d groups drifted", drifted, len(reports))
+		os.Exit(1)
+	}
+}
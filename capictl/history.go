@@ -0,0 +1,129 @@
+package main
+
+import (
+	"capi_tools/history"
+	"capi_tools/plan"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+var historyDir = flag.String("history", history.DefaultDir(), "directory with applied group transitions")
+
+func historyStore() *history.Store {
+	return history.Open(*historyDir)
+}
+
+// record saves applied plan to local history, failure here must not fail the apply.
+func record(p *plan.Plan) {
+	r := history.NewRecord(p.Transition, p.Generation, p.Endpoint)
+	if err := historyStore().Add(r); err != nil {
+		log.Printf("failed to record group %s generation %s in history: %v", p.GroupId, p.Generation, err)
+	}
+}
+
+func historyCmd(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: capictl history <group>\n")
+		os.Exit(2)
+	}
+
+	records, err := historyStore().List(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to read history: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "GENERATION\tTIME\tAUTHOR\tHOSTS\tFINGERPRINT\tOPERATION\n")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", r.Generation, r.Time.Format("2006-01-02 15:04:05"),
+			r.Author, r.Hosts(), r.Fingerprint, r.OperationId)
+	}
+	w.Flush()
+}
+
+func diffCmd(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 3 {
+		fmt.Fprintf(os.Stderr, "usage: capictl diff <group> <gen1> <gen2>\n")
+		os.Exit(2)
+	}
+	group := fs.Arg(0)
+
+	store := historyStore()
+	a, err := store.Get(group, fs.Arg(1))
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	b, err := store.Get(group, fs.Arg(2))
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	old, new := a.Workloads(), b.Workloads()
+	hosts := make([]string, 0)
+	for h := range old {
+		hosts = append(hosts, h)
+	}
+	for h := range new {
+		if _, ok := old[h]; !ok {
+			hosts = append(hosts, h)
+		}
+	}
+	sort.Strings(hosts)
+
+	fmt.Printf("group %s, generation %s => %s\n", group, a.Generation, b.Generation)
+	for _, h := range hosts {
+		switch {
+		case new[h] == nil:
+			fmt.Printf("  - %s\n", h)
+		case old[h] == nil:
+			fmt.Printf("  + %s\n", h)
+		default:
+			changes := plan.CompareWorkloads(old[h], new[h])
+			if len(changes) == 0 {
+				continue
+			}
+			fmt.Printf("  ~ %s\n", h)
+			for _, c := range changes {
+				fmt.Printf("      %s\n", c)
+			}
+		}
+	}
+}
+
+func rollbackCmd(args []string) {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	to := fs.String("to", "", "generation to bring back")
+	dryRun := fs.Bool("dry-run", false, "only print the plan")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *to == "" {
+		fmt.Fprintf(os.Stderr, "usage: capictl rollback -to <generation> <group>\n")
+		os.Exit(2)
+	}
+	group := fs.Arg(0)
+
+	r, err := historyStore().Get(group, *to)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	c := newClient()
+	p, err := plan.BuildRestore(c, r.Transition)
+	if err != nil {
+		log.Fatalf("Failed to plan rollback of group %s on capi %s, reason: %v", group, *capiURL, err)
+	}
+	p.Message = fmt.Sprintf("rollback of group %s to generation %s", group, *to)
+	p.Print(os.Stdout)
+	if *dryRun || p.Empty() {
+		return
+	}
+
+	applyPlan(c, p)
+	log.Printf("group %s rolled back to content of generation %s as generation %s, operation %s", group, *to, p.Generation, p.Transition.GroupOperationId)
+}
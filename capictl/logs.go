@@ -0,0 +1,80 @@
+package main
+
+import (
+	"capi_tools/client"
+	"capi_tools/feedback"
+	"capi_tools/watch"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+func logsCmd(args []string) {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	host := fs.String("host", "", "only workloads on host")
+	follow := fs.Bool("f", false, "follow new process feedback")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: capictl logs [-host h] [-f] <group>\n")
+		os.Exit(2)
+	}
+	group := fs.Arg(0)
+
+	hostFilter := ""
+	if *host != "" {
+		hostFilter = client.HostsFilter([]string{*host})
+	}
+	m := watch.New(newClient(), hostFilter, client.GroupFilter(group))
+
+	// last printed feedback per workload and executable
+	printed := make(map[string]string)
+	for {
+		changed, err := m.Sync()
+		if err != nil {
+			if !*follow {
+				log.Fatalf("Failed to get state of group %s on capi %s, reason: %v", group, *capiURL, err)
+			}
+			log.Printf("group %s: %v", group, err)
+			time.Sleep(m.Timeout)
+			continue
+		}
+		if changed {
+			for _, wl := range m.Workloads() {
+				if client.WorkloadGroup(wl) != group || (*host != "" && client.WorkloadHost(wl) != *host) {
+					continue
+				}
+				for _, p := range feedback.Processes(wl) {
+					key := watch.Key(wl.Id) + "/" + p.Executable
+					text := formatProcess(p)
+					if printed[key] == text {
+						continue
+					}
+					printed[key] = text
+					fmt.Print(text)
+				}
+			}
+		}
+		if !*follow {
+			return
+		}
+	}
+}
+
+func formatProcess(p *feedback.Process) string {
+	var b strings.Builder
+	reported := ""
+	if !p.Reported.IsZero() {
+		reported = " at " + p.Reported.Format("2006-01-02 15:04:05")
+	}
+	fmt.Fprintf(&b, "=== %s %s gen %s%s: %s\n", p.Host, p.Service, p.Generation, reported, p.Summary())
+	if p.StdOut != "" {
+		fmt.Fprintf(&b, "--- stdout\n%s\n", strings.TrimRight(p.StdOut, "\n"))
+	}
+	if p.StdErr != "" {
+		fmt.Fprintf(&b, "--- stderr\n%s\n", strings.TrimRight(p.StdErr, "\n"))
+	}
+	return b.String()
+}
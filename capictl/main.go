@@ -0,0 +1,115 @@
+package main
+
+import (
+	"capi_tools/client"
+	"capi_tools/credentials"
+	"capi_tools/spec"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+var capiURL = flag.String("capi", "http://sit-dev-01-sas.haze.yandex.net:8081/proto/v0", "capi host url")
+var credentialsPath = flag.String("credentials", credentials.DefaultPath(), "token profiles config")
+var schedulerId = flag.String("scheduler-id", client.DefaultSchedulerId, "scheduler id workloads are written by and mutations are signed with")
+
+var creds *credentials.Config
+
+//var capi_url string "http://iss00-prestable.search.yandex.net:8082/proto/v0/state/full"
+
+type command struct {
+	usage string
+	run   func(args []string)
+}
+
+var commands = map[string]*command{
+	"plan":      {"plan -task task.yaml [-prepare] [-out plan.json]", planCmd},
+	"history":   {"history <group>", historyCmd},
+	"diff":      {"diff <group> <gen1> <gen2>", diffCmd},
+	"rollback":  {"rollback -to <generation> [-dry-run] <group>", rollbackCmd},
+	"activate":  {"activate [-dry-run] <group>", activateCmd},
+	"state":     {"state -to ACTIVE|PREPARED|REMOVED [-dry-run] <group> <host>...", stateCmd},
+	"status":    {"status [-diverged] <group>", statusCmd},
+	"wait":      {"wait [-state STATE] [-generation gen] [-timeout 30m] <group>", waitCmd},
+	"diagnose":  {"diagnose [-host h] [-all] [-json] <group>", diagnoseCmd},
+	"logs":      {"logs [-host h] [-f] <group>", logsCmd},
+	"disk":      {"disk [-group g] [-json]", diskCmd},
+	"downloads": {"downloads [-group g] [-f] [-interval 10s] [-failing n] [-json]", downloadsCmd},
+	"metrics":   {"metrics [-group g] [-listen :9101] [-graphite host:port] [-prefix capi]", metricsCmd},
+	"stats":     {"stats [-state state.json] [-metric ram_total,cpu_total] [-by all|location|health] [-format table|csv] [-out chart.svg|png]", statsCmd},
+	"convert":   {"convert -to proto|rest <state.json|rest url> [out.json]", convertCmd},
+	"snapshot":  {"snapshot save|load|diff ...", snapshotCmd},
+	"simulate":  {"simulate [-requests r.jsonl | -synthetic n] [-strategies first_fit,best_fit,...] <snapshot>...", simulateCmd},
+	"net":       {"net gc [-project p,...] [-grace 24h] [-dry-run] [-json]", netCmd},
+	"quota":     {"quota [-state snapshot] [-project p,...] [-json]", quotaCmd},
+	"destroy":   {"destroy -task task.yaml | [-group glob] [-owner o] [-project p] [-property name=glob]... [-filter expr] [-dry-run] [-yes]", destroyCmd},
+	"drain":     {"drain [-strategy s] [-timeout 10m] [-dry-run] [<host|filter>... | -f]", drainCmd},
+	"reconcile": {"reconcile [-listen addr] [-pull 1m] [-prune] [-dry-run] <dir>", reconcileCmd},
+	"drift":     {"drift [-state snapshot] [-json] <task.yaml|dir>...", driftCmd},
+	"audit":     {"audit [-group glob] [-user name] [-since 24h|time] [-until time] [-action apply|destroy] [-failed] [-json]", auditCmd},
+	"apply":     {"apply -task task.yaml [-prepare] | -plan plan.json [-rolling -max-unavailable n -max-surge n -on-failure abort|pause|rollback] [-wait]", applyCmd},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: capictl [flags] <command> [args]\n\nflags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(0)
+	}
+
+	// tokens must never reach logs
+	log.SetOutput(credentials.RedactWriter(os.Stderr))
+	var err error
+	if creds, err = credentials.Load(*credentialsPath); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	if err := creds.Check(); err != nil {
+		log.Fatalf("refusing to start: %v", err)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	cmd.run(flag.Args()[1:])
+}
+
+func newClient() *client.Client {
+	return client.New(*capiURL).
+		WithTransport(authTransport(credentials.Capi, *capiURL)).
+		WithSchedulerId(*schedulerId).
+		WithJournal(auditJournal())
+}
+
+// loadSpec reads task yaml, its workloads are written by -scheduler-id.
+func loadSpec(path string) (*spec.Spec, error) {
+	s, err := spec.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	s.Scheduler = *schedulerId
+	return s, nil
+}
+
+func authTransport(service, url string) *credentials.Transport {
+	return &credentials.Transport{Profile: creds.For(service, url)}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"capi_tools/client"
+	"capi_tools/graphite"
+	"capi_tools/metrics"
+	"capi_tools/watch"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func metricsCmd(args []string) {
+	fs := flag.NewFlagSet("metrics", flag.ExitOnError)
+	group := fs.String("group", "", "only workloads of group, whole cluster if empty")
+	listen := fs.String("listen", "", "serve prometheus metrics on address, e.g. :9101")
+	graphiteAddr := fs.String("graphite", "", "send metrics to graphite host:port")
+	prefix := fs.String("prefix", "capi", "metric name prefix")
+	resend := fs.Duration("resend", time.Minute, "send unchanged values to graphite this often")
+	fs.Parse(args)
+
+	workloadFilter := ""
+	if *group != "" {
+		workloadFilter = client.GroupFilter(*group)
+	}
+	m := watch.New(newClient(), "", workloadFilter)
+	store := metrics.NewStore()
+	sender := graphite.New(*graphiteAddr)
+	defer sender.Close()
+	changes := metrics.NewChanges(*resend)
+
+	if *listen == "" && *graphiteAddr == "" {
+		if _, err := m.Sync(); err != nil {
+			log.Fatalf("Failed to get state on capi %s, reason: %v", *capiURL, err)
+		}
+		store.Add(extract(m))
+		metrics.WritePrometheus(os.Stdout, *prefix, store.Series())
+		return
+	}
+
+	if *listen != "" {
+		http.Handle("/metrics", metrics.Handler(store, *prefix))
+		go func() {
+			log.Fatal(http.ListenAndServe(*listen, nil))
+		}()
+	}
+	for {
+		changed, err := m.Sync()
+		if err != nil {
+			log.Printf("metrics: %v", err)
+			time.Sleep(m.Timeout)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		samples := extract(m)
+		store.Add(samples)
+		live := make(map[metrics.Labels]bool)
+		for _, s := range samples {
+			live[s.Labels] = true
+		}
+		store.Retain(live)
+
+		if *graphiteAddr != "" {
+			changes.Retain(live)
+			if err := sender.Send(metrics.GraphiteLines(*prefix, changes.Filter(samples))); err != nil {
+				log.Printf("metrics: %v", err)
+			}
+		}
+	}
+}
+
+func extract(m *watch.Mirror) []*metrics.Sample {
+	samples := make([]*metrics.Sample, 0)
+	for _, wl := range m.Workloads() {
+		samples = append(samples, metrics.Extract(wl)...)
+	}
+	return samples
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/credentials"
+	"capi_tools/ipbroker"
+	"capi_tools/plan"
+	"capi_tools/spec"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var ipBrokerURL = flag.String("ip-broker", "", "allocate workload addresses in ip-broker at url, e.g. "+ipbroker.DefaultURL)
+
+func ipBroker() *ipbroker.Client {
+	return ipbroker.New(*ipBrokerURL).WithTransport(authTransport(credentials.IpBroker, *ipBrokerURL))
+}
+
+// assignNetwork allocates an ip-broker endpoint per task host and passes its
+// address and fqdn to workloads as properties.
+func assignNetwork(c *client.Client, task *spec.Spec) error {
+	return setNetwork(c, task, ipBroker().Ensure)
+}
+
+// lookupNetwork passes addresses of endpoints already allocated for task
+// hosts to workloads, for previews which must not allocate anything.
+func lookupNetwork(c *client.Client, task *spec.Spec) error {
+	return setNetwork(c, task, ipBroker().Lookup)
+}
+
+func setNetwork(c *client.Client, task *spec.Spec,
+	endpoints func(project, group string, hosts []string) (map[string]*ipbroker.Endpoint, error)) error {
+	if *ipBrokerURL == "" {
+		return nil
+	}
+	if task.ProjectId == "" {
+		return fmt.Errorf("project_id must be set in task to allocate addresses in ip-broker")
+	}
+	hosts := task.Hosts
+	if len(hosts) == 0 {
+		current, err := c.GroupState(task.GroupId())
+		if err != nil {
+			return fmt.Errorf("failed to get state of group %s: %v", task.GroupId(), err)
+		}
+		for _, h := range current {
+			hosts = append(hosts, h.Metadata.Id)
+		}
+	}
+
+	found, err := endpoints(task.ProjectId, task.GroupId(), hosts)
+	if err != nil {
+		return err
+	}
+	task.HostProperties = make(map[string]map[string]string)
+	for h, e := range found {
+		task.HostProperties[h] = e.Properties()
+	}
+	return nil
+}
+
+// moveNetwork allocates endpoints for hosts replicas of p are moved to and
+// replaces addresses the workloads carried from their old hosts.
+func moveNetwork(p *plan.Plan, moves map[string]string) error {
+	hosts := make([]string, 0, len(moves))
+	for _, h := range moves {
+		hosts = append(hosts, h)
+	}
+	return ensureNetwork(p, hosts)
+}
+
+// completeNetwork allocates endpoints for hosts of p whose workloads got no
+// address because plans only look addresses up, returns those hosts.
+func completeNetwork(p *plan.Plan) ([]string, error) {
+	if *ipBrokerURL == "" || p.Transition.Owner == nil {
+		return nil, nil
+	}
+	hosts := make([]string, 0)
+	for _, t := range p.Transition.Transitions {
+		for _, wl := range t.Workloads {
+			if _, ok := wl.Properties[ipbroker.PropAddress]; !ok {
+				hosts = append(hosts, t.HostId)
+				break
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	return hosts, ensureNetwork(p, hosts)
+}
+
+// printNetwork writes addresses workloads of p got on hosts.
+func printNetwork(w io.Writer, p *plan.Plan, hosts []string) {
+	wanted := make(map[string]bool)
+	for _, h := range hosts {
+		wanted[h] = true
+	}
+	fmt.Fprintf(w, "addresses allocated for group %s:\n", p.GroupId)
+	for _, t := range p.Transition.Transitions {
+		if !wanted[t.HostId] || len(t.Workloads) == 0 {
+			continue
+		}
+		props := t.Workloads[0].Properties
+		fmt.Fprintf(w, "  %s: %s=%s %s=%s\n", t.HostId,
+			ipbroker.PropAddress, props[ipbroker.PropAddress], ipbroker.PropHostname, props[ipbroker.PropHostname])
+	}
+}
+
+// ensureNetwork allocates endpoints of p's group on hosts and sets their
+// addresses on workloads of those hosts.
+func ensureNetwork(p *plan.Plan, hosts []string) error {
+	if *ipBrokerURL == "" || p.Transition.Owner == nil {
+		return nil
+	}
+	endpoints, err := ipBroker().Ensure(p.Transition.Owner.ProjectId, p.GroupId, hosts)
+	if err != nil {
+		return err
+	}
+	for _, t := range p.Transition.Transitions {
+		e, ok := endpoints[t.HostId]
+		if !ok {
+			continue
+		}
+		for _, wl := range t.Workloads {
+			if wl.Properties == nil {
+				wl.Properties = make(map[string]string)
+			}
+			for k, v := range e.Properties() {
+				wl.Properties[k] = v
+			}
+		}
+	}
+	return nil
+}
+
+// releaseNetwork frees endpoints of hosts p removed the group from, failure
+// leaves the endpoints to net gc and must not fail the apply.
+func releaseNetwork(p *plan.Plan) {
+	releaseEndpoints(p, removedHosts(p))
+}
+
+// releaseEndpoints frees endpoints of p's group on hosts, failure is only
+// logged.
+func releaseEndpoints(p *plan.Plan, hosts []string) {
+	if *ipBrokerURL == "" || len(hosts) == 0 || p.Transition.Owner == nil {
+		return
+	}
+	if err := ipBroker().Release(p.Transition.Owner.ProjectId, p.GroupId, hosts); err != nil {
+		log.Printf("failed to release endpoints of group %s: %v", p.GroupId, err)
+	}
+}
+
+func netCmd(args []string) {
+	if len(args) == 0 || args[0] != "gc" {
+		fmt.Fprintf(os.Stderr, "usage: capictl [-ip-broker url] net gc [-project p,...] [-grace 24h] [-dry-run] [-json]\n")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("net gc", flag.ExitOnError)
+	projects := fs.String("project", "", "comma separated projects to collect, all if empty")
+	grace := fs.Duration("grace", 24*time.Hour, "keep unused endpoints younger than this")
+	dryRun := fs.Bool("dry-run", false, "only report what would be deleted")
+	asJSON := fs.Bool("json", false, "print per project counts as json")
+	fs.Parse(args[1:])
+
+	if *ipBrokerURL == "" {
+		*ipBrokerURL = ipbroker.DefaultURL
+	}
+	ib := ipBroker()
+	endpoints, err := ib.List("")
+	if err != nil {
+		log.Fatalf("Failed to list endpoints on ip-broker %s, reason: %v", ib.URL(), err)
+	}
+	if *projects != "" {
+		wanted := make(map[string]bool)
+		for _, p := range strings.Split(*projects, ",") {
+			wanted[p] = true
+		}
+		selected := make([]*ipbroker.Endpoint, 0)
+		for _, e := range endpoints {
+			if wanted[e.ProjectId] {
+				selected = append(selected, e)
+			}
+		}
+		endpoints = selected
+	}
+
+	// addresses are checked against the whole cluster, a workload of any
+	// group keeps its endpoint alive
+	cstate, err := newClient().GetState(&clusterapi.GetStateRequest{})
+	if err != nil {
+		log.Fatalf("Failed to get state on capi %s, reason: %v", *capiURL, err)
+	}
+	leaks := ipbroker.Leaks(endpoints, ipbroker.InUse(cstate), *grace, time.Now())
+
+	stats := make(map[string]*ipbroker.ProjectGC)
+	project := func(id string) *ipbroker.ProjectGC {
+		if stats[id] == nil {
+			stats[id] = &ipbroker.ProjectGC{ProjectId: id}
+		}
+		return stats[id]
+	}
+	for _, e := range endpoints {
+		project(e.ProjectId).Total++
+	}
+	for _, l := range leaks {
+		s := project(l.ProjectId)
+		if l.Grace {
+			s.InGrace++
+			continue
+		}
+		s.Leaked++
+		if *dryRun {
+			if !*asJSON {
+				fmt.Printf("would delete %s %s %s %s, age %s\n", l.ProjectId, l.Id, l.Address, l.Fqdn, l.Age.Truncate(time.Second))
+			}
+			continue
+		}
+		if err := ib.Delete(l.Id); err != nil {
+			log.Printf("failed to delete endpoint %s of %s: %v", l.Id, l.ProjectId, err)
+			s.Failed++
+			continue
+		}
+		s.Deleted++
+		if !*asJSON {
+			fmt.Printf("deleted %s %s %s %s, age %s\n", l.ProjectId, l.Id, l.Address, l.Fqdn, l.Age.Truncate(time.Second))
+		}
+	}
+
+	result := make([]*ipbroker.ProjectGC, 0, len(stats))
+	for _, s := range stats {
+		s.Live = s.Total - s.Leaked - s.InGrace
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ProjectId < result[j].ProjectId })
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "PROJECT\tTOTAL\tLIVE\tLEAKED\tIN GRACE\tDELETED\tFAILED\n")
+	for _, s := range result {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", s.ProjectId, s.Total, s.Live, s.Leaked, s.InGrace, s.Deleted, s.Failed)
+	}
+	w.Flush()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"capi_tools/client"
+	"capi_tools/plan"
+	"capi_tools/prompt"
+	"capi_tools/rollout"
+	"capi_tools/spec"
+	"capi_tools/wait"
+	"flag"
+	"log"
+	"os"
+	"time"
+)
+
+func planCmd(args []string) {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	taskF := fs.String("task", "", "path to task.yaml")
+	out := fs.String("out", "", "save plan to file for apply -plan")
+	prepare := fs.Bool("prepare", false, "create new version PREPARED next to the running one")
+	fs.Parse(args)
+	if *taskF == "" {
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	task, err := loadSpec(*taskF)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	p, err := buildPlan(newClient(), task, *prepare)
+	if err != nil {
+		log.Fatalf("Failed to plan task on capi %s, reason: %v", *capiURL, err)
+	}
+	p.Print(os.Stdout)
+
+	if *out != "" {
+		if err := p.Save(*out); err != nil {
+			log.Fatalf("Failed to save plan to %s: %v", *out, err)
+		}
+		log.Printf("plan saved to %s", *out)
+	}
+}
+
+func applyCmd(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	taskF := fs.String("task", "", "path to task.yaml")
+	planF := fs.String("plan", "", "apply plan saved by capictl plan -out")
+	prepare := fs.Bool("prepare", false, "create new version PREPARED next to the running one, see activate")
+	rolling := fs.Bool("rolling", false, "update hosts in batches waiting for each to become ACTIVE")
+	maxUnavailable := fs.Int("max-unavailable", 1, "rolling: hosts updated at once")
+	maxSurge := fs.Int("max-surge", 0, "rolling: new hosts brought up before existing are updated")
+	batchTimeout := fs.Duration("batch-timeout", 10*time.Minute, "rolling: time for a batch to become ACTIVE")
+	onFailure := fs.String("on-failure", rollout.Abort, "rolling: abort, pause or rollback when a batch fails")
+	waitF := fs.Bool("wait", false, "wait until applied workloads reach their target state")
+	waitTimeout := fs.Duration("wait-timeout", 30*time.Minute, "give up waiting after")
+	yes := fs.Bool("yes", false, "apply a saved plan without confirming addresses allocated for it")
+	fs.Parse(args)
+	if (*taskF == "") == (*planF == "") {
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	switch *onFailure {
+	case rollout.Abort, rollout.Pause, rollout.Rollback:
+	default:
+		log.Fatalf("bad -on-failure %s, want abort, pause or rollback", *onFailure)
+	}
+	if *prepare && *rolling {
+		log.Fatalf("-prepare and -rolling can not be used together")
+	}
+
+	c := newClient()
+	var p *plan.Plan
+	if *planF != "" {
+		var err error
+		if p, err = plan.Load(*planF); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		if p.Endpoint != "" && p.Endpoint != c.URL() {
+			log.Fatalf("plan was made against %s, not %s", p.Endpoint, c.URL())
+		}
+	} else {
+		task, err := loadSpec(*taskF)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		if p, err = buildPlan(c, task, *prepare); err != nil {
+			log.Fatalf("Failed to plan task on capi %s, reason: %v", *capiURL, err)
+		}
+	}
+	p.Print(os.Stdout)
+
+	if p.Empty() {
+		log.Printf("nothing to apply for group %s", p.GroupId)
+		return
+	}
+	// plans only look addresses up, missing ones are allocated once quota
+	// and etags are checked so a refused plan leaves nothing in ip-broker
+	checkPlan(c, p)
+	allocated, err := completeNetwork(p)
+	if err != nil {
+		log.Fatalf("Failed to allocate addresses for group %s: %v", p.GroupId, err)
+	}
+	if len(allocated) > 0 {
+		printNetwork(os.Stdout, p, allocated)
+		// a saved plan was reviewed without these addresses
+		if *planF != "" && !*yes && !prompt.Confirm("apply the saved plan with the addresses above?") {
+			releaseEndpoints(p, allocated)
+			log.Fatalf("group %s not applied", p.GroupId)
+		}
+	}
+	if *rolling {
+		r := rollout.New(c, p, rollout.Options{
+			MaxUnavailable: *maxUnavailable,
+			MaxSurge:       *maxSurge,
+			Timeout:        *batchTimeout,
+			OnFailure:      *onFailure,
+		})
+		if err := r.Run(); err != nil {
+			log.Fatalf("Failed to roll out group %s on capi %s, reason: %v", p.GroupId, *capiURL, err)
+		}
+		record(p)
+		releaseNetwork(p)
+		log.Printf("group %s rolled out, generation %s, operation %s", p.GroupId, p.Generation, p.Transition.GroupOperationId)
+		return
+	}
+	applyChecked(c, p)
+	log.Printf("group %s applied, generation %s, operation %s", p.GroupId, p.Generation, p.Transition.GroupOperationId)
+
+	if *waitF {
+		err := wait.Group(c, p.GroupId, wait.Options{
+			Generation: p.Generation,
+			Removed:    removedHosts(p),
+			Timeout:    *waitTimeout,
+			Out:        os.Stdout,
+		})
+		if err != nil {
+			log.Fatalf("group %s did not reach target state: %v", p.GroupId, err)
+		}
+		log.Printf("group %s reached target state", p.GroupId)
+	}
+}
+
+func removedHosts(p *plan.Plan) []string {
+	hosts := make([]string, 0)
+	for _, c := range p.Changes {
+		if c.Action == plan.Remove {
+			hosts = append(hosts, c.Host)
+		}
+	}
+	return hosts
+}
+
+// buildPlan plans task with addresses of endpoints already allocated for its
+// hosts, completeNetwork allocates the rest once the plan is to be applied.
+func buildPlan(c *client.Client, task *spec.Spec, prepare bool) (*plan.Plan, error) {
+	if err := lookupNetwork(c, task); err != nil {
+		return nil, err
+	}
+	if prepare {
+		return plan.BuildPrepare(c, task)
+	}
+	return plan.Build(c, task)
+}
+
+// applyPlan checks p with checkPlan, applies it and records it in history,
+// exits on failure.
+func applyPlan(c *client.Client, p *plan.Plan) {
+	checkPlan(c, p)
+	applyChecked(c, p)
+}
+
+// checkPlan exits if p is over quota or its hosts changed since it was made.
+func checkPlan(c *client.Client, p *plan.Plan) {
+	checkQuota(p)
+	hosts, err := c.HostsState(p.Hosts())
+	if err == nil {
+		err = p.Check(hosts)
+	}
+	if err != nil {
+		log.Fatalf("Failed to apply group %s on capi %s, reason: %v", p.GroupId, *capiURL, err)
+	}
+}
+
+// applyChecked applies p passed checkPlan and records it in history, exits
+// on failure.
+func applyChecked(c *client.Client, p *plan.Plan) {
+	if err := plan.Apply(c, p); err != nil {
+		log.Fatalf("Failed to apply group %s on capi %s, reason: %v", p.GroupId, *capiURL, err)
+	}
+	record(p)
+	releaseNetwork(p)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"capi_tools/plan"
+	"capi_tools/quota"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var quotaPath = flag.String("quota", quota.DefaultPath(), "per project resource limits")
+var quotaCheck = flag.String("quota-check", "refuse", "before applying a group over quota: refuse, warn or off")
+
+func loadQuota() *quota.Config {
+	q, err := quota.Load(*quotaPath)
+	if err != nil {
+		log.Fatalf("Failed to load quota: %v", err)
+	}
+	return q
+}
+
+// checkQuota exits if p takes its project over limits, only warns with
+// -quota-check warn.
+func checkQuota(p *plan.Plan) {
+	switch *quotaCheck {
+	case "off":
+		return
+	case "refuse", "warn":
+	default:
+		log.Fatalf("bad -quota-check %s, want refuse, warn or off", *quotaCheck)
+	}
+	q := loadQuota()
+	if p.Transition.Owner == nil {
+		return
+	}
+	if _, ok := q.Limits(p.Transition.Owner.ProjectId); !ok {
+		return
+	}
+
+	cstate, err := clusterState("")
+	if err == nil {
+		_, err = q.Check(cstate, p.Transition)
+	}
+	if err == nil {
+		return
+	}
+	if *quotaCheck == "warn" {
+		log.Printf("warning: quota check: %v", err)
+		return
+	}
+	log.Fatalf("refusing to apply: %v", err)
+}
+
+func formatAmount(resource string, v uint64) string {
+	if resource == quota.Cpu {
+		return fmt.Sprintf("%d", v)
+	}
+	return fmt.Sprintf("%.1fG", float64(v)/(1<<30))
+}
+
+func quotaCmd(args []string) {
+	fs := flag.NewFlagSet("quota", flag.ExitOnError)
+	statePath := fs.String("state", "", "snapshot or rest url instead of live state")
+	projects := fs.String("project", "", "comma separated projects, all by default")
+	asJSON := fs.Bool("json", false, "print report as json")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "usage: capictl quota [-state snapshot] [-project p,...] [-json]\n")
+		os.Exit(2)
+	}
+
+	q := loadQuota()
+	cstate, err := clusterState(*statePath)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	var names []string
+	if *projects != "" {
+		names = strings.Split(*projects, ",")
+	}
+	report := q.Report(cstate, names)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "PROJECT\tRESOURCE\tUSED\tLIMIT\tUSAGE\n")
+	for _, l := range report {
+		limit, usage := "-", "-"
+		if l.Limit > 0 {
+			limit = formatAmount(l.Resource, l.Limit)
+			usage = fmt.Sprintf("%.0f%%", l.Percent())
+			if l.Over() {
+				usage += " OVER"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", l.Project, l.Resource, formatAmount(l.Resource, l.Used), limit, usage)
+	}
+	w.Flush()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"capi_tools/history"
+	"capi_tools/placement"
+	"capi_tools/plan"
+	"capi_tools/reconcile"
+	"capi_tools/spec"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func reconcileCmd(args []string) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8090", "serve group status on address, empty disables it")
+	interval := fs.Duration("interval", 5*time.Minute, "reconcile all groups at least this often")
+	cooldown := fs.Duration("cooldown", time.Minute, "leave a group alone after changing it")
+	pull := fs.Duration("pull", 0, "git pull the directory this often, 0 disables it")
+	prune := fs.Bool("prune", false, "destroy groups no file defines any more")
+	dryRun := fs.Bool("dry-run", false, "only report drift, change nothing")
+	strategy := fs.String("strategy", "best_fit", "placement of new replicas: first_fit, best_fit, worst_fit or random")
+	statePath := fs.String("state", filepath.Join(filepath.Dir(history.DefaultDir()), "reconcile.json"), "groups managed by the reconciler")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: capictl reconcile [-listen addr] [-pull 1m] [-prune] [-dry-run] <dir>\n")
+		os.Exit(2)
+	}
+	strategies, err := placement.Strategies([]string{*strategy}, time.Now().UnixNano())
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	c := newClient()
+	r, err := reconcile.New(c, reconcile.Options{
+		Dir:          fs.Arg(0),
+		PullInterval: *pull,
+		Interval:     *interval,
+		Cooldown:     *cooldown,
+		Prune:        *prune,
+		DryRun:       *dryRun,
+		StatePath:    *statePath,
+		Strategy:     strategies[0],
+		BeforePlan: func(s *spec.Spec) error {
+			return lookupNetwork(c, s)
+		},
+		BeforeApply: func(s *spec.Spec) error {
+			return assignNetwork(c, s)
+		},
+		AfterApply: func(p *plan.Plan) {
+			record(p)
+			releaseNetwork(p)
+		},
+	})
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	if *listen != "" {
+		http.Handle("/status", reconcile.Handler(r))
+		http.Handle("/status/", reconcile.Handler(r))
+		go func() {
+			log.Fatal(http.ListenAndServe(*listen, nil))
+		}()
+	}
+	log.Printf("reconciling %s against capi %s", fs.Arg(0), *capiURL)
+	r.Run()
+}
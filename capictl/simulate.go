@@ -0,0 +1,87 @@
+package main
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/placement"
+	"capi_tools/simulate"
+	"capi_tools/snapshot"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func simulateCmd(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	requestsFile := fs.String("requests", "", "json lines file with requests {group, replicas, resources: {cpu, ram, disk}, anti_affinity}")
+	synthetic := fs.Int("synthetic", 0, "generate this many synthetic requests")
+	seed := fs.Int64("seed", 1, "seed for synthetic requests and random strategy")
+	strategies := fs.String("strategies", "first_fit,best_fit,worst_fit,random", "comma separated strategies to compare")
+	antiAffinity := fs.String("anti-affinity", placement.Host, "anti-affinity of generated and recorded requests: none, host or rack")
+	asJSON := fs.Bool("json", false, "print json")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: capictl simulate [-requests r.jsonl | -synthetic n] [-strategies ...] <snapshot> [snapshot...]\n\n"+
+			"requests are replayed on the first snapshot, with several snapshots and no\n"+
+			"-requests/-synthetic workloads created between them are replayed\n")
+		os.Exit(2)
+	}
+	switch *antiAffinity {
+	case placement.None, placement.Host, placement.Rack:
+	default:
+		log.Fatalf("bad anti-affinity %s, want none, host or rack", *antiAffinity)
+	}
+
+	states := make([]*clusterapi.ClusterState, 0, fs.NArg())
+	for _, path := range fs.Args() {
+		s, err := snapshot.Load(path)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		states = append(states, s.State)
+	}
+
+	var requests []*placement.Request
+	switch {
+	case *requestsFile != "":
+		var err error
+		if requests, err = simulate.LoadRequests(*requestsFile); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	case *synthetic > 0:
+		requests = simulate.Synthetic(*synthetic, *seed, *antiAffinity)
+	case len(states) > 1:
+		requests = simulate.Recorded(states, *antiAffinity)
+	default:
+		log.Fatalf("nothing to replay: give -requests, -synthetic or several snapshots")
+	}
+
+	ss, err := placement.Strategies(strings.Split(*strategies, ","), *seed)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	cluster := placement.NewCluster(states[0])
+	reports := make([]*simulate.Report, 0, len(ss))
+	for _, s := range ss {
+		reports = append(reports, simulate.Run(cluster, requests, s))
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		return
+	}
+	fmt.Printf("%d requests on %d hosts\n\n", len(requests), len(cluster.Nodes))
+	fmt.Printf("%-10s %7s %8s %9s %9s %10s %6s %6s %6s %6s\n",
+		"STRATEGY", "PLACED", "REJECTED", "REJECT%", "REPLICAS", "VIOLATION", "CPU%", "RAM%", "FRAG%", "HOSTS")
+	for _, r := range reports {
+		fmt.Printf("%-10s %7d %8d %8.1f%% %9d %10d %5.1f%% %5.1f%% %5.1f%% %6d\n",
+			r.Strategy, r.Placed, r.Rejected, r.RejectionRate*100, r.Replicas, r.Violations,
+			r.CpuUtil*100, r.RamUtil*100, r.Fragmentation*100, r.HostsUsed)
+	}
+}
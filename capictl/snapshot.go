@@ -0,0 +1,162 @@
+package main
+
+import (
+	"capi_tools/snapshot"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func snapshotCmd(args []string) {
+	if len(args) == 0 {
+		snapshotUsage()
+	}
+	switch args[0] {
+	case "save":
+		snapshotSave(args[1:])
+	case "load":
+		snapshotLoad(args[1:])
+	case "diff":
+		snapshotDiff(args[1:])
+	default:
+		snapshotUsage()
+	}
+}
+
+func snapshotUsage() {
+	fmt.Fprintf(os.Stderr, "usage: capictl snapshot save [-state src] [-out file.json.gz]\n"+
+		"       capictl snapshot load [-json] <file>\n"+
+		"       capictl snapshot diff [-json] <a> <b>\n")
+	os.Exit(2)
+}
+
+func snapshotSave(args []string) {
+	fs := flag.NewFlagSet("snapshot save", flag.ExitOnError)
+	stateFile := fs.String("state", "", "state json or rest url to snapshot, live state if empty")
+	out := fs.String("out", "", "snapshot file, "+snapshot.DefaultDir()+"/cluster_state_<ts>.json.gz if empty")
+	fs.Parse(args)
+
+	cstate, err := clusterState(*stateFile)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	endpoint := *stateFile
+	if endpoint == "" {
+		endpoint = *capiURL
+	}
+	s := snapshot.New(endpoint, cstate)
+	path := *out
+	if path == "" {
+		path = filepath.Join(snapshot.DefaultDir(), s.FileName())
+	}
+	if err := snapshot.Save(path, s); err != nil {
+		log.Fatalf("Failed to save snapshot %s: %v", path, err)
+	}
+	fmt.Printf("saved %d hosts to %s\n", s.Hosts, path)
+}
+
+func snapshotLoad(args []string) {
+	fs := flag.NewFlagSet("snapshot load", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print state json")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		snapshotUsage()
+	}
+
+	s, err := snapshot.Load(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(s.State); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		return
+	}
+	workloads := 0
+	for _, h := range s.State.Hosts {
+		workloads += len(h.Workloads)
+	}
+	fmt.Printf("endpoint:  %s\ntaken:     %s\nhosts:     %d\nworkloads: %d\n",
+		s.Endpoint, s.Taken.Format("2006-01-02 15:04:05"), len(s.State.Hosts), workloads)
+	if s.Version != nil {
+		clusters := make([]string, 0, len(s.Version.Versions))
+		for c, v := range s.Version.Versions {
+			clusters = append(clusters, fmt.Sprintf("%s=%d", c, v))
+		}
+		sort.Strings(clusters)
+		fmt.Printf("version:   %s\n", strings.Join(clusters, " "))
+	}
+}
+
+func snapshotDiff(args []string) {
+	fs := flag.NewFlagSet("snapshot diff", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print json")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		snapshotUsage()
+	}
+
+	a, err := snapshot.Load(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	b, err := snapshot.Load(fs.Arg(1))
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	d := snapshot.Compare(a.State, b.State)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		return
+	}
+	fmt.Printf("--- %s %s\n+++ %s %s\n", fs.Arg(0), a.Taken.Format("2006-01-02 15:04:05"), fs.Arg(1), b.Taken.Format("2006-01-02 15:04:05"))
+	if d.Empty() {
+		fmt.Printf("no changes\n")
+		return
+	}
+	for _, h := range d.HostsAdded {
+		fmt.Printf("+ host %s\n", h)
+	}
+	for _, h := range d.HostsRemoved {
+		fmt.Printf("- host %s\n", h)
+	}
+	for _, h := range d.Health {
+		fmt.Printf("~ host %s health %s => %s\n", h.Host, h.Old, h.New)
+	}
+	for _, r := range d.Resources {
+		for _, c := range r.Changes {
+			fmt.Printf("~ host %s %s\n", r.Host, c)
+		}
+	}
+	for _, g := range d.Groups {
+		kinds := make([]string, 0, len(g.Changes))
+		for k, n := range g.Changes {
+			kinds = append(kinds, fmt.Sprintf("%d %s", n, k))
+		}
+		sort.Strings(kinds)
+		fmt.Printf("group %s owner %s: %s\n", g.Group, g.Owner, strings.Join(kinds, ", "))
+	}
+	for _, w := range d.Workloads {
+		mark := "~"
+		switch w.Change {
+		case snapshot.Created:
+			mark = "+"
+		case snapshot.Removed:
+			mark = "-"
+		}
+		fmt.Printf("  %s %s %s %s %s => %s\n", mark, w.Group, w.Host, w.Service, w.Old, w.New)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"capi_tools/plan"
+	"capi_tools/wait"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func activateCmd(args []string) {
+	fs := flag.NewFlagSet("activate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only print the plan")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: capictl activate [-dry-run] <group>\n")
+		os.Exit(2)
+	}
+	group := fs.Arg(0)
+
+	c := newClient()
+	p, err := plan.BuildActivate(c, group)
+	if err != nil {
+		log.Fatalf("Failed to plan activation of group %s on capi %s, reason: %v", group, *capiURL, err)
+	}
+	p.Print(os.Stdout)
+	if *dryRun || p.Empty() {
+		return
+	}
+	applyPlan(c, p)
+	log.Printf("group %s generation %s activated, operation %s", group, p.Generation, p.Transition.GroupOperationId)
+}
+
+func stateCmd(args []string) {
+	fs := flag.NewFlagSet("state", flag.ExitOnError)
+	to := fs.String("to", "", "target state: ACTIVE, PREPARED or REMOVED")
+	dryRun := fs.Bool("dry-run", false, "only print the plan")
+	fs.Parse(args)
+	if fs.NArg() < 2 || *to == "" {
+		fmt.Fprintf(os.Stderr, "usage: capictl state -to ACTIVE|PREPARED|REMOVED [-dry-run] <group> <host>...\n")
+		os.Exit(2)
+	}
+	group := fs.Arg(0)
+
+	c := newClient()
+	p, err := plan.BuildSetState(c, group, fs.Args()[1:], *to)
+	if err != nil {
+		log.Fatalf("Failed to plan state change of group %s on capi %s, reason: %v", group, *capiURL, err)
+	}
+	p.Print(os.Stdout)
+	if *dryRun || p.Empty() {
+		return
+	}
+	applyPlan(c, p)
+	log.Printf("group %s: %d hosts moved to %s, operation %s", group, p.Count(plan.Modify), *to, p.Transition.GroupOperationId)
+}
+
+func statusCmd(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	diverged := fs.Bool("diverged", false, "show only workloads not in their target state")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: capictl status [-diverged] <group>\n")
+		os.Exit(2)
+	}
+	group := fs.Arg(0)
+
+	current, err := newClient().GroupState(group)
+	if err != nil {
+		log.Fatalf("Failed to get state of group %s on capi %s, reason: %v", group, *capiURL, err)
+	}
+
+	n := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "HOST\tSERVICE\tGENERATION\tTARGET\tCURRENT\t\n")
+	for _, d := range plan.Divergences(group, current) {
+		mark := ""
+		if d.Diverged() {
+			mark = "*"
+			n++
+		} else if *diverged {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", d.Host, d.Service, d.Generation, d.Target, d.Current, mark)
+	}
+	w.Flush()
+	fmt.Printf("%d workloads not in target state\n", n)
+}
+
+func waitCmd(args []string) {
+	fs := flag.NewFlagSet("wait", flag.ExitOnError)
+	state := fs.String("state", "", "wanted current state, workloads' target state by default")
+	gen := fs.String("generation", "", "wait for this generation")
+	timeout := fs.Duration("timeout", 30*time.Minute, "give up after")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: capictl wait [-state STATE] [-generation gen] [-timeout 30m] <group>\n")
+		os.Exit(2)
+	}
+	group := fs.Arg(0)
+
+	err := wait.Group(newClient(), group, wait.Options{
+		State:      *state,
+		Generation: *gen,
+		Timeout:    *timeout,
+		Out:        os.Stdout,
+	})
+	if err != nil {
+		log.Fatalf("group %s did not reach target state: %v", group, err)
+	}
+	log.Printf("group %s reached target state", group)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/credentials"
+	"capi_tools/rest"
+	"capi_tools/snapshot"
+	"capi_tools/stats"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func statsCmd(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	stateFile := fs.String("state", "", "snapshot, state json or rest state url, live state if empty")
+	metric := fs.String("metric", "ram_total,cpu_total", "comma separated metrics: ram_total, ram_free, cpu_total, cpu_free")
+	by := fs.String("by", "all", "group hosts by all, location or health")
+	width := fs.Float64("width", 0, "bucket width, 10 GB for ram and 200% for cpu if 0")
+	format := fs.String("format", "table", "table or csv")
+	out := fs.String("out", "", "render chart to file, .svg or .png")
+	fs.Parse(args)
+
+	cstate, err := clusterState(*stateFile)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	groups, err := stats.Split(stats.Hosts(cstate), *by)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	hists := make([]*stats.Histogram, 0)
+	for _, name := range strings.Split(*metric, ",") {
+		m, err := stats.FindMetric(strings.TrimSpace(name))
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		hists = append(hists, stats.Histograms(groups, m, *width)...)
+	}
+
+	if *out != "" {
+		if err := renderChart(*out, hists); err != nil {
+			log.Fatalf("Failed to render %s: %v", *out, err)
+		}
+		return
+	}
+	switch *format {
+	case "csv":
+		if err := stats.WriteCSV(os.Stdout, hists); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	case "table":
+		stats.WriteTable(os.Stdout, hists, 60)
+	default:
+		log.Fatalf("unknown format %s, want table or csv", *format)
+	}
+}
+
+func renderChart(path string, hists []*stats.Histogram) error {
+	var render func(io.Writer, []*stats.Histogram) error
+	switch filepath.Ext(path) {
+	case ".svg":
+		render = stats.WriteSVG
+	case ".png":
+		render = stats.WritePNG
+	default:
+		return fmt.Errorf("unknown chart format %s, want .svg or .png", filepath.Ext(path))
+	}
+	if len(hists) == 0 {
+		return fmt.Errorf("no histograms to draw, nothing matched")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := render(f, hists); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// clusterState reads state from a snapshot or a json file in proto or rest
+// format, fetches it from a rest url or gets full state from capi if path is empty.
+func clusterState(path string) (*clusterapi.ClusterState, error) {
+	switch {
+	case path == "":
+		cstate, err := newClient().GetState(&clusterapi.GetStateRequest{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get state on capi %s: %v", *capiURL, err)
+		}
+		return cstate, nil
+	case strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://"):
+		return rest.Get(path, authTransport(credentials.Capi, path))
+	}
+	s, err := snapshot.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.State, nil
+}
@@ -1,6 +1,20 @@
 #!/bin/bash
-oauth="8980ba4f3b5b4d7a827fbebd9acc8016"
+# prefer capictl net gc, it keeps endpoints of live workloads
 projectid="CAPIDEVNETS"
+tokenfile=~/.capi_tools/ip-broker.token
+
+oauth="${IP_BROKER_TOKEN}"
+if [ -z "${oauth}" ]; then
+    if [ ! -f ${tokenfile} ]; then
+        echo "set IP_BROKER_TOKEN or put the token into ${tokenfile}" >&2
+        exit 1
+    fi
+    if [ -n "`find ${tokenfile} -perm /077`" ]; then
+        echo "refusing to use ${tokenfile} readable by others, run chmod 600 ${tokenfile}" >&2
+        exit 1
+    fi
+    oauth=`cat ${tokenfile}`
+fi
 
 # get current endpoints and substract ids
 curl -s -i -H"Authorization: OAuth $oauth" -XGET  "ip-broker.qloud.yandex.net/network/endpoint/" > /tmp/${projectid}_endpoints.txt
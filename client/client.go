@@ -0,0 +1,155 @@
+// Package client is a thin wrapper around the cluster api proto endpoints.
+package client
+
+import (
+	"bytes"
+	"capi_tools/audit"
+	"capi_tools/clusterapi"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ErrNotModified is returned by GetState when the cluster state did not change
+// since the version passed in the request.
+var ErrNotModified = errors.New("cluster state not modified")
+
+type Client struct {
+	url         string
+	http        *http.Client
+	schedulerId string
+	journal     *audit.Journal
+}
+
+// New returns a client for capi url like "http://host:8081/proto/v0".
+func New(url string) *Client {
+	return &Client{
+		url:         strings.TrimRight(url, "/"),
+		http:        &http.Client{Timeout: 5 * time.Minute},
+		schedulerId: DefaultSchedulerId,
+	}
+}
+
+// WithTransport makes requests through rt, e.g. one adding credentials.
+func (c *Client) WithTransport(rt http.RoundTripper) *Client {
+	c.http.Transport = rt
+	return c
+}
+
+// URL returns capi url the client was created with.
+func (c *Client) URL() string {
+	return c.url
+}
+
+func (c *Client) call(path string, req proto.Message, resp proto.Message) error {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	r, err := c.http.Post(c.url+path, "application/x-protobuf", bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %v", path, err)
+	}
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %v", path, err)
+	}
+	if r.StatusCode == http.StatusNotModified {
+		return ErrNotModified
+	}
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", path, r.Status, body)
+	}
+
+	if err := proto.Unmarshal(body, resp); err != nil {
+		return fmt.Errorf("failed to unmarshal %s response: %v", path, err)
+	}
+	return nil
+}
+
+// GetState fetches cluster state, POST /state/full.
+func (c *Client) GetState(req *clusterapi.GetStateRequest) (*clusterapi.ClusterState, error) {
+	resp := new(clusterapi.ClusterState)
+	if err := c.call("/state/full", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// GetStateDelta fetches changes relative to req.FromVersion, POST /state/delta.
+func (c *Client) GetStateDelta(req *clusterapi.GetStateDeltaRequest) (*clusterapi.ClusterStateDelta, error) {
+	resp := new(clusterapi.ClusterStateDelta)
+	if err := c.call("/state/delta", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Apply sends group transitions, POST /apply/group. Transitions without
+// operation id get one and an unsigned request is signed.
+func (c *Client) Apply(req *clusterapi.ApplyGroupTransitionRequest) (*clusterapi.ApplyGroupTransitionResponse, error) {
+	groups := make([]string, 0, len(req.GroupTransitions))
+	operation := ""
+	for _, t := range req.GroupTransitions {
+		if t.GroupOperationId == "" {
+			t.GroupOperationId = NewOperationId()
+		}
+		groups = append(groups, t.GroupId)
+		operation = t.GroupOperationId
+	}
+	if req.SchedulerSignature == nil {
+		req.SchedulerSignature = c.Sign(operation, "apply groups "+strings.Join(groups, ", "))
+	}
+	c.logMutation(req.SchedulerSignature)
+
+	entry := audit.NewApply(c.url, req)
+	resp := new(clusterapi.ApplyGroupTransitionResponse)
+	if err := c.call("/apply/group", req, resp); err != nil {
+		c.audit(entry, nil, err)
+		return nil, err
+	}
+	failed := make(map[string]string)
+	for _, r := range resp.Results {
+		if r.Exception != nil {
+			failed[r.GroupId] = DescribeException(r.Exception)
+		}
+	}
+	c.audit(entry, failed, nil)
+	return resp, nil
+}
+
+// Destroy removes groups, POST /destroy. An unsigned request is signed
+// with a new operation id.
+func (c *Client) Destroy(req *clusterapi.DestroyRequest) (*clusterapi.DestroyResponse, error) {
+	if req.SchedulerSignature == nil {
+		groups := make([]string, 0, len(req.GroupsToDestroy))
+		for _, g := range req.GroupsToDestroy {
+			groups = append(groups, g.GroupId)
+		}
+		req.SchedulerSignature = c.Sign(NewOperationId(), "destroy groups "+strings.Join(groups, ", "))
+	}
+	c.logMutation(req.SchedulerSignature)
+
+	entry := audit.NewDestroy(c.url, req)
+	resp := new(clusterapi.DestroyResponse)
+	if err := c.call("/destroy", req, resp); err != nil {
+		c.audit(entry, nil, err)
+		return nil, err
+	}
+	failed := make(map[string]string)
+	for _, r := range resp.Results {
+		if r.Exception != nil {
+			failed[r.GroupId] = DescribeException(r.Exception)
+		}
+	}
+	c.audit(entry, failed, nil)
+	return resp, nil
+}
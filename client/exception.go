@@ -0,0 +1,66 @@
+package client
+
+import (
+	"capi_tools/clusterapi"
+	"fmt"
+	"strings"
+)
+
+// DescribeException flattens nested capi exception into one line.
+func DescribeException(e *clusterapi.Exception) string {
+	if e == nil {
+		return ""
+	}
+	parts := make([]string, 0)
+	if e.DetailMessage != "" {
+		parts = append(parts, e.DetailMessage)
+	}
+	if ge := e.GetGroupTransitionApplyException(); ge != nil {
+		causes := make([]string, 0, len(ge.Causes))
+		for _, c := range ge.GetCauses() {
+			causes = append(causes, DescribeException(c))
+		}
+		parts = append(parts, fmt.Sprintf("group %s: [%s]", ge.GroupId, strings.Join(causes, "; ")))
+	}
+	if ve := e.GetTransitionValidationException(); ve != nil {
+		switch {
+		case ve.EtagFailureException != nil:
+			parts = append(parts, "etag mismatch")
+		case ve.QuotaViolationException != nil:
+			parts = append(parts, "quota violation")
+		case ve.HostTransitionApplyException != nil:
+			parts = append(parts, describeHostException(ve.HostTransitionApplyException))
+		}
+	}
+	if se := e.GetSystemException(); se != nil {
+		parts = append(parts, "system exception "+se.JavaClass)
+	}
+	return strings.Join(parts, ": ")
+}
+
+func describeHostException(he *clusterapi.HostTransitionApplyException) string {
+	switch {
+	case he.HostNotInClusterException != nil:
+		return fmt.Sprintf("host %s not in cluster", he.HostId)
+	case he.ApplyIllegalStateException != nil:
+		return fmt.Sprintf("host %s: illegal state", he.HostId)
+	case he.HostOvercommittedException != nil:
+		return fmt.Sprintf("host %s overcommitted: %s", he.HostId,
+			strings.Join(he.HostOvercommittedException.Violations, ", "))
+	}
+	return fmt.Sprintf("host %s: transition failed", he.HostId)
+}
+
+// ApplyError returns error describing all failed groups in resp or nil.
+func ApplyError(resp *clusterapi.ApplyGroupTransitionResponse) error {
+	failed := make([]string, 0)
+	for _, r := range resp.GetResults() {
+		if r.Exception != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", r.GroupId, DescribeException(r.Exception)))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("apply failed for %s", strings.Join(failed, "; "))
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"capi_tools/audit"
+	"capi_tools/clusterapi"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultSchedulerId signs mutations unless WithSchedulerId sets another one.
+const DefaultSchedulerId = "capi_tools"
+
+// NewOperationId returns unique id for GroupTransition.GroupOperationId.
+func NewOperationId() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%x", time.Now().UTC().Format("20060102T150405"), b)
+}
+
+// WithSchedulerId sets scheduler id mutations are signed with.
+func (c *Client) WithSchedulerId(id string) *Client {
+	c.schedulerId = id
+	return c
+}
+
+// SchedulerId returns scheduler id mutations are signed with.
+func (c *Client) SchedulerId() string {
+	return c.schedulerId
+}
+
+// Sign returns signature of a mutation, message starts with the operation
+// id so capi logs can be matched with local ones even for destroy requests
+// which have no operation id field.
+func (c *Client) Sign(operation, message string) *clusterapi.SchedulerSignature {
+	return &clusterapi.SchedulerSignature{
+		SchedulerId: c.schedulerId,
+		Message:     fmt.Sprintf("[%s] %s", operation, message),
+	}
+}
+
+func (c *Client) logMutation(s *clusterapi.SchedulerSignature) {
+	log.Printf("capi %s: %s %s", c.url, s.SchedulerId, s.Message)
+}
+
+// WithJournal records every apply and destroy in j.
+func (c *Client) WithJournal(j *audit.Journal) *Client {
+	c.journal = j
+	return c
+}
+
+// audit finishes and appends entry, journal failure must not fail the mutation.
+func (c *Client) audit(e *audit.Entry, failed map[string]string, err error) {
+	if c.journal == nil {
+		return
+	}
+	e.Finish(failed, err)
+	if err := c.journal.Append(e); err != nil {
+		log.Printf("failed to write audit journal %s: %v", c.journal.Path(), err)
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"capi_tools/clusterapi"
+	"fmt"
+	"strings"
+)
+
+// GroupFilter returns workload filter matching all workloads of group.
+func GroupFilter(group string) string {
+	return fmt.Sprintf("'Workload/id/configuration/groupId' == '%s'", group)
+}
+
+// HostsFilter returns host filter matching any of hosts.
+func HostsFilter(hosts []string) string {
+	conds := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		conds = append(conds, fmt.Sprintf("'HostMetadata/id' == '%s'", h))
+	}
+	return strings.Join(conds, " || ")
+}
+
+// GroupWorkloads returns workloads of group from hosts.
+func GroupWorkloads(group string, hosts []*clusterapi.Host) []*clusterapi.Workload {
+	result := make([]*clusterapi.Workload, 0)
+	for _, h := range hosts {
+		for _, wl := range h.GetWorkloads() {
+			if WorkloadGroup(wl) == group {
+				result = append(result, wl)
+			}
+		}
+	}
+	return result
+}
+
+// WorkloadGroup returns group id of workload or "" if workload has no id.
+func WorkloadGroup(wl *clusterapi.Workload) string {
+	if wl.GetId() == nil || wl.GetId().GetConfiguration() == nil {
+		return ""
+	}
+	return wl.GetId().GetConfiguration().GroupId
+}
+
+// WorkloadHost returns fqdn of host the workload is placed on.
+func WorkloadHost(wl *clusterapi.Workload) string {
+	if wl.GetId() == nil || wl.GetId().GetSlot() == nil {
+		return ""
+	}
+	return wl.GetId().GetSlot().Host
+}
+
+// GroupState returns hosts running workloads of group, each host keeps only
+// workloads of that group.
+func (c *Client) GroupState(group string) ([]*clusterapi.Host, error) {
+	cstate, err := c.GetState(&clusterapi.GetStateRequest{WorkloadFilter: GroupFilter(group)})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*clusterapi.Host, 0)
+	for _, h := range cstate.GetHosts() {
+		wls := make([]*clusterapi.Workload, 0)
+		for _, wl := range h.GetWorkloads() {
+			if WorkloadGroup(wl) == group {
+				wls = append(wls, wl)
+			}
+		}
+		if len(wls) == 0 {
+			continue
+		}
+		result = append(result, &clusterapi.Host{Metadata: h.GetMetadata(), Workloads: wls})
+	}
+	return result, nil
+}
+
+// HostsState returns state of hosts with all their workloads.
+func (c *Client) HostsState(hosts []string) ([]*clusterapi.Host, error) {
+	if len(hosts) == 0 {
+		return []*clusterapi.Host{}, nil
+	}
+	cstate, err := c.GetState(&clusterapi.GetStateRequest{HostFilter: HostsFilter(hosts)})
+	if err != nil {
+		return nil, err
+	}
+	return cstate.GetHosts(), nil
+}
+
+// WorkloadService returns slot service of workload.
+func WorkloadService(wl *clusterapi.Workload) string {
+	if wl.GetId() == nil || wl.GetId().GetSlot() == nil {
+		return ""
+	}
+	return wl.GetId().GetSlot().Service
+}
+
+// WorkloadFingerprint returns group state fingerprint workload was created with.
+func WorkloadFingerprint(wl *clusterapi.Workload) string {
+	if wl.GetId() == nil || wl.GetId().GetConfiguration() == nil {
+		return ""
+	}
+	return wl.GetId().GetConfiguration().GroupStateFingerprint
+}
+
+// WorkloadResources returns computing resources requested by workload container.
+func WorkloadResources(wl *clusterapi.Workload) *clusterapi.ComputingResources {
+	var c *clusterapi.Container
+	switch {
+	case wl.Entity == nil:
+	case wl.Entity.Instance != nil:
+		c = wl.Entity.Instance.Container
+	case wl.Entity.Job != nil:
+		c = wl.Entity.Job.Container
+	}
+	if c == nil || c.ComputingResources == nil {
+		return &clusterapi.ComputingResources{}
+	}
+	return c.ComputingResources
+}
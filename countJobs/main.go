@@ -0,0 +1,105 @@
+package main
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/credentials"
+	"capi_tools/graphite"
+	"capi_tools/jobstats"
+	"capi_tools/rest"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var capiURL = flag.String("capi", "http://sit-dev-01-sas.haze.yandex.net:8081/proto/v0", "capi host url")
+var restURL = flag.String("rest", "", "read state from rest endpoint like http://capi-sas.yandex-team.ru:29100/rest/v0/state/0 instead of capi")
+var graphiteAddr = flag.String("graphite", "localhost:2024", "graphite host:port")
+var prefix = flag.String("prefix", "one_min.capi", "metric prefix")
+var interval = flag.Duration("interval", time.Minute, "how often to count")
+var once = flag.Bool("once", false, "count once and exit")
+var credentialsPath = flag.String("credentials", credentials.DefaultPath(), "token profiles config")
+var dryRun = flag.Bool("dry-run", false, "print lines instead of sending them")
+
+// classFlags collects repeated -class name=regexp flags.
+type classFlags []jobstats.Class
+
+func (c *classFlags) String() string {
+	names := make([]string, 0, len(*c))
+	for _, cl := range *c {
+		names = append(names, cl.Name+"="+cl.Match.String())
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *classFlags) Set(s string) error {
+	cl, err := jobstats.ParseClass(s)
+	if err != nil {
+		return err
+	}
+	*c = append(*c, cl)
+	return nil
+}
+
+func main() {
+	var classes classFlags
+	flag.Var(&classes, "class", "host class as name=regexp, first match wins, may repeat (default rtc, r2, tsnet, qloud, zerling)")
+	flag.Parse()
+	if len(classes) == 0 {
+		classes = jobstats.DefaultClasses
+	}
+	if *interval <= 0 && !*once {
+		log.Fatalf("-interval must be positive, got %s", *interval)
+	}
+
+	log.SetOutput(credentials.RedactWriter(os.Stderr))
+	creds, err := credentials.Load(*credentialsPath)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	if err := creds.Check(); err != nil {
+		log.Fatalf("refusing to start: %v", err)
+	}
+
+	c := client.New(*capiURL).WithTransport(&credentials.Transport{Profile: creds.For(credentials.Capi, *capiURL)})
+	restTransport := &credentials.Transport{Profile: creds.For(credentials.Capi, *restURL)}
+	sender := graphite.New(*graphiteAddr)
+	defer sender.Close()
+	for {
+		start := time.Now()
+		if err := count(c, restTransport, sender, classes); err != nil {
+			log.Printf("Failed to count jobs on capi %s, reason: %v", *capiURL, err)
+		}
+		if *once {
+			return
+		}
+		time.Sleep(*interval - time.Since(start)%*interval)
+	}
+}
+
+func count(c *client.Client, restTransport http.RoundTripper, sender *graphite.Sender, classes []jobstats.Class) error {
+	var cstate *clusterapi.ClusterState
+	var err error
+	if *restURL != "" {
+		cstate, err = rest.Get(*restURL, restTransport)
+	} else {
+		cstate, err = c.GetState(&clusterapi.GetStateRequest{})
+	}
+	if err != nil {
+		return err
+	}
+	lines := jobstats.Count(cstate, classes).Lines(*prefix, time.Now())
+	if *dryRun {
+		fmt.Println(strings.Join(lines, "\n"))
+		return nil
+	}
+	if err := sender.Send(lines); err != nil {
+		return err
+	}
+	log.Printf("sent %d lines for %d hosts", len(lines), len(cstate.Hosts))
+	return nil
+}
@@ -0,0 +1,173 @@
+// Package credentials finds OAuth tokens for capi and ip-broker endpoints.
+//
+// A token of a profile is taken from the first source set: an environment
+// variable, a file readable only by its owner, or the output of a helper
+// command. Profiles are chosen by endpoint url and configured in
+// ~/.capi_tools/credentials.yaml:
+//
+//	profiles:
+//	  - name: capi
+//	    env: CAPI_OAUTH_TOKEN
+//	    file: ~/.capi_tools/capi.token
+//	  - name: capi-prestable
+//	    endpoint: http://iss00-prestable.search.yandex.net:8082
+//	    helper: secret-tool lookup service capi-prestable
+package credentials
+
+import (
+	"capi_tools/localuser"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// profile names used when no endpoint matches
+const (
+	Capi     = "capi"
+	IpBroker = "ip-broker"
+)
+
+type Profile struct {
+	Name string `yaml:"name"`
+	// url prefix of endpoints the profile is used for, the longest match wins
+	Endpoint string `yaml:"endpoint"`
+	Env      string `yaml:"env"`
+	File     string `yaml:"file"`
+	Helper   string `yaml:"helper"`
+
+	once  sync.Once
+	token string
+	err   error
+}
+
+type Config struct {
+	Profiles []*Profile `yaml:"profiles"`
+}
+
+// DefaultPath is ~/.capi_tools/credentials.yaml.
+func DefaultPath() string {
+	return localuser.Path("credentials.yaml")
+}
+
+// Default has a capi and an ip-broker profile reading tokens from
+// CAPI_OAUTH_TOKEN and IP_BROKER_TOKEN or from token files in ~/.capi_tools.
+func Default() *Config {
+	return &Config{Profiles: []*Profile{
+		{Name: Capi, Env: "CAPI_OAUTH_TOKEN", File: "~/.capi_tools/capi.token"},
+		{Name: IpBroker, Env: "IP_BROKER_TOKEN", File: "~/.capi_tools/ip-broker.token"},
+	}}
+}
+
+// Load reads config from path, Default is returned if there is no such file.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Default(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Check verifies token files of all profiles are not readable by others.
+func (c *Config) Check() error {
+	for _, p := range c.Profiles {
+		if p.File == "" {
+			continue
+		}
+		if err := CheckFile(expand(p.File)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("profile %s: %v", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// For returns profile of endpoint url: the longest matching endpoint prefix,
+// otherwise the profile named service, nil if there is none.
+func (c *Config) For(service, url string) *Profile {
+	var best *Profile
+	for _, p := range c.Profiles {
+		if p.Endpoint != "" && strings.HasPrefix(url, p.Endpoint) && (best == nil || len(p.Endpoint) > len(best.Endpoint)) {
+			best = p
+		}
+	}
+	if best != nil {
+		return best
+	}
+	for _, p := range c.Profiles {
+		if p.Name == service && p.Endpoint == "" {
+			return p
+		}
+	}
+	return nil
+}
+
+// CheckFile returns error if path is accessible by group or others.
+func CheckFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("token file %s has mode %04o, run chmod 600 %s", path, fi.Mode().Perm(), path)
+	}
+	return nil
+}
+
+// Token returns token of profile, it is looked up once and remembered.
+// Empty token without error means no source is configured or set.
+func (p *Profile) Token() (string, error) {
+	p.once.Do(func() {
+		p.token, p.err = p.lookup()
+		if p.token != "" {
+			register(p.token)
+		}
+	})
+	return p.token, p.err
+}
+
+func (p *Profile) lookup() (string, error) {
+	if p.Env != "" {
+		if t := strings.TrimSpace(os.Getenv(p.Env)); t != "" {
+			return t, nil
+		}
+	}
+	if p.File != "" {
+		path := expand(p.File)
+		if err := CheckFile(path); err == nil {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSpace(string(data)), nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if p.Helper != "" {
+		out, err := exec.Command("sh", "-c", p.Helper).Output()
+		if err != nil {
+			return "", fmt.Errorf("token helper of profile %s failed: %v", p.Name, err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	return "", nil
+}
+
+func expand(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(localuser.Home(), path[2:])
+	}
+	return path
+}
@@ -0,0 +1,194 @@
+package credentials
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func writeFile(t *testing.T, path, content string, mode os.FileMode) {
+	if err := ioutil.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile mode is masked by umask
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	c, err := Load(filepath.Join(dir, "missing.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names(c), []string{Capi, IpBroker}) {
+		t.Errorf("profiles without config = %v, want defaults", names(c))
+	}
+
+	path := filepath.Join(dir, "credentials.yaml")
+	writeFile(t, path, "profiles:\n  - name: capi\n    env: TOKEN\n  - name: prestable\n    endpoint: http://pre:8082\n    helper: echo t\n", 0600)
+	if c, err = Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names(c), []string{"capi", "prestable"}) {
+		t.Errorf("profiles = %v", names(c))
+	}
+	if p := c.Profiles[1]; p.Endpoint != "http://pre:8082" || p.Helper != "echo t" {
+		t.Errorf("profile prestable = %+v", p)
+	}
+
+	writeFile(t, path, "profiles: [", 0600)
+	if _, err := Load(path); err == nil {
+		t.Error("broken config loaded")
+	}
+}
+
+func names(c *Config) []string {
+	result := make([]string, 0, len(c.Profiles))
+	for _, p := range c.Profiles {
+		result = append(result, p.Name)
+	}
+	return result
+}
+
+func TestFor(t *testing.T) {
+	c := &Config{Profiles: []*Profile{
+		{Name: Capi},
+		{Name: "pre", Endpoint: "http://pre"},
+		{Name: "pre-8082", Endpoint: "http://pre:8082"},
+	}}
+	tests := []struct {
+		service, url string
+		want         string
+	}{
+		{Capi, "http://prod:8082", Capi},
+		{Capi, "http://pre:9000", "pre"},
+		{Capi, "http://pre:8082/state", "pre-8082"},
+		{IpBroker, "http://broker", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if p := c.For(tt.service, tt.url); p != nil {
+			got = p.Name
+		}
+		if got != tt.want {
+			t.Errorf("For(%s, %s) = %q, want %q", tt.service, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	private := filepath.Join(dir, "private.token")
+	writeFile(t, private, "a", 0600)
+	shared := filepath.Join(dir, "shared.token")
+	writeFile(t, shared, "b", 0644)
+
+	tests := []struct {
+		name string
+		file string
+		ok   bool
+	}{
+		{"owner only", private, true},
+		{"readable by others", shared, false},
+		{"missing", filepath.Join(dir, "missing.token"), true},
+	}
+	for _, tt := range tests {
+		c := &Config{Profiles: []*Profile{{Name: Capi, File: tt.file}}}
+		if err := c.Check(); (err == nil) != tt.ok {
+			t.Errorf("%s: Check() = %v", tt.name, err)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	file := filepath.Join(dir, "capi.token")
+	writeFile(t, file, "from-file\n", 0600)
+	shared := filepath.Join(dir, "shared.token")
+	writeFile(t, shared, "leaked", 0644)
+	os.Setenv("CREDENTIALS_TEST_TOKEN", "from-env")
+	defer os.Unsetenv("CREDENTIALS_TEST_TOKEN")
+
+	tests := []struct {
+		name    string
+		profile *Profile
+		want    string
+		wantErr bool
+	}{
+		{"env first", &Profile{Env: "CREDENTIALS_TEST_TOKEN", File: file}, "from-env", false},
+		{"unset env falls back to file", &Profile{Env: "CREDENTIALS_TEST_UNSET", File: file}, "from-file", false},
+		{"missing file falls back to helper", &Profile{File: filepath.Join(dir, "missing"), Helper: "echo from-helper"}, "from-helper", false},
+		{"file readable by others", &Profile{File: shared, Helper: "echo from-helper"}, "", true},
+		{"failing helper", &Profile{Helper: "exit 1"}, "", true},
+		{"no source", &Profile{}, "", false},
+	}
+	for _, tt := range tests {
+		got, err := tt.profile.Token()
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: Token() = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	for _, p := range []*Profile{{Helper: "echo secret"}, {}, nil} {
+		c := &http.Client{Transport: &Transport{Profile: p}}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if req.Header.Get("Authorization") != "" {
+			t.Error("request of the caller was modified")
+		}
+	}
+	if want := []string{"OAuth secret", "", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+func TestRedactWriter(t *testing.T) {
+	p := &Profile{Helper: "echo redact-me"}
+	if _, err := p.Token(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := RedactWriter(&buf)
+	line := "GET http://capi?token=redact-me failed, token redact-me\n"
+	n, err := w.Write([]byte(line))
+	if err != nil || n != len(line) {
+		t.Errorf("Write() = %d, %v, want %d", n, err, len(line))
+	}
+	if want := "GET http://capi?token=<redacted> failed, token <redacted>\n"; buf.String() != want {
+		t.Errorf("written %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,80 @@
+package credentials
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Transport adds Authorization: OAuth header with token of Profile.
+type Transport struct {
+	Profile *Profile
+	// http.DefaultTransport if nil
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if t.Profile == nil {
+		return base.RoundTrip(r)
+	}
+	token, err := t.Profile.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return base.RoundTrip(r)
+	}
+	// a RoundTripper must not modify the request it was given
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = make(http.Header, len(r.Header)+1)
+	for k, v := range r.Header {
+		r2.Header[k] = v
+	}
+	r2.Header.Set("Authorization", "OAuth "+token)
+	return base.RoundTrip(r2)
+}
+
+var (
+	mu     sync.Mutex
+	tokens []string
+)
+
+func register(token string) {
+	mu.Lock()
+	defer mu.Unlock()
+	tokens = append(tokens, token)
+}
+
+const redacted = "<redacted>"
+
+// Redact replaces every token this process has read in s.
+func Redact(s string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, t := range tokens {
+		s = strings.Replace(s, t, redacted, -1)
+	}
+	return s
+}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+// RedactWriter wraps w so tokens never reach it, meant for log.SetOutput.
+func RedactWriter(w io.Writer) io.Writer {
+	return redactWriter{w}
+}
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"capi/capi"
 	"capi/task"
+	"capi_tools/credentials"
 	"flag"
 	"io/ioutil"
 	"log"
@@ -19,6 +20,7 @@ var taskF = flag.String("task", "", "path to task.yaml")
 //var capi_url string "http://iss00-prestable.search.yandex.net:8082/proto/v0/state/full"
 
 func main() {
+	log.SetOutput(credentials.RedactWriter(os.Stderr))
 	flag.Parse()
 	if *taskF == "" {
 		flag.PrintDefaults()
@@ -0,0 +1,255 @@
+// Package diagnosis explains why a workload is not where it should be,
+// turning raw agent feedback into a root cause, a one line explanation and a
+// suggested fix.
+package diagnosis
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/disk"
+	"capi_tools/feedback"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Cause string
+
+const (
+	Healthy           Cause = "healthy"
+	InProgress        Cause = "in_progress"
+	DiskFull          Cause = "disk_full"
+	DownloadFailed    Cause = "download_failed"
+	ChecksumMismatch  Cause = "checksum_mismatch"
+	ValidationFailed  Cause = "validation_failed"
+	DirectoryFailure  Cause = "directory_failure"
+	ResourcesNotReady Cause = "resources_not_ready"
+	ShardFailed       Cause = "shard_failed"
+	HookFailed        Cause = "hook_failed"
+	DaemonCrashed     Cause = "daemon_crashed"
+	OutOfMemory       Cause = "out_of_memory"
+	RestartLimit      Cause = "restart_limit"
+	StartedTooOften   Cause = "started_too_often"
+	TimeLimit         Cause = "time_limit"
+	LockWait          Cause = "lock_wait"
+	Failed            Cause = "failed"
+	Unknown           Cause = "unknown"
+)
+
+type Severity int
+
+const (
+	Ok Severity = iota
+	Pending
+	Warning
+	Failure
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Pending:
+		return "pending"
+	case Warning:
+		return "warning"
+	case Failure:
+		return "failure"
+	}
+	return "ok"
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Diagnosis is the most important problem of one workload.
+type Diagnosis struct {
+	Host        string   `json:"host"`
+	Group       string   `json:"group"`
+	Service     string   `json:"service"`
+	State       string   `json:"state"`
+	Target      string   `json:"target"`
+	Cause       Cause    `json:"cause"`
+	Severity    Severity `json:"severity"`
+	Explanation string   `json:"explanation"`
+	Fix         string   `json:"fix,omitempty"`
+	// descriptions of all messages considered, most important first
+	Evidence []string `json:"evidence,omitempty"`
+}
+
+func (d *Diagnosis) String() string {
+	return fmt.Sprintf("%s %s: %s", d.Host, d.Cause, d.Explanation)
+}
+
+// finding is a candidate cause derived from one message, the one with the
+// highest rank wins.
+type finding struct {
+	rank        int
+	cause       Cause
+	severity    Severity
+	explanation string
+	fix         string
+}
+
+// Diagnose classifies workload feedback.
+func Diagnose(wl *clusterapi.Workload) *Diagnosis {
+	d := &Diagnosis{
+		Host:    client.WorkloadHost(wl),
+		Group:   client.WorkloadGroup(wl),
+		Service: client.WorkloadService(wl),
+		State:   feedback.CurrentState(wl),
+		Target:  wl.TargetState,
+	}
+
+	findings := make([]*finding, 0)
+	for _, m := range feedback.All(wl) {
+		if m.Kind == feedback.Metric {
+			continue
+		}
+		if f := classify(m, wl); f != nil {
+			findings = append(findings, f)
+			d.Evidence = append(d.Evidence, fmt.Sprintf("%s: %s", m.Kind, feedback.Describe(m.FeedbackMessage)))
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].rank > findings[j].rank })
+
+	switch {
+	case len(findings) > 0:
+		f := findings[0]
+		d.Cause, d.Severity, d.Explanation, d.Fix = f.cause, f.severity, f.explanation, f.fix
+	case d.State == d.Target:
+		d.Cause, d.Severity = Healthy, Ok
+		d.Explanation = fmt.Sprintf("%s as requested", d.State)
+	case d.State == "UNKNOWN":
+		d.Cause, d.Severity = Unknown, Pending
+		d.Explanation = "agent has not reported state yet"
+		d.Fix = "check that the agent on the host is alive"
+	default:
+		d.Cause, d.Severity = InProgress, Pending
+		d.Explanation = fmt.Sprintf("moving from %s to %s", d.State, d.Target)
+	}
+	return d
+}
+
+// Group diagnoses every workload of group, problems first.
+func Group(group string, hosts []*clusterapi.Host) []*Diagnosis {
+	result := make([]*Diagnosis, 0)
+	for _, wl := range client.GroupWorkloads(group, hosts) {
+		result = append(result, Diagnose(wl))
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Severity != result[j].Severity {
+			return result[i].Severity > result[j].Severity
+		}
+		return result[i].Host < result[j].Host
+	})
+	return result
+}
+
+func classify(m feedback.Message, wl *clusterapi.Workload) *finding {
+	severity := Pending
+	switch m.Kind {
+	case feedback.Failure:
+		severity = Failure
+	case feedback.Warning:
+		severity = Warning
+	}
+	// failures outrank anything reported as pending
+	base := int(severity) * 100
+
+	switch {
+	case m.ProcessFeedback != nil && m.ProcessFeedback.OutOfMemory:
+		p := m.ProcessFeedback
+		return &finding{base + 60, OutOfMemory, Failure,
+			fmt.Sprintf("%s killed by OOM, ram limit %s", p.ExecutableName, feedback.HumanBytes(client.WorkloadResources(wl).RamBytes)),
+			"raise resources.ram in task or find the memory leak"}
+	case m.WaitingForFreeSpace != nil:
+		w := m.WaitingForFreeSpace
+		return &finding{base + 50, DiskFull, severity,
+			fmt.Sprintf("disk full: need %s, %s available of %s, reserver %s",
+				feedback.HumanBytes(w.RequiredBytes), feedback.HumanBytes(w.AvailableBytes),
+				feedback.HumanBytes(w.TotalBytes), w.Reserver),
+			fmt.Sprintf("free %s on the host or shrink resources, %s must stay free",
+				feedback.HumanBytes(disk.Missing(w)), feedback.HumanBytes(w.SpaceToLeaveOnDiskBytes))}
+	case m.CachedResourceNotRemoved != nil:
+		c := m.CachedResourceNotRemoved
+		return &finding{base + 45, DiskFull, severity,
+			fmt.Sprintf("cached resource of %s holds disk space, %s available", c.Reserver, feedback.HumanBytes(c.AvailableBytes)),
+			"remove unused cached resources from the host"}
+	case m.ChecksumVerificationFailure != nil:
+		c := m.ChecksumVerificationFailure
+		return &finding{base + 40, ChecksumMismatch, severity,
+			fmt.Sprintf("checksum of %s is %s, expected %s", c.TargetFile, c.Calculated, c.Expected),
+			"fix checksum in task or reupload the resource"}
+	case m.DownloadFailed != nil:
+		d := m.DownloadFailed
+		return &finding{base + 40, DownloadFailed, severity,
+			fmt.Sprintf("download of %s failed: %s", d.From, d.FailReason),
+			"check that the resource url is alive and reachable from the host"}
+	case m.ValidationFailed != nil:
+		v := m.ValidationFailed
+		return &finding{base + 40, ValidationFailed, severity,
+			fmt.Sprintf("resource %s is broken, missing %s in %s: %s", v.From, v.MissingFile, v.TargetDir, v.FailReason),
+			"reupload the resource"}
+	case m.ShardFailure != nil:
+		return &finding{base + 40, ShardFailed, severity,
+			fmt.Sprintf("shard %s failed: %s", m.ShardFailure.ShardId, m.ShardFailure.FailReason),
+			"check the shard is available"}
+	case m.DirectoryFailure != nil:
+		d := m.DirectoryFailure
+		return &finding{base + 40, DirectoryFailure, severity,
+			fmt.Sprintf("can not prepare directory %s: %s", d.Directory, d.FailReason),
+			"check mount points and permissions on the host"}
+	case m.CountLimit != nil:
+		c := m.CountLimit
+		return &finding{base + 35, RestartLimit, Failure,
+			fmt.Sprintf("gave up after %d of %d attempts", c.Attempts, c.MaxAttempts),
+			"look at hook output with capictl logs, fix and reapply"}
+	case m.TimeLimitViolation != nil:
+		t := m.TimeLimitViolation
+		return &finding{base + 35, TimeLimit, Failure,
+			fmt.Sprintf("running for %s, limit is %s", t.DurationFromFirstRun, t.Duration),
+			"raise time limits or speed the hook up"}
+	case m.FrequencyLimit != nil:
+		f := m.FrequencyLimit
+		return &finding{base + 30, StartedTooOften, severity,
+			fmt.Sprintf("restarting too often, allowed gap %s, last run %s", f.InvocationGap, f.LastRun),
+			"the daemon keeps exiting, look at its output with capictl logs"}
+	case m.DaemonFailure != nil:
+		d := m.DaemonFailure
+		return &finding{base + 30, DaemonCrashed, severity,
+			fmt.Sprintf("daemon %s failed: %s", d.Hook, d.FailReason),
+			"look at daemon output with capictl logs"}
+	case m.HookFailure != nil:
+		return &finding{base + 30, HookFailed, severity,
+			fmt.Sprintf("hook %s failed (%s)", m.HookFailure.Hook, m.HookFailure.State),
+			"look at hook output with capictl logs"}
+	case m.ProcessFeedback != nil && (m.ProcessFeedback.ExitCode != 0 || m.ProcessFeedback.SignalNumber != 0):
+		p := m.ProcessFeedback
+		return &finding{base + 25, HookFailed, severity,
+			fmt.Sprintf("%s %s with exit code %d, signal %s", p.ExecutableName, p.State, p.ExitCode, feedback.SignalName(p.SignalNumber)),
+			"look at process output with capictl logs"}
+	case m.FailMessage != nil:
+		return &finding{base + 20, Failed, severity,
+			fmt.Sprintf("%s: %s", m.FailMessage.State, m.FailMessage.FailReason), ""}
+	case m.ResourcesNotReady != nil:
+		r := m.ResourcesNotReady
+		names := make([]string, 0, len(r.States))
+		for name, state := range r.States {
+			names = append(names, fmt.Sprintf("%s (%s)", name, state))
+		}
+		sort.Strings(names)
+		explanation := "resources not ready: " + strings.Join(names, ", ")
+		if r.FailReason != "" {
+			explanation += ": " + r.FailReason
+		}
+		return &finding{base + 10, ResourcesNotReady, severity, explanation, ""}
+	case m.Lock != nil:
+		l := m.Lock
+		return &finding{base + 5, LockWait, severity,
+			fmt.Sprintf("waiting for lock %s held by %s", l.Lock, l.LockedBy),
+			fmt.Sprintf("wait until %s finishes", l.LockedBy)}
+	case m.Progress != nil || m.ChecksumProgress != nil || m.HookInProgress != nil:
+		return &finding{base, InProgress, Pending, feedback.Describe(m.FeedbackMessage), ""}
+	}
+	return nil
+}
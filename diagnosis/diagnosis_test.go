@@ -0,0 +1,109 @@
+package diagnosis
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	full := &clusterapi.FeedbackMessage{WaitingForFreeSpace: &clusterapi.WaitingForFreeSpace{
+		Reserver: "rbtorrent", RequiredBytes: 10 << 30, AvailableBytes: 1 << 30, TotalBytes: 100 << 30,
+	}}
+	oom := &clusterapi.FeedbackMessage{ProcessFeedback: &clusterapi.ProcessFeedback{ExecutableName: "server", OutOfMemory: true}}
+	download := &clusterapi.FeedbackMessage{DownloadFailed: &clusterapi.DownloadFailed{From: "sbr:1", FailReason: "timeout"}}
+	lock := &clusterapi.FeedbackMessage{Lock: &clusterapi.Lock{Lock: "install", LockedBy: "other"}}
+
+	tests := []struct {
+		name     string
+		wl       *clusterapi.Workload
+		cause    Cause
+		severity Severity
+		// part of the explanation
+		explains string
+	}{
+		{
+			name:     "healthy",
+			wl:       fixture.Workload("g", "a", fixture.Current("ACTIVE")),
+			cause:    Healthy,
+			severity: Ok,
+			explains: "ACTIVE as requested",
+		},
+		{
+			name:     "no state reported",
+			wl:       fixture.Workload("g", "a"),
+			cause:    Unknown,
+			severity: Pending,
+		},
+		{
+			name:     "in progress",
+			wl:       fixture.Workload("g", "a", fixture.Current("PREPARED")),
+			cause:    InProgress,
+			severity: Pending,
+			explains: "from PREPARED to ACTIVE",
+		},
+		{
+			name:     "disk full",
+			wl:       fixture.Workload("g", "a", fixture.Current("PREPARED"), fixture.Pending(full)),
+			cause:    DiskFull,
+			severity: Pending,
+			explains: "need 10.0G",
+		},
+		{
+			name:     "disk full reported as warning",
+			wl:       fixture.Workload("g", "a", fixture.Current("PREPARED"), fixture.Warning(full)),
+			cause:    DiskFull,
+			severity: Warning,
+		},
+		{
+			name:     "out of memory outranks a failed download",
+			wl:       fixture.Workload("g", "a", fixture.Failure(download), fixture.Failure(oom), fixture.Resources(100, 1<<30)),
+			cause:    OutOfMemory,
+			severity: Failure,
+			explains: "server killed by OOM",
+		},
+		{
+			name:     "failure outranks pending",
+			wl:       fixture.Workload("g", "a", fixture.Pending(lock), fixture.Failed("no luck")),
+			cause:    Failed,
+			severity: Failure,
+			explains: "no luck",
+		},
+		{
+			name:     "lock",
+			wl:       fixture.Workload("g", "a", fixture.Current("PREPARED"), fixture.Pending(lock)),
+			cause:    LockWait,
+			severity: Pending,
+			explains: "held by other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Diagnose(tt.wl)
+			if d.Cause != tt.cause || d.Severity != tt.severity {
+				t.Errorf("Diagnose = %s %s, want %s %s", d.Cause, d.Severity, tt.cause, tt.severity)
+			}
+			if !strings.Contains(d.Explanation, tt.explains) {
+				t.Errorf("explanation %q does not mention %q", d.Explanation, tt.explains)
+			}
+		})
+	}
+}
+
+func TestGroupProblemsFirst(t *testing.T) {
+	hosts := []*clusterapi.Host{
+		fixture.Host("a", fixture.Workload("g", "a", fixture.Current("ACTIVE"))),
+		fixture.Host("b", fixture.Workload("g", "b", fixture.Failed("broken"))),
+		fixture.Host("c", fixture.Workload("g", "c", fixture.Current("PREPARED")), fixture.Workload("other", "c", fixture.Failed("x"))),
+	}
+	got := make([]string, 0)
+	for _, d := range Group("g", hosts) {
+		got = append(got, d.Host+" "+string(d.Cause))
+	}
+	want := []string{"b failed", "c in_progress", "a healthy"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Group = %v, want %v", got, want)
+	}
+}
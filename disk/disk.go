@@ -0,0 +1,159 @@
+// Package disk aggregates disk space feedback of workloads into a report of
+// hosts blocked on free space and reservers holding it.
+package disk
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/feedback"
+	"sort"
+)
+
+// Blocked is a workload resource waiting for free space.
+type Blocked struct {
+	Group     string `json:"group"`
+	Service   string `json:"service"`
+	Reserver  string `json:"reserver"`
+	Required  uint64 `json:"required_bytes"`
+	Consumed  uint64 `json:"consumed_bytes"`
+	Total     uint64 `json:"total_bytes"`
+	Available uint64 `json:"available_bytes"`
+	// space that must stay free on the partition
+	Reserve uint64 `json:"reserve_bytes"`
+	Missing uint64 `json:"missing_bytes"`
+}
+
+// Cached is a cached resource agent could not remove to free space.
+type Cached struct {
+	Group     string `json:"group"`
+	Service   string `json:"service"`
+	Reserver  string `json:"reserver"`
+	Available uint64 `json:"available_bytes"`
+	Reserve   uint64 `json:"reserve_bytes"`
+}
+
+// Host is disk pressure on one host.
+type Host struct {
+	Host string `json:"host"`
+	// sum of space missing for every blocked resource
+	Missing uint64     `json:"missing_bytes"`
+	Blocked []*Blocked `json:"blocked,omitempty"`
+	Cached  []*Cached  `json:"cached,omitempty"`
+}
+
+// Stuck reports whether some workload on host waits for free space.
+func (h *Host) Stuck() bool {
+	return len(h.Blocked) > 0
+}
+
+// Reserver is disk pressure caused by one reserving driver over all hosts.
+type Reserver struct {
+	Reserver    string `json:"reserver"`
+	Hosts       int    `json:"hosts"`
+	Blocked     int    `json:"blocked"`
+	Missing     uint64 `json:"missing_bytes"`
+	CachedHosts int    `json:"cached_hosts"`
+}
+
+type Report struct {
+	StuckHosts int         `json:"stuck_hosts"`
+	Missing    uint64      `json:"missing_bytes"`
+	Hosts      []*Host     `json:"hosts"`
+	Reservers  []*Reserver `json:"reservers"`
+}
+
+// Missing returns bytes to free for reservation to succeed.
+func Missing(w *clusterapi.WaitingForFreeSpace) uint64 {
+	need := w.RequiredBytes + w.SpaceToLeaveOnDiskBytes
+	have := w.AvailableBytes + w.ConsumedBytes
+	if need <= have {
+		return 0
+	}
+	return need - have
+}
+
+// Build collects disk space feedback of all workloads on hosts, hosts
+// without such feedback are left out. Hosts missing most space go first.
+func Build(hosts []*clusterapi.Host) *Report {
+	r := &Report{Hosts: make([]*Host, 0), Reservers: make([]*Reserver, 0)}
+	reservers := make(map[string]*Reserver)
+	reserver := func(name string) *Reserver {
+		if reservers[name] == nil {
+			reservers[name] = &Reserver{Reserver: name}
+			r.Reservers = append(r.Reservers, reservers[name])
+		}
+		return reservers[name]
+	}
+
+	for _, h := range hosts {
+		if h.Metadata == nil {
+			continue
+		}
+		hp := &Host{Host: h.Metadata.Id}
+		blockedBy := make(map[string]bool)
+		cachedBy := make(map[string]bool)
+		for _, wl := range h.Workloads {
+			for _, m := range feedback.All(wl) {
+				switch {
+				case m.WaitingForFreeSpace != nil:
+					w := m.WaitingForFreeSpace
+					b := &Blocked{
+						Group:     client.WorkloadGroup(wl),
+						Service:   client.WorkloadService(wl),
+						Reserver:  w.Reserver,
+						Required:  w.RequiredBytes,
+						Consumed:  w.ConsumedBytes,
+						Total:     w.TotalBytes,
+						Available: w.AvailableBytes,
+						Reserve:   w.SpaceToLeaveOnDiskBytes,
+						Missing:   Missing(w),
+					}
+					hp.Blocked = append(hp.Blocked, b)
+					hp.Missing += b.Missing
+					rs := reserver(w.Reserver)
+					rs.Blocked++
+					rs.Missing += b.Missing
+					if !blockedBy[w.Reserver] {
+						blockedBy[w.Reserver] = true
+						rs.Hosts++
+					}
+				case m.CachedResourceNotRemoved != nil:
+					c := m.CachedResourceNotRemoved
+					hp.Cached = append(hp.Cached, &Cached{
+						Group:     client.WorkloadGroup(wl),
+						Service:   client.WorkloadService(wl),
+						Reserver:  c.Reserver,
+						Available: c.AvailableBytes,
+						Reserve:   c.SpaceToLeaveOnDiskBytes,
+					})
+					if !cachedBy[c.Reserver] {
+						cachedBy[c.Reserver] = true
+						reserver(c.Reserver).CachedHosts++
+					}
+				}
+			}
+		}
+		if len(hp.Blocked) == 0 && len(hp.Cached) == 0 {
+			continue
+		}
+		if hp.Stuck() {
+			r.StuckHosts++
+		}
+		r.Missing += hp.Missing
+		r.Hosts = append(r.Hosts, hp)
+	}
+
+	sort.SliceStable(r.Hosts, func(i, j int) bool {
+		if r.Hosts[i].Missing != r.Hosts[j].Missing {
+			return r.Hosts[i].Missing > r.Hosts[j].Missing
+		}
+		return r.Hosts[i].Host < r.Hosts[j].Host
+	})
+	sort.SliceStable(r.Reservers, func(i, j int) bool {
+		if r.Reservers[i].Missing != r.Reservers[j].Missing {
+			return r.Reservers[i].Missing > r.Reservers[j].Missing
+		}
+		return r.Reservers[i].Reserver < r.Reservers[j].Reserver
+	})
+	return r
+}
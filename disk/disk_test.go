@@ -0,0 +1,74 @@
+package disk
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func waiting(reserver string, required, available, consumed, reserve uint64) *clusterapi.FeedbackMessage {
+	return &clusterapi.FeedbackMessage{WaitingForFreeSpace: &clusterapi.WaitingForFreeSpace{
+		Reserver:                reserver,
+		RequiredBytes:           required,
+		AvailableBytes:          available,
+		ConsumedBytes:           consumed,
+		SpaceToLeaveOnDiskBytes: reserve,
+	}}
+}
+
+func TestMissing(t *testing.T) {
+	tests := []struct {
+		name                                   string
+		required, available, consumed, reserve uint64
+		want                                   uint64
+	}{
+		{"enough space", 10, 20, 0, 5, 0},
+		{"short", 10, 5, 0, 0, 5},
+		{"reserve must stay free", 10, 10, 0, 3, 3},
+		{"consumed counts as free", 10, 4, 6, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := waiting("r", tt.required, tt.available, tt.consumed, tt.reserve).WaitingForFreeSpace
+			if got := Missing(w); got != tt.want {
+				t.Errorf("Missing = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	cached := &clusterapi.FeedbackMessage{CachedResourceNotRemoved: &clusterapi.CachedResourceNotRemoved{Reserver: "cache", AvailableBytes: 1}}
+	hosts := []*clusterapi.Host{
+		fixture.Host("a", fixture.Workload("g", "a", fixture.Pending(waiting("rbtorrent", 10, 5, 0, 0)))),
+		fixture.Host("b",
+			fixture.Workload("g", "b", fixture.Pending(waiting("rbtorrent", 100, 10, 0, 0))),
+			fixture.Workload("h", "b", fixture.Warning(waiting("rbtorrent", 30, 10, 0, 0)), fixture.Pending(cached))),
+		fixture.Host("c", fixture.Workload("g", "c", fixture.Pending(cached))),
+		fixture.Host("d", fixture.Workload("g", "d", fixture.Current("ACTIVE"))),
+	}
+	r := Build(hosts)
+
+	got := make([]string, 0)
+	for _, h := range r.Hosts {
+		got = append(got, fmt.Sprintf("%s missing %d blocked %d cached %d", h.Host, h.Missing, len(h.Blocked), len(h.Cached)))
+	}
+	for _, rs := range r.Reservers {
+		got = append(got, fmt.Sprintf("%s hosts %d blocked %d missing %d cached %d", rs.Reserver, rs.Hosts, rs.Blocked, rs.Missing, rs.CachedHosts))
+	}
+	want := []string{
+		"b missing 110 blocked 2 cached 1",
+		"a missing 5 blocked 1 cached 0",
+		"c missing 0 blocked 0 cached 1",
+		"rbtorrent hosts 2 blocked 3 missing 115 cached 0",
+		"cache hosts 0 blocked 0 missing 0 cached 2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Build =\n%v\nwant\n%v", got, want)
+	}
+	if r.StuckHosts != 2 || r.Missing != 115 {
+		t.Errorf("stuck hosts %d, missing %d, want 2 and 115", r.StuckHosts, r.Missing)
+	}
+}
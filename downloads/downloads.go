@@ -0,0 +1,237 @@
+// Package downloads collects resource download feedback of workloads and
+// estimates download throughput from consecutive snapshots.
+package downloads
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/feedback"
+	"sort"
+	"time"
+)
+
+// Download is one resource being fetched, failed or not ready on a host.
+type Download struct {
+	Host    string `json:"host"`
+	Group   string `json:"group"`
+	Service string `json:"service"`
+	// resource uuid if known from workload entity, url otherwise
+	Resource string `json:"resource"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	State    string `json:"state"`
+	Done     uint64 `json:"bytes_done,omitempty"`
+	Total    uint64 `json:"bytes_total,omitempty"`
+	Failed   bool   `json:"failed,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// bytes per second since previous snapshot, 0 if unknown
+	Rate float64 `json:"rate,omitempty"`
+}
+
+func (d *Download) key() string {
+	return d.Host + "|" + d.Group + "|" + d.Service + "|" + d.Resource + "|" + d.To
+}
+
+// Collect returns downloads reported by workloads.
+func Collect(wls []*clusterapi.Workload) []*Download {
+	result := make([]*Download, 0)
+	for _, wl := range wls {
+		byURL, byName := resources(wl)
+		base := Download{
+			Host:    client.WorkloadHost(wl),
+			Group:   client.WorkloadGroup(wl),
+			Service: client.WorkloadService(wl),
+		}
+		for _, m := range feedback.All(wl) {
+			switch {
+			case m.Progress != nil:
+				p := m.Progress
+				d := base
+				d.Resource, d.From, d.To, d.State = resourceID(byURL, p.From), p.From, p.To, p.State
+				d.Done, d.Total = p.BytesDone, p.BytesTotal
+				result = append(result, &d)
+			case m.DownloadFailed != nil:
+				f := m.DownloadFailed
+				d := base
+				d.Resource, d.From, d.To, d.State = resourceID(byURL, f.From), f.From, f.To, f.State
+				d.Failed, d.Reason = true, f.FailReason
+				result = append(result, &d)
+			case m.ResourcesNotReady != nil:
+				for name, state := range m.ResourcesNotReady.States {
+					d := base
+					d.Resource, d.State, d.Reason = name, state, m.ResourcesNotReady.FailReason
+					if id, ok := byName[name]; ok {
+						d.Resource = id
+					}
+					result = append(result, &d)
+				}
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].key() < result[j].key() })
+	return result
+}
+
+// resources maps urls and names of workload resources and volume layers to
+// their uuids, a volume of one layer is named by its own uuid.
+func resources(wl *clusterapi.Workload) (map[string]string, map[string]string) {
+	byURL := make(map[string]string)
+	byName := make(map[string]string)
+	var res map[string]*clusterapi.Resourcelike
+	var volumes []*clusterapi.Volume
+	switch {
+	case wl.Entity == nil:
+	case wl.Entity.Instance != nil:
+		res, volumes = wl.Entity.Instance.Resources, wl.Entity.Instance.Volumes
+	case wl.Entity.Job != nil:
+		res, volumes = wl.Entity.Job.Resources, wl.Entity.Job.Volumes
+	}
+	for _, v := range volumes {
+		for _, l := range v.Layers {
+			if l.Uuid == "" {
+				continue
+			}
+			for _, u := range l.Urls {
+				byURL[u] = l.Uuid
+			}
+		}
+		if len(v.Layers) == 1 && v.Uuid != "" && v.Layers[0].Uuid != "" {
+			byName[v.Uuid] = v.Layers[0].Uuid
+		}
+	}
+	for name, r := range res {
+		var uuid string
+		var urls []string
+		switch {
+		case r.Resource != nil:
+			uuid, urls = r.Resource.Uuid, r.Resource.Urls
+		case r.DynamicResource != nil:
+			uuid, urls = r.DynamicResource.Uuid, r.DynamicResource.Urls
+		case r.Shard != nil:
+			uuid = r.Shard.ShardId
+		}
+		if uuid == "" {
+			continue
+		}
+		byName[name] = uuid
+		for _, u := range urls {
+			byURL[u] = uuid
+		}
+	}
+	return byURL, byName
+}
+
+func resourceID(byURL map[string]string, url string) string {
+	if id, ok := byURL[url]; ok {
+		return id
+	}
+	return url
+}
+
+// Tracker remembers the previous snapshot to estimate download rates.
+type Tracker struct {
+	last map[string]uint64
+	at   time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{last: make(map[string]uint64)}
+}
+
+// Observe fills Rate of downloads taken at moment now from bytes done in the
+// previous snapshot and remembers them for the next one.
+func (t *Tracker) Observe(now time.Time, downloads []*Download) {
+	elapsed := now.Sub(t.at).Seconds()
+	next := make(map[string]uint64)
+	for _, d := range downloads {
+		if d.Failed || d.Total == 0 {
+			continue
+		}
+		k := d.key()
+		next[k] = d.Done
+		if prev, ok := t.last[k]; ok && elapsed > 0 && d.Done >= prev {
+			d.Rate = float64(d.Done-prev) / elapsed
+		}
+	}
+	t.last, t.at = next, now
+}
+
+// Resource is the state of one resource over all hosts.
+type Resource struct {
+	Resource string `json:"resource"`
+	InFlight int    `json:"in_flight"`
+	Failed   int    `json:"failed"`
+	NotReady int    `json:"not_ready"`
+	// distinct fail reasons
+	Reasons []string `json:"reasons,omitempty"`
+	Rate    float64  `json:"rate,omitempty"`
+}
+
+type Report struct {
+	InFlight  []*Download `json:"in_flight"`
+	Failed    []*Download `json:"failed"`
+	NotReady  []*Download `json:"not_ready"`
+	Rate      float64     `json:"rate,omitempty"`
+	Resources []*Resource `json:"resources"`
+}
+
+// Summarize splits downloads by kind and aggregates them by resource, the
+// resources failing on most hosts go first.
+func Summarize(downloads []*Download) *Report {
+	r := &Report{
+		InFlight:  make([]*Download, 0),
+		Failed:    make([]*Download, 0),
+		NotReady:  make([]*Download, 0),
+		Resources: make([]*Resource, 0),
+	}
+	byID := make(map[string]*Resource)
+	reasons := make(map[string]map[string]bool)
+	for _, d := range downloads {
+		res := byID[d.Resource]
+		if res == nil {
+			res = &Resource{Resource: d.Resource}
+			byID[d.Resource] = res
+			reasons[d.Resource] = make(map[string]bool)
+			r.Resources = append(r.Resources, res)
+		}
+		switch {
+		case d.Failed:
+			r.Failed = append(r.Failed, d)
+			res.Failed++
+		case d.Total > 0:
+			r.InFlight = append(r.InFlight, d)
+			res.InFlight++
+		default:
+			r.NotReady = append(r.NotReady, d)
+			res.NotReady++
+		}
+		if d.Reason != "" && !reasons[d.Resource][d.Reason] {
+			reasons[d.Resource][d.Reason] = true
+			res.Reasons = append(res.Reasons, d.Reason)
+		}
+		res.Rate += d.Rate
+		r.Rate += d.Rate
+	}
+	sort.SliceStable(r.Resources, func(i, j int) bool {
+		a, b := r.Resources[i], r.Resources[j]
+		if a.Failed != b.Failed {
+			return a.Failed > b.Failed
+		}
+		if a.NotReady != b.NotReady {
+			return a.NotReady > b.NotReady
+		}
+		return a.Resource < b.Resource
+	})
+	return r
+}
+
+// FailingOn returns resources failed on at least hosts hosts.
+func (r *Report) FailingOn(hosts int) []*Resource {
+	result := make([]*Resource, 0)
+	for _, res := range r.Resources {
+		if res.Failed >= hosts {
+			result = append(result, res)
+		}
+	}
+	return result
+}
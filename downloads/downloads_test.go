@@ -0,0 +1,35 @@
+package downloads
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"capi_tools/spec"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// volume layers are named by the volume uuid, the name of the task volume
+func TestCollectVolumes(t *testing.T) {
+	s := fixture.Spec("1", "a")
+	s.Volumes = map[string]spec.Volume{"data": {Url: "rbtorrent:data", Mount: "/data"}}
+	wl := s.Workload("a", "1")
+	fixture.Pending(&clusterapi.FeedbackMessage{Progress: &clusterapi.Progress{
+		From: "rbtorrent:data", To: "/place/data", BytesDone: 10, BytesTotal: 100, State: "DOWNLOADING",
+	}})(wl)
+	fixture.Failure(&clusterapi.FeedbackMessage{DownloadFailed: &clusterapi.DownloadFailed{
+		From: "rbtorrent:data", To: "/place/old", FailReason: "no peers",
+	}})(wl)
+	fixture.Pending(&clusterapi.FeedbackMessage{ResourcesNotReady: &clusterapi.ResourcesNotReady{
+		States: map[string]string{"data": "DOWNLOADING", "other": "PENDING"},
+	}})(wl)
+
+	got := make([]string, 0)
+	for _, d := range Collect([]*clusterapi.Workload{wl}) {
+		got = append(got, fmt.Sprintf("%s %s failed=%v", d.Resource, d.State, d.Failed))
+	}
+	want := []string{"data DOWNLOADING failed=false", "data DOWNLOADING failed=false", "data  failed=true", "other PENDING failed=false"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Collect = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,152 @@
+// Package drain plans moving our replicas off hosts going to maintenance.
+// New hosts are chosen with the placement engine, every group gets a plan
+// adding the new hosts and removing the drained ones.
+package drain
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/placement"
+	"capi_tools/plan"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Draining returns hosts of cstate Wall-E prepares for maintenance.
+func Draining(cstate *clusterapi.ClusterState) []string {
+	hosts := make([]string, 0)
+	for _, h := range cstate.Hosts {
+		md := h.Metadata
+		if md != nil && md.Health != nil && md.Health.State == clusterapi.HostHealthState_PREPARE_MAINTENANCE {
+			hosts = append(hosts, md.Id)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Groups returns groups of scheduler with workloads on hosts.
+func Groups(cstate *clusterapi.ClusterState, hosts []string, scheduler string) []string {
+	drained := make(map[string]bool)
+	for _, h := range hosts {
+		drained[h] = true
+	}
+	seen := make(map[string]bool)
+	groups := make([]string, 0)
+	for _, h := range cstate.Hosts {
+		if h.Metadata == nil || !drained[h.Metadata.Id] {
+			continue
+		}
+		for _, wl := range h.Workloads {
+			g := client.WorkloadGroup(wl)
+			if wl.SchedulerId != scheduler || g == "" || seen[g] {
+				continue
+			}
+			seen[g] = true
+			groups = append(groups, g)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Evacuation moves replicas of one group, Moves maps drained host to new one.
+type Evacuation struct {
+	Group string
+	Moves map[string]string
+	Plan  *plan.Plan
+}
+
+func (e *Evacuation) String() string {
+	moves := make([]string, 0, len(e.Moves))
+	for from, to := range e.Moves {
+		moves = append(moves, from+" -> "+to)
+	}
+	sort.Strings(moves)
+	return fmt.Sprintf("group %s: %s", e.Group, strings.Join(moves, ", "))
+}
+
+// NewCluster returns placement cluster of cstate where drained hosts never
+// receive replicas. Evacuations planned on it one after another see hosts
+// taken by the previous ones.
+func NewCluster(cstate *clusterapi.ClusterState, hosts []string) *placement.Cluster {
+	cluster := placement.NewCluster(cstate)
+	for _, h := range hosts {
+		if n := cluster.Node(h); n != nil {
+			n.Healthy = false
+		}
+	}
+	return cluster
+}
+
+// Plan evacuates groups of scheduler from hosts. Drained hosts never receive
+// replicas and at most one replica of a group goes to a host. Groups which
+// can not be placed are returned as errors, the rest is still planned.
+func Plan(cstate *clusterapi.ClusterState, hosts []string, scheduler string, s placement.Strategy) ([]*Evacuation, []error) {
+	cluster := NewCluster(cstate, hosts)
+	result := make([]*Evacuation, 0)
+	errs := make([]error, 0)
+	for _, group := range Groups(cstate, hosts, scheduler) {
+		e, err := PlanGroup(cluster, cstate, group, hosts, s)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		result = append(result, e)
+	}
+	return result, errs
+}
+
+// PlanGroup evacuates group from hosts reserving new hosts on cluster, the
+// plan is made against cstate. Nothing stays reserved when the group can not
+// be placed.
+func PlanGroup(cluster *placement.Cluster, cstate *clusterapi.ClusterState, group string, hosts []string,
+	s placement.Strategy) (*Evacuation, error) {
+	byId := make(map[string]*clusterapi.Host)
+	for _, h := range cstate.Hosts {
+		if h.Metadata != nil {
+			byId[h.Metadata.Id] = h
+		}
+	}
+
+	e := &Evacuation{Group: group, Moves: make(map[string]string)}
+	others := make([]*clusterapi.Host, 0)
+	placed := make([]*placement.Result, 0)
+	var err error
+	for _, h := range hosts {
+		if byId[h] == nil {
+			continue
+		}
+		wls := client.GroupWorkloads(group, []*clusterapi.Host{byId[h]})
+		if len(wls) == 0 {
+			continue
+		}
+		var need placement.Resources
+		for _, wl := range wls {
+			need = need.Add(placement.FromComputing(client.WorkloadResources(wl)))
+		}
+		req := &placement.Request{Group: group, Replicas: 1, Resources: need, AntiAffinity: placement.Host}
+		var res *placement.Result
+		if res, err = placement.Place(cluster, req, s); err != nil {
+			err = fmt.Errorf("can not move group %s off %s: %v", group, h, err)
+			break
+		}
+		placed = append(placed, res)
+		e.Moves[h] = res.Hosts[0]
+		others = append(others, byId[res.Hosts[0]])
+	}
+	if err == nil {
+		e.Plan, err = plan.Evacuate(group, cstate.Hosts, others, e.Moves)
+	}
+	if err != nil {
+		// give resources taken for this group back
+		for _, res := range placed {
+			n := cluster.Node(res.Hosts[0])
+			n.Used = n.Used.Sub(res.Request.Resources)
+			n.Groups[group]--
+		}
+		return nil, err
+	}
+	return e, nil
+}
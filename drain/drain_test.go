@@ -0,0 +1,66 @@
+package drain
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"capi_tools/placement"
+	"reflect"
+	"testing"
+)
+
+func workload(group, host string) *clusterapi.Workload {
+	return fixture.Workload(group, host, fixture.Generation("1"), fixture.Resources(600, 1<<20),
+		func(wl *clusterapi.Workload) { wl.SchedulerId = "capi_tools" })
+}
+
+func state() *clusterapi.ClusterState {
+	return fixture.State(
+		fixture.Health(fixture.Host("d", workload("g1", "d"), workload("g2", "d")), clusterapi.HostHealthState_PREPARE_MAINTENANCE),
+		fixture.Host("a"),
+		fixture.Host("b"),
+	)
+}
+
+func TestPlan(t *testing.T) {
+	cstate := state()
+	hosts := Draining(cstate)
+	if !reflect.DeepEqual(hosts, []string{"d"}) {
+		t.Fatalf("draining %v", hosts)
+	}
+	evacuations, errs := Plan(cstate, hosts, "capi_tools", placement.FirstFit{})
+	if len(errs) != 0 || len(evacuations) != 2 {
+		t.Fatalf("Plan() = %v, %v", evacuations, errs)
+	}
+	// a host fits one of the groups only
+	if to1, to2 := evacuations[0].Moves["d"], evacuations[1].Moves["d"]; to1 == to2 || to1 == "d" || to2 == "d" {
+		t.Errorf("moves %v and %v", evacuations[0], evacuations[1])
+	}
+}
+
+func TestPlanGroupSharesCluster(t *testing.T) {
+	cstate := state()
+	cluster := NewCluster(cstate, []string{"d"})
+	if _, err := PlanGroup(cluster, cstate, "g1", []string{"d"}, placement.FirstFit{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// g1 moved meanwhile, the next group is planned on fresh state
+	fresh := state()
+	fresh.Hosts[0].Workloads = fresh.Hosts[0].Workloads[1:]
+	fresh.Hosts[1].Workloads = []*clusterapi.Workload{workload("g1", "a")}
+	fresh.Hosts[1].Metadata.Etag = 2
+	e, err := PlanGroup(cluster, fresh, "g2", []string{"d"}, placement.FirstFit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Moves["d"] != "b" {
+		t.Errorf("g2 moves to %s, want b as a is taken by g1", e.Moves["d"])
+	}
+
+	if _, err := PlanGroup(cluster, state(), "g1", []string{"d"}, placement.FirstFit{}); err == nil {
+		t.Error("g1 placed on a full cluster")
+	}
+	if n := cluster.Node("b"); n.Groups["g1"] != 0 {
+		t.Errorf("failed placement left g1 reserved on b: %v", n.Groups)
+	}
+}
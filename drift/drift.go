@@ -0,0 +1,167 @@
+// Package drift compares task specs with what capi reports for their groups
+// without changing anything.
+package drift
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/ipbroker"
+	"capi_tools/plan"
+	"capi_tools/spec"
+	"fmt"
+	"sort"
+)
+
+// kinds of findings
+const (
+	// replica the spec asks for is not running
+	Missing = "missing"
+	// replica runs on a host the spec does not list
+	Extra = "extra"
+	// workloads differ from the spec
+	Changed = "changed"
+	// workload was last written by another scheduler
+	Foreign = "foreign"
+	// workload has not reached its target state
+	NotReached = "not-reached"
+	// spec host is not in the cluster
+	UnknownHost = "unknown-host"
+	// number of replicas differs from spec replicas
+	Replicas = "replicas"
+)
+
+type Finding struct {
+	Kind    string             `json:"kind"`
+	Host    string             `json:"host,omitempty"`
+	Message string             `json:"message,omitempty"`
+	Fields  []plan.FieldChange `json:"fields,omitempty"`
+}
+
+func (f *Finding) String() string {
+	line := f.Kind
+	if f.Host != "" {
+		line = f.Host + ": " + line
+	}
+	if f.Message != "" {
+		line += ", " + f.Message
+	}
+	return line
+}
+
+// Report is drift of one group.
+type Report struct {
+	Group       string     `json:"group"`
+	File        string     `json:"file"`
+	Fingerprint string     `json:"fingerprint"`
+	Hosts       int        `json:"hosts"`
+	Findings    []*Finding `json:"findings"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Drifted reports whether the group differs from its spec or could not be checked.
+func (r *Report) Drifted() bool {
+	return len(r.Findings) > 0 || r.Error != ""
+}
+
+// Check compares s loaded from file with cstate.
+func Check(s *spec.Spec, file string, cstate *clusterapi.ClusterState) *Report {
+	group := s.GroupId()
+	r := &Report{Group: group, File: file, Fingerprint: s.Fingerprint(), Findings: make([]*Finding, 0)}
+
+	byId := make(map[string]*clusterapi.Host)
+	current := make([]*clusterapi.Host, 0)
+	for _, h := range cstate.Hosts {
+		if h.Metadata == nil {
+			continue
+		}
+		byId[h.Metadata.Id] = h
+		if wls := client.GroupWorkloads(group, []*clusterapi.Host{h}); len(wls) > 0 {
+			current = append(current, &clusterapi.Host{Metadata: h.Metadata, Workloads: wls})
+		}
+	}
+
+	task := *s
+	task.HostProperties = make(map[string]map[string]string)
+	for _, h := range current {
+		// addresses come from ip-broker, not from the spec
+		props := make(map[string]string)
+		for _, wl := range h.Workloads {
+			for _, name := range []string{ipbroker.PropAddress, ipbroker.PropHostname} {
+				if v, ok := wl.Properties[name]; ok {
+					props[name] = v
+				}
+			}
+		}
+		task.HostProperties[h.Metadata.Id] = props
+	}
+
+	others := make([]*clusterapi.Host, 0)
+	if len(s.Hosts) > 0 {
+		task.Hosts = make([]string, 0, len(s.Hosts))
+		for _, h := range s.Hosts {
+			if byId[h] == nil {
+				r.Findings = append(r.Findings, &Finding{Kind: UnknownHost, Host: h, Message: "host is not in cluster state"})
+				continue
+			}
+			task.Hosts = append(task.Hosts, h)
+			others = append(others, byId[h])
+		}
+	}
+	if s.Replicas > 0 && len(s.Hosts) == 0 && len(current) != s.Replicas {
+		r.Findings = append(r.Findings, &Finding{
+			Kind:    Replicas,
+			Message: fmt.Sprintf("%d replicas running, spec wants %d", len(current), s.Replicas),
+		})
+	}
+	r.Hosts = len(current)
+	if len(current) == 0 && len(task.Hosts) == 0 {
+		r.Findings = append(r.Findings, &Finding{Kind: Missing, Message: "group runs nowhere"})
+		return r
+	}
+
+	p, err := plan.Make(&task, current, others)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	changed := make(map[string]bool)
+	for _, c := range p.Changes {
+		switch c.Action {
+		case plan.Add:
+			r.Findings = append(r.Findings, &Finding{Kind: Missing, Host: c.Host})
+		case plan.Remove:
+			r.Findings = append(r.Findings, &Finding{Kind: Extra, Host: c.Host})
+		case plan.Modify:
+			changed[c.Host] = true
+			r.Findings = append(r.Findings, &Finding{Kind: Changed, Host: c.Host, Fields: c.Fields})
+		}
+	}
+
+	for _, h := range current {
+		id := h.Metadata.Id
+		for _, wl := range h.Workloads {
+			if wl.SchedulerId != s.SchedulerName() {
+				r.Findings = append(r.Findings, &Finding{
+					Kind:    Foreign,
+					Host:    id,
+					Message: fmt.Sprintf("workload %s written by scheduler %q", client.WorkloadService(wl), wl.SchedulerId),
+				})
+			}
+		}
+		if changed[id] {
+			continue
+		}
+		for _, d := range plan.Divergences(group, []*clusterapi.Host{h}) {
+			if d.Diverged() {
+				r.Findings = append(r.Findings, &Finding{
+					Kind:    NotReached,
+					Host:    id,
+					Message: fmt.Sprintf("%s generation %s is %s, target %s", d.Service, d.Generation, d.Current, d.Target),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(r.Findings, func(i, j int) bool { return r.Findings[i].Host < r.Findings[j].Host })
+	return r
+}
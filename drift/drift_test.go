@@ -0,0 +1,124 @@
+package drift
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"capi_tools/spec"
+	"reflect"
+	"testing"
+)
+
+func task(version string, replicas int, hosts ...string) *spec.Spec {
+	s := fixture.Spec(version, hosts...)
+	s.Replicas = replicas
+	return s
+}
+
+// host runs workloads of s on id, reaching their target state when ready.
+func host(id string, s *spec.Spec, ready bool) *clusterapi.Host {
+	if s == nil {
+		return fixture.Host(id)
+	}
+	wl := s.Workload(id, "1")
+	if ready {
+		fixture.Current(wl.TargetState)(wl)
+	}
+	return fixture.Host(id, wl)
+}
+
+func kinds(r *Report) []string {
+	result := make([]string, 0, len(r.Findings))
+	for _, f := range r.Findings {
+		result = append(result, f.Host+" "+f.Kind)
+	}
+	return result
+}
+
+func TestCheck(t *testing.T) {
+	v1 := task("1", 0)
+	foreign := host("a", v1, true)
+	foreign.Workloads[0].SchedulerId = "someone"
+	other := task("1", 0, "a")
+	other.Scheduler = "someone"
+	tests := []struct {
+		name  string
+		task  *spec.Spec
+		hosts []*clusterapi.Host
+		want  []string
+	}{
+		{
+			name:  "in sync",
+			task:  task("1", 0, "a", "b"),
+			hosts: []*clusterapi.Host{host("a", v1, true), host("b", v1, true)},
+			want:  []string{},
+		},
+		{
+			name:  "running hosts kept",
+			task:  task("1", 0),
+			hosts: []*clusterapi.Host{host("a", v1, true), host("c", nil, false)},
+			want:  []string{},
+		},
+		{
+			name:  "missing and extra replicas",
+			task:  task("1", 0, "a", "c"),
+			hosts: []*clusterapi.Host{host("a", v1, true), host("b", v1, true), host("c", nil, false)},
+			want:  []string{"b extra", "c missing"},
+		},
+		{
+			name:  "changed spec",
+			task:  task("2", 0, "a"),
+			hosts: []*clusterapi.Host{host("a", v1, true)},
+			want:  []string{"a changed"},
+		},
+		{
+			name:  "not reached",
+			task:  task("1", 0, "a"),
+			hosts: []*clusterapi.Host{host("a", v1, false)},
+			want:  []string{"a not-reached"},
+		},
+		{
+			name:  "foreign scheduler",
+			task:  task("1", 0, "a"),
+			hosts: []*clusterapi.Host{foreign},
+			want:  []string{"a changed", "a foreign"},
+		},
+		{
+			name:  "configured scheduler",
+			task:  other,
+			hosts: []*clusterapi.Host{foreign},
+			want:  []string{},
+		},
+		{
+			name:  "unknown host",
+			task:  task("1", 0, "a", "x"),
+			hosts: []*clusterapi.Host{host("a", v1, true)},
+			want:  []string{"x unknown-host"},
+		},
+		{
+			name:  "replicas",
+			task:  task("1", 2),
+			hosts: []*clusterapi.Host{host("a", v1, true)},
+			want:  []string{" replicas"},
+		},
+		{
+			name:  "group runs nowhere",
+			task:  task("1", 0),
+			hosts: []*clusterapi.Host{host("a", nil, false)},
+			want:  []string{" missing"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Check(tt.task, "task.yaml", fixture.State(tt.hosts...))
+			if r.Error != "" {
+				t.Fatal(r.Error)
+			}
+			if got := kinds(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findings %v, want %v", got, tt.want)
+			}
+			if r.Drifted() != (len(tt.want) > 0) {
+				t.Errorf("drifted %v with findings %v", r.Drifted(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"capi_tools/ipbroker"
+	"flag"
+	"log"
+	"net/http"
+)
+
+var listen = flag.String("listen", "localhost:8090", "address to serve fake ip-broker on")
+var token = flag.String("token", "", "require this OAuth token, any if empty")
+
+func main() {
+	flag.Parse()
+	f := ipbroker.NewFake()
+	f.Token = *token
+	log.Printf("fake ip-broker on http://%s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, f))
+}
@@ -0,0 +1,146 @@
+// Package feedback walks feedback messages agents report for workloads.
+package feedback
+
+import (
+	"capi_tools/clusterapi"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kind is the CurrentStateFeedback list a message came from.
+type Kind string
+
+const (
+	Metric  Kind = "metric"
+	Pending Kind = "pending"
+	Info    Kind = "info"
+	Warning Kind = "warning"
+	Failure Kind = "failure"
+)
+
+type Message struct {
+	Kind Kind
+	*clusterapi.FeedbackMessage
+}
+
+// All returns every feedback message of workload, failures first.
+func All(wl *clusterapi.Workload) []Message {
+	result := make([]Message, 0)
+	if wl.Feedback == nil || wl.Feedback.Feedback == nil {
+		return result
+	}
+	f := wl.Feedback.Feedback
+	add := func(kind Kind, msgs []*clusterapi.FeedbackMessage) {
+		for _, m := range msgs {
+			if m != nil {
+				result = append(result, Message{kind, m})
+			}
+		}
+	}
+	add(Failure, f.Failures)
+	add(Warning, f.Warnings)
+	add(Pending, f.PendingStateMessages)
+	add(Info, f.Info)
+	add(Metric, f.Metrics)
+	return result
+}
+
+// CurrentState returns current state reported by agent or UNKNOWN.
+func CurrentState(wl *clusterapi.Workload) string {
+	if wl.Feedback == nil || wl.Feedback.CurrentState == "" {
+		return "UNKNOWN"
+	}
+	return wl.Feedback.CurrentState
+}
+
+// Describe returns one line description of message.
+func Describe(m *clusterapi.FeedbackMessage) string {
+	switch {
+	case m.FailMessage != nil:
+		return fmt.Sprintf("%s: %s", m.FailMessage.State, m.FailMessage.FailReason)
+	case m.HookFailure != nil:
+		return fmt.Sprintf("hook %s failed (%s)", m.HookFailure.Hook, m.HookFailure.State)
+	case m.CountLimit != nil:
+		return fmt.Sprintf("attempts limit reached: %d of %d", m.CountLimit.Attempts, m.CountLimit.MaxAttempts)
+	case m.TimeLimitViolation != nil:
+		t := m.TimeLimitViolation
+		return fmt.Sprintf("time limit %s exceeded, running for %s since %s", t.Duration, t.DurationFromFirstRun, t.FirstRun)
+	case m.FrequencyLimit != nil:
+		f := m.FrequencyLimit
+		return fmt.Sprintf("started too often: last run %s, now %s, allowed gap %s", f.LastRun, f.Now, f.InvocationGap)
+	case m.Progress != nil:
+		p := m.Progress
+		return fmt.Sprintf("downloading %s: %s", p.From, Percent(p.BytesDone, p.BytesTotal))
+	case m.ChecksumProgress != nil:
+		return fmt.Sprintf("verifying checksum of %s", m.ChecksumProgress.TargetFile)
+	case m.HookInProgress != nil:
+		return fmt.Sprintf("hook %s running", m.HookInProgress.Hook)
+	case m.Lock != nil:
+		return fmt.Sprintf("waiting for lock %s held by %s", m.Lock.Lock, m.Lock.LockedBy)
+	case m.ProcessFeedback != nil:
+		p := m.ProcessFeedback
+		return fmt.Sprintf("%s %s: exit code %d, signal %d", p.ExecutableName, p.State, p.ExitCode, p.SignalNumber)
+	case m.DownloadFailed != nil:
+		d := m.DownloadFailed
+		return fmt.Sprintf("download of %s failed: %s", d.From, d.FailReason)
+	case m.ValidationFailed != nil:
+		v := m.ValidationFailed
+		return fmt.Sprintf("validation of %s failed: %s", v.From, v.FailReason)
+	case m.ChecksumVerificationFailure != nil:
+		c := m.ChecksumVerificationFailure
+		return fmt.Sprintf("checksum of %s mismatch: expected %s, got %s", c.TargetFile, c.Expected, c.Calculated)
+	case m.DaemonFailure != nil:
+		return fmt.Sprintf("daemon %s failed: %s", m.DaemonFailure.Hook, m.DaemonFailure.FailReason)
+	case m.DirectoryFailure != nil:
+		return fmt.Sprintf("directory %s: %s", m.DirectoryFailure.Directory, m.DirectoryFailure.FailReason)
+	case m.ShardFailure != nil:
+		return fmt.Sprintf("shard %s failed: %s", m.ShardFailure.ShardId, m.ShardFailure.FailReason)
+	case m.WaitingForFreeSpace != nil:
+		w := m.WaitingForFreeSpace
+		return fmt.Sprintf("waiting for free space: need %s, available %s", HumanBytes(w.RequiredBytes), HumanBytes(w.AvailableBytes))
+	case m.CachedResourceNotRemoved != nil:
+		return fmt.Sprintf("cached resource of %s not removed", m.CachedResourceNotRemoved.Reserver)
+	case m.ResourcesNotReady != nil:
+		r := m.ResourcesNotReady
+		names := make([]string, 0, len(r.States))
+		for name, state := range r.States {
+			names = append(names, name+"="+state)
+		}
+		sort.Strings(names)
+		return fmt.Sprintf("resources not ready: %s", strings.Join(names, ", "))
+	case m.PendingRemove != nil:
+		return fmt.Sprintf("pending removal of %s by %s", m.PendingRemove.Id, m.PendingRemove.RequesterId)
+	case m.SelfHelp != nil:
+		return fmt.Sprintf("self help: %s %s", m.SelfHelp.Cause, m.SelfHelp.Url)
+	case m.CountersFeedback != nil:
+		return fmt.Sprintf("%d counters of %s", len(m.CountersFeedback.Counters), m.CountersFeedback.Container)
+	case m.FeedbackOkMessage != nil:
+		return m.FeedbackOkMessage.State
+	case m.FeedbackMergeMessage != nil:
+		return m.FeedbackMergeMessage.State
+	}
+	return "empty message"
+}
+
+// Percent formats done of total like "45% (12.1M/27.0M)".
+func Percent(done, total uint64) string {
+	if total == 0 {
+		return HumanBytes(done)
+	}
+	return fmt.Sprintf("%d%% (%s/%s)", done*100/total, HumanBytes(done), HumanBytes(total))
+}
+
+// HumanBytes formats n like 1.5G.
+func HumanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,104 @@
+package feedback
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"fmt"
+	"time"
+)
+
+// Process is the last known result of one executable of a workload.
+type Process struct {
+	Host       string
+	Service    string
+	Generation string
+	Executable string
+	State      string
+	ExitCode   uint32
+	Signal     uint32
+	OOM        bool
+	// ram limit of the workload container
+	RamLimit uint64
+	StdOut   string
+	StdErr   string
+	// when agent reported the feedback
+	Reported time.Time
+}
+
+// Processes returns process feedback of workload, one per executable. The
+// agent appends a message each time a process ends and All walks stale
+// failures before the lists describing the current run, so the last message
+// of an executable is its most recent result.
+func Processes(wl *clusterapi.Workload) []*Process {
+	var reported time.Time
+	if wl.Feedback != nil && wl.Feedback.HostTimestamp != 0 {
+		reported = Time(wl.Feedback.HostTimestamp)
+	}
+
+	result := make([]*Process, 0)
+	index := make(map[string]int)
+	for _, m := range All(wl) {
+		p := m.ProcessFeedback
+		if p == nil {
+			continue
+		}
+		proc := &Process{
+			Host:       client.WorkloadHost(wl),
+			Service:    client.WorkloadService(wl),
+			Generation: wl.Generation,
+			Executable: p.ExecutableName,
+			State:      p.State,
+			ExitCode:   p.ExitCode,
+			Signal:     p.SignalNumber,
+			OOM:        p.OutOfMemory,
+			RamLimit:   client.WorkloadResources(wl).RamBytes,
+			StdOut:     p.StdOut,
+			StdErr:     p.StdErr,
+			Reported:   reported,
+		}
+		if i, ok := index[p.ExecutableName]; ok {
+			result[i] = proc
+			continue
+		}
+		index[p.ExecutableName] = len(result)
+		result = append(result, proc)
+	}
+	return result
+}
+
+// Summary is one line about how the process ended.
+func (p *Process) Summary() string {
+	line := fmt.Sprintf("%s %s exit code %d", p.Executable, p.State, p.ExitCode)
+	if p.Signal != 0 {
+		line += fmt.Sprintf(", signal %s", SignalName(p.Signal))
+	}
+	if p.OOM {
+		line += fmt.Sprintf(", OOM killed, ram limit %s", HumanBytes(p.RamLimit))
+	}
+	return line
+}
+
+// Time converts agent timestamp which may be in seconds or milliseconds.
+func Time(ts uint64) time.Time {
+	if ts > 1e12 {
+		return time.Unix(0, int64(ts)*int64(time.Millisecond))
+	}
+	return time.Unix(int64(ts), 0)
+}
+
+var signalNames = map[uint32]string{
+	1: "SIGHUP", 2: "SIGINT", 3: "SIGQUIT", 4: "SIGILL", 5: "SIGTRAP", 6: "SIGABRT",
+	7: "SIGBUS", 8: "SIGFPE", 9: "SIGKILL", 10: "SIGUSR1", 11: "SIGSEGV", 12: "SIGUSR2",
+	13: "SIGPIPE", 14: "SIGALRM", 15: "SIGTERM", 16: "SIGSTKFLT", 17: "SIGCHLD", 18: "SIGCONT",
+	19: "SIGSTOP", 20: "SIGTSTP", 21: "SIGTTIN", 22: "SIGTTOU", 23: "SIGURG", 24: "SIGXCPU",
+	25: "SIGXFSZ", 26: "SIGVTALRM", 27: "SIGPROF", 28: "SIGWINCH", 29: "SIGIO", 30: "SIGPWR",
+	31: "SIGSYS",
+}
+
+// SignalName returns linux name of signal like SIGKILL(9).
+func SignalName(n uint32) string {
+	if name, ok := signalNames[n]; ok {
+		return fmt.Sprintf("%s(%d)", name, n)
+	}
+	return fmt.Sprintf("%d", n)
+}
@@ -0,0 +1,58 @@
+package feedback
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"reflect"
+	"testing"
+)
+
+func process(name, state string, code uint32) *clusterapi.FeedbackMessage {
+	return &clusterapi.FeedbackMessage{ProcessFeedback: &clusterapi.ProcessFeedback{ExecutableName: name, State: state, ExitCode: code}}
+}
+
+func TestProcesses(t *testing.T) {
+	tests := []struct {
+		name string
+		wl   *clusterapi.Workload
+		want []string
+	}{
+		{
+			name: "one per executable",
+			wl: fixture.Workload("g", "a",
+				fixture.Failure(process("install", "HOOK_FAILED", 1)),
+				fixture.Pending(process("start", "DAEMON_EXITED", 0))),
+			want: []string{"install HOOK_FAILED exit code 1", "start DAEMON_EXITED exit code 0"},
+		},
+		{
+			name: "stale failure replaced by the current run",
+			wl: fixture.Workload("g", "a",
+				fixture.Failure(process("install", "HOOK_FAILED", 1)),
+				fixture.Pending(process("install", "HOOK_EXITED", 0))),
+			want: []string{"install HOOK_EXITED exit code 0"},
+		},
+		{
+			name: "last of a list",
+			wl: fixture.Workload("g", "a",
+				fixture.Failure(process("install", "HOOK_FAILED", 1)),
+				fixture.Failure(process("install", "HOOK_FAILED", 2))),
+			want: []string{"install HOOK_FAILED exit code 2"},
+		},
+		{
+			name: "no feedback",
+			wl:   fixture.Workload("g", "a"),
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, p := range Processes(tt.wl) {
+				got = append(got, p.Summary())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Processes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
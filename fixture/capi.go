@@ -0,0 +1,169 @@
+package fixture
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Capi is a fake capi serving Hosts. Applied transitions replace workloads
+// of their group when every host etag matches, then the host etag grows and
+// workloads report their target state as current.
+type Capi struct {
+	Hosts     []*clusterapi.Host
+	Applied   []*clusterapi.GroupTransition
+	Destroyed []string
+
+	mu      sync.Mutex
+	version uint64
+}
+
+// NewCapi returns a fake capi serving hosts.
+func NewCapi(hosts ...*clusterapi.Host) *Capi {
+	return &Capi{Hosts: hosts}
+}
+
+// Touch bumps etag of host as a change made by somebody else would.
+func (f *Capi) Touch(host string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if h := f.host(host); h != nil {
+		h.Metadata.Etag++
+		f.version++
+	}
+}
+
+func (f *Capi) host(id string) *clusterapi.Host {
+	for _, h := range f.Hosts {
+		if h.Metadata.Id == id {
+			return h
+		}
+	}
+	return nil
+}
+
+func (f *Capi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var resp proto.Message
+	switch r.URL.Path {
+	case "/state/full":
+		req := new(clusterapi.GetStateRequest)
+		if err := proto.Unmarshal(body, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp = f.state(req.HostFilter, req.WorkloadFilter)
+	case "/state/delta":
+		// nothing changes between requests of a test
+		resp = &clusterapi.ClusterStateDelta{Version: f.clusterVersion()}
+	case "/apply/group":
+		req := new(clusterapi.ApplyGroupTransitionRequest)
+		if err := proto.Unmarshal(body, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result := &clusterapi.ApplyGroupTransitionResponse{}
+		for _, gt := range req.GroupTransitions {
+			result.Results = append(result.Results, f.apply(gt))
+		}
+		resp = result
+	case "/destroy":
+		req := new(clusterapi.DestroyRequest)
+		if err := proto.Unmarshal(body, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result := &clusterapi.DestroyResponse{}
+		for _, g := range req.GroupsToDestroy {
+			f.replace(g.GroupId, "", nil)
+			f.Destroyed = append(f.Destroyed, g.GroupId)
+			result.Results = append(result.Results, &clusterapi.DestroyGroupEither{GroupId: g.GroupId})
+		}
+		resp = result
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+func (f *Capi) clusterVersion() *clusterapi.ClusterVersion {
+	return &clusterapi.ClusterVersion{Versions: map[string]uint64{"fake": f.version}}
+}
+
+// state returns hosts named in hostFilter, all when it is empty, with
+// workloads of the group named in workloadFilter.
+func (f *Capi) state(hostFilter, workloadFilter string) *clusterapi.ClusterState {
+	cstate := &clusterapi.ClusterState{Version: f.clusterVersion()}
+	for _, h := range f.Hosts {
+		if hostFilter != "" && !strings.Contains(hostFilter, "'"+h.Metadata.Id+"'") {
+			continue
+		}
+		wls := make([]*clusterapi.Workload, 0, len(h.Workloads))
+		for _, wl := range h.Workloads {
+			if workloadFilter == "" || workloadFilter == client.GroupFilter(client.WorkloadGroup(wl)) {
+				wls = append(wls, wl)
+			}
+		}
+		cstate.Hosts = append(cstate.Hosts, &clusterapi.Host{Metadata: h.Metadata, Workloads: wls})
+	}
+	return cstate
+}
+
+func (f *Capi) apply(gt *clusterapi.GroupTransition) *clusterapi.ApplyGroupEither {
+	f.Applied = append(f.Applied, gt)
+	for _, t := range gt.Transitions {
+		if h := f.host(t.HostId); h == nil || h.Metadata.Etag != t.HostStateEtag {
+			return &clusterapi.ApplyGroupEither{GroupId: gt.GroupId, Exception: &clusterapi.Exception{
+				DetailMessage: "etag of " + t.HostId + " changed",
+				TransitionValidationException: &clusterapi.TransitionValidationException{
+					EtagFailureException: &clusterapi.EtagFailureException{},
+				},
+			}}
+		}
+	}
+	for _, t := range gt.Transitions {
+		for _, wl := range t.Workloads {
+			Current(wl.TargetState)(wl)
+		}
+		f.replace(gt.GroupId, t.HostId, t.Workloads)
+	}
+	return &clusterapi.ApplyGroupEither{GroupId: gt.GroupId}
+}
+
+// replace sets workloads of group on host, on every host when host is empty.
+func (f *Capi) replace(group, host string, wls []*clusterapi.Workload) {
+	for _, h := range f.Hosts {
+		if host != "" && h.Metadata.Id != host {
+			continue
+		}
+		kept := make([]*clusterapi.Workload, 0, len(h.Workloads)+len(wls))
+		for _, wl := range h.Workloads {
+			if client.WorkloadGroup(wl) != group {
+				kept = append(kept, wl)
+			}
+		}
+		if len(kept) != len(h.Workloads) || len(wls) > 0 {
+			h.Metadata.Etag++
+		}
+		h.Workloads = append(kept, wls...)
+	}
+	f.version++
+}
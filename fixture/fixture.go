@@ -0,0 +1,205 @@
+// Package fixture builds cluster state for tests: hosts, workloads and
+// workloads of task specs, plus a fake capi serving that state.
+package fixture
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/spec"
+)
+
+// defaults of workloads built by Workload
+const (
+	Service = "svc"
+	Owner   = "o"
+	Project = "P"
+)
+
+// Workload returns an ACTIVE workload of group on host, changed by opts.
+func Workload(group, host string, opts ...func(*clusterapi.Workload)) *clusterapi.Workload {
+	wl := &clusterapi.Workload{
+		Owner: &clusterapi.Owner{OwnerId: Owner, ProjectId: Project},
+		Id: &clusterapi.WorkloadId{
+			Slot:          &clusterapi.Slot{Service: Service, Host: host},
+			Configuration: &clusterapi.ConfigurationId{GroupId: group},
+		},
+		TargetState: "ACTIVE",
+	}
+	for _, o := range opts {
+		o(wl)
+	}
+	return wl
+}
+
+// Generation sets workload generation.
+func Generation(gen string) func(*clusterapi.Workload) {
+	return func(wl *clusterapi.Workload) { wl.Generation = gen }
+}
+
+// Target sets workload target state.
+func Target(state string) func(*clusterapi.Workload) {
+	return func(wl *clusterapi.Workload) { wl.TargetState = state }
+}
+
+// Current sets state the agent reports for the workload, empty state means
+// the agent reported nothing.
+func Current(state string) func(*clusterapi.Workload) {
+	return func(wl *clusterapi.Workload) {
+		if state == "" {
+			return
+		}
+		if wl.Feedback == nil {
+			wl.Feedback = &clusterapi.DetailedCurrentState{}
+		}
+		wl.Feedback.CurrentState = state
+	}
+}
+
+// Failed adds a failure with reason to workload feedback.
+func Failed(reason string) func(*clusterapi.Workload) {
+	return Failure(&clusterapi.FeedbackMessage{FailMessage: &clusterapi.FeedbackFailMessage{FailReason: reason}})
+}
+
+// Failure adds m to failures of workload feedback.
+func Failure(m *clusterapi.FeedbackMessage) func(*clusterapi.Workload) {
+	return func(wl *clusterapi.Workload) {
+		f := feedback(wl)
+		f.Failures = append(f.Failures, m)
+	}
+}
+
+// Warning adds m to warnings of workload feedback.
+func Warning(m *clusterapi.FeedbackMessage) func(*clusterapi.Workload) {
+	return func(wl *clusterapi.Workload) {
+		f := feedback(wl)
+		f.Warnings = append(f.Warnings, m)
+	}
+}
+
+// Pending adds m to pending state messages of workload feedback.
+func Pending(m *clusterapi.FeedbackMessage) func(*clusterapi.Workload) {
+	return func(wl *clusterapi.Workload) {
+		f := feedback(wl)
+		f.PendingStateMessages = append(f.PendingStateMessages, m)
+	}
+}
+
+// Metric adds m to metrics of workload feedback.
+func Metric(m *clusterapi.FeedbackMessage) func(*clusterapi.Workload) {
+	return func(wl *clusterapi.Workload) {
+		f := feedback(wl)
+		f.Metrics = append(f.Metrics, m)
+	}
+}
+
+func feedback(wl *clusterapi.Workload) *clusterapi.CurrentStateFeedback {
+	if wl.Feedback == nil {
+		wl.Feedback = &clusterapi.DetailedCurrentState{}
+	}
+	if wl.Feedback.Feedback == nil {
+		wl.Feedback.Feedback = &clusterapi.CurrentStateFeedback{}
+	}
+	return wl.Feedback.Feedback
+}
+
+// OwnedBy sets owner and project of workload.
+func OwnedBy(owner, project string) func(*clusterapi.Workload) {
+	return func(wl *clusterapi.Workload) { wl.Owner = &clusterapi.Owner{OwnerId: owner, ProjectId: project} }
+}
+
+// Property sets workload property.
+func Property(name, value string) func(*clusterapi.Workload) {
+	return func(wl *clusterapi.Workload) {
+		if wl.Properties == nil {
+			wl.Properties = make(map[string]string)
+		}
+		wl.Properties[name] = value
+	}
+}
+
+// Resources sets cpu in percents of core and ram in bytes of workload container.
+func Resources(cpu uint32, ram uint64) func(*clusterapi.Workload) {
+	return func(wl *clusterapi.Workload) {
+		wl.Entity = &clusterapi.Entity{Instance: &clusterapi.Instance{Container: &clusterapi.Container{
+			ComputingResources: &clusterapi.ComputingResources{CpuPowerPercentsCore: cpu, RamBytes: ram},
+		}}}
+	}
+}
+
+// Disk sets disk space in bytes of workload container, set it after Resources.
+func Disk(disk uint64) func(*clusterapi.Workload) {
+	return func(wl *clusterapi.Workload) {
+		if wl.Entity == nil {
+			Resources(0, 0)(wl)
+		}
+		wl.Entity.Instance.Container.ComputingResources.HddSpaceBytes = disk
+	}
+}
+
+// Host returns an UP host with etag 1, 1000 cpu and 1G ram running wls.
+func Host(id string, wls ...*clusterapi.Workload) *clusterapi.Host {
+	return &clusterapi.Host{
+		Metadata: &clusterapi.HostMetadata{
+			Id:                 id,
+			Etag:               1,
+			Health:             &clusterapi.HostHealth{State: clusterapi.HostHealthState_UP},
+			ComputingResources: &clusterapi.ComputingResources{CpuPowerPercentsCore: 1000, RamBytes: 1 << 30},
+		},
+		Workloads: wls,
+	}
+}
+
+// Health sets health state of h and returns it.
+func Health(h *clusterapi.Host, state clusterapi.HostHealthState) *clusterapi.Host {
+	h.Metadata.Health = &clusterapi.HostHealth{State: state}
+	return h
+}
+
+// Capacity sets cpu and ram of h and returns it.
+func Capacity(h *clusterapi.Host, cpu uint32, ram uint64) *clusterapi.Host {
+	h.Metadata.ComputingResources = &clusterapi.ComputingResources{CpuPowerPercentsCore: cpu, RamBytes: ram}
+	return h
+}
+
+// Rack places h in rack and returns it.
+func Rack(h *clusterapi.Host, rack string) *clusterapi.Host {
+	h.Metadata.Location = &clusterapi.Location{Rack: rack}
+	return h
+}
+
+// Hosts returns hosts without workloads.
+func Hosts(ids ...string) []*clusterapi.Host {
+	result := make([]*clusterapi.Host, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, Host(id))
+	}
+	return result
+}
+
+// State returns cluster state of hosts.
+func State(hosts ...*clusterapi.Host) *clusterapi.ClusterState {
+	return &clusterapi.ClusterState{Hosts: hosts}
+}
+
+// Running returns hosts running workloads of s at generation gen, host i
+// has etag i+1.
+func Running(s *spec.Spec, gen string, ids ...string) []*clusterapi.Host {
+	result := make([]*clusterapi.Host, 0, len(ids))
+	for i, id := range ids {
+		h := Host(id, s.Workload(id, gen))
+		h.Metadata.Etag = int64(i + 1)
+		result = append(result, h)
+	}
+	return result
+}
+
+// Spec returns a valid task of service svc with version and hosts.
+func Spec(version string, hosts ...string) *spec.Spec {
+	return &spec.Spec{
+		Owner:     Owner,
+		ProjectId: Project,
+		Service:   Service,
+		Version:   version,
+		Resources: spec.Resources{Cpu: 100, Ram: "100M"},
+		Hosts:     hosts,
+	}
+}
@@ -0,0 +1,98 @@
+// Package graphite sends metrics over the graphite plaintext protocol.
+package graphite
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// Sender keeps a connection to a carbon relay and reconnects when it breaks.
+type Sender struct {
+	Addr string
+	// lines written at once
+	BatchSize int
+	// attempts to deliver a batch before giving up
+	Retries     int
+	DialTimeout time.Duration
+
+	conn net.Conn
+}
+
+func New(addr string) *Sender {
+	return &Sender{
+		Addr:        addr,
+		BatchSize:   500,
+		Retries:     3,
+		DialTimeout: 5 * time.Second,
+	}
+}
+
+// Line formats one plaintext protocol line.
+func Line(path string, value interface{}, ts time.Time) string {
+	return fmt.Sprintf("%s %v %d", path, value, ts.Unix())
+}
+
+// Node makes s a single path node, dots of fqdn become underscores.
+func Node(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ' ', '/', ':':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// Send writes lines in batches, a batch failed to write is retried over a
+// new connection.
+func (s *Sender) Send(lines []string) error {
+	for start := 0; start < len(lines); start += s.BatchSize {
+		end := start + s.BatchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		batch := strings.Join(lines[start:end], "\n") + "\n"
+
+		var err error
+		for attempt := 0; attempt < s.Retries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+			if err = s.write(batch); err == nil {
+				break
+			}
+			log.Printf("graphite %s: %v, reconnecting", s.Addr, err)
+			s.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to send %d lines to %s: %v", len(lines)-start, s.Addr, err)
+		}
+	}
+	return nil
+}
+
+func (s *Sender) write(batch string) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout("tcp", s.Addr, s.DialTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.DialTimeout))
+	_, err := s.conn.Write([]byte(batch))
+	return err
+}
+
+func (s *Sender) Close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
@@ -0,0 +1,144 @@
+// Package history keeps applied group transitions in flat json files,
+// one directory per group, so previous generations can be listed, compared
+// and applied again.
+package history
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/localuser"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Record is one applied group transition.
+type Record struct {
+	GroupId     string                      `json:"groupId"`
+	Generation  string                      `json:"generation"`
+	Fingerprint string                      `json:"fingerprint"`
+	OperationId string                      `json:"operationId"`
+	Time        time.Time                   `json:"time"`
+	Author      string                      `json:"author"`
+	Endpoint    string                      `json:"endpoint"`
+	Transition  *clusterapi.GroupTransition `json:"transition"`
+}
+
+type Store struct {
+	dir string
+}
+
+// DefaultDir is ~/.capi_tools/history.
+func DefaultDir() string {
+	return localuser.Path("history")
+}
+
+func Open(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Author returns name of the local user applying changes.
+func Author() string {
+	return localuser.Name()
+}
+
+// NewRecord describes transition t applied at generation gen.
+func NewRecord(t *clusterapi.GroupTransition, gen string, endpoint string) *Record {
+	r := &Record{
+		GroupId:     t.GroupId,
+		Generation:  gen,
+		OperationId: t.GroupOperationId,
+		Time:        time.Now(),
+		Author:      Author(),
+		Endpoint:    endpoint,
+		Transition:  t,
+	}
+	for _, tr := range t.Transitions {
+		for _, wl := range tr.Workloads {
+			if wl.Id != nil && wl.Id.Configuration != nil {
+				r.Fingerprint = wl.Id.Configuration.GroupStateFingerprint
+			}
+		}
+	}
+	return r
+}
+
+func (s *Store) groupDir(group string) string {
+	return filepath.Join(s.dir, strings.Replace(group, string(filepath.Separator), "_", -1))
+}
+
+// Add stores record as <group>/<generation>-<unix time>.json.
+func (s *Store) Add(r *Record) error {
+	dir := s.groupDir(r.GroupId)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%d.json", r.Generation, r.Time.UnixNano())
+	return ioutil.WriteFile(filepath.Join(dir, name), data, 0644)
+}
+
+// List returns records of group sorted by time.
+func (s *Store) List(group string) ([]*Record, error) {
+	files, err := filepath.Glob(filepath.Join(s.groupDir(group), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*Record, 0, len(files))
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		r := new(Record)
+		if err := json.Unmarshal(data, r); err != nil {
+			return nil, fmt.Errorf("broken history record %s: %v", f, err)
+		}
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+// Get returns the latest record of generation gen of group.
+func (s *Store) Get(group, gen string) (*Record, error) {
+	records, err := s.List(group)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Generation == gen {
+			return records[i], nil
+		}
+	}
+	return nil, fmt.Errorf("generation %s of group %s not found in history", gen, group)
+}
+
+// Hosts returns number of hosts with workloads in the record.
+func (r *Record) Hosts() int {
+	n := 0
+	for _, tr := range r.Transition.Transitions {
+		if len(tr.Workloads) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Workloads returns workloads of the record by host.
+func (r *Record) Workloads() map[string][]*clusterapi.Workload {
+	result := make(map[string][]*clusterapi.Workload)
+	for _, tr := range r.Transition.Transitions {
+		if len(tr.Workloads) > 0 {
+			result[tr.HostId] = tr.Workloads
+		}
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package ipbroker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fake is an in-memory ip-broker serving the endpoint api, for tests and
+// local runs. Addresses are taken from Prefix, fqdns are built from Domain.
+type Fake struct {
+	Prefix string
+	Domain string
+	// token requests must carry, any if empty
+	Token string
+
+	mu        sync.Mutex
+	next      int
+	endpoints map[string]*Endpoint
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		Prefix:    "fd00:cafe::",
+		Domain:    "fake.ip-broker.local",
+		endpoints: make(map[string]*Endpoint),
+	}
+}
+
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.Token != "" && r.Header.Get("Authorization") != "OAuth "+f.Token {
+		http.Error(w, "bad token", http.StatusUnauthorized)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/network/endpoint/")
+	if id == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == "GET" && id == "":
+		list := make([]*Endpoint, 0, len(f.endpoints))
+		for _, e := range f.endpoints {
+			list = append(list, e)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+		json.NewEncoder(w).Encode(list)
+	case r.Method == "POST" && id == "":
+		req := &Endpoint{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.ProjectId == "" {
+			http.Error(w, "bad endpoint request", http.StatusBadRequest)
+			return
+		}
+		f.next++
+		b := make([]byte, 6)
+		rand.Read(b)
+		e := &Endpoint{
+			Id:        hex.EncodeToString(b),
+			ProjectId: req.ProjectId,
+			Address:   fmt.Sprintf("%s%x", f.Prefix, f.next),
+			Labels:    req.Labels,
+			Created:   time.Now(),
+		}
+		e.Fqdn = fmt.Sprintf("i-%s.%s", e.Id, f.Domain)
+		f.endpoints[e.Id] = e
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(e)
+	case r.Method == "GET":
+		e, ok := f.endpoints[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(e)
+	case r.Method == "DELETE":
+		if _, ok := f.endpoints[id]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(f.endpoints, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
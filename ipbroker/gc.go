@@ -0,0 +1,62 @@
+package ipbroker
+
+import (
+	"capi_tools/clusterapi"
+	"sort"
+	"time"
+)
+
+// Leak is an endpoint no workload in cluster state uses.
+type Leak struct {
+	*Endpoint
+	Age time.Duration
+	// too young to delete, workload may be being deployed
+	Grace bool
+}
+
+// ProjectGC counts endpoints of one project.
+type ProjectGC struct {
+	ProjectId string `json:"project_id"`
+	Total     int    `json:"total"`
+	Live      int    `json:"live"`
+	Leaked    int    `json:"leaked"`
+	InGrace   int    `json:"in_grace"`
+	Deleted   int    `json:"deleted"`
+	Failed    int    `json:"failed"`
+}
+
+// InUse returns addresses and fqdns workloads of cstate carry in their properties.
+func InUse(cstate *clusterapi.ClusterState) map[string]bool {
+	used := make(map[string]bool)
+	for _, h := range cstate.Hosts {
+		for _, wl := range h.Workloads {
+			for _, p := range []string{PropAddress, PropHostname} {
+				if v := wl.Properties[p]; v != "" {
+					used[v] = true
+				}
+			}
+		}
+	}
+	return used
+}
+
+// Leaks returns endpoints whose address and fqdn are not used, those
+// created less than grace ago or of unknown age are marked Grace.
+func Leaks(endpoints []*Endpoint, used map[string]bool, grace time.Duration, now time.Time) []*Leak {
+	result := make([]*Leak, 0)
+	for _, e := range endpoints {
+		if used[e.Address] || used[e.Fqdn] {
+			continue
+		}
+		l := &Leak{Endpoint: e, Age: now.Sub(e.Created)}
+		l.Grace = e.Created.IsZero() || l.Age < grace
+		result = append(result, l)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ProjectId != result[j].ProjectId {
+			return result[i].ProjectId < result[j].ProjectId
+		}
+		return result[i].Id < result[j].Id
+	})
+	return result
+}
@@ -0,0 +1,190 @@
+// Package ipbroker allocates network endpoints, an ipv6 address with fqdn,
+// for workloads in ip-broker.
+package ipbroker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DefaultURL = "http://ip-broker.qloud.yandex.net"
+
+// workload properties endpoint address and fqdn are passed in
+const (
+	PropAddress  = "IPV6_ADDRESS"
+	PropHostname = "HOSTNAME"
+)
+
+// labels endpoints allocated by capi_tools are tagged with
+const (
+	LabelGroup = "capi_group"
+	LabelHost  = "capi_host"
+)
+
+type Endpoint struct {
+	Id        string            `json:"id"`
+	ProjectId string            `json:"project_id"`
+	Address   string            `json:"ip"`
+	Fqdn      string            `json:"fqdn"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Created   time.Time         `json:"created"`
+}
+
+type Client struct {
+	url  string
+	http *http.Client
+}
+
+// New returns client of ip-broker at url, see WithTransport for authorization.
+func New(url string) *Client {
+	return &Client{
+		url:  strings.TrimRight(url, "/"),
+		http: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// WithTransport makes requests through rt, e.g. one adding credentials.
+func (c *Client) WithTransport(rt http.RoundTripper) *Client {
+	c.http.Transport = rt
+	return c
+}
+
+func (c *Client) URL() string {
+	return c.url
+}
+
+func (c *Client) do(method, path string, req, resp interface{}) error {
+	var body []byte
+	if req != nil {
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return err
+		}
+	}
+	r, err := http.NewRequest(method, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	res, err := c.http.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s: %s", method, path, res.Status, strings.TrimSpace(string(data)))
+	}
+	if resp == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, resp)
+}
+
+// List returns endpoints of project, all endpoints if project is empty.
+func (c *Client) List(project string) ([]*Endpoint, error) {
+	all := make([]*Endpoint, 0)
+	if err := c.do("GET", "/network/endpoint/", nil, &all); err != nil {
+		return nil, err
+	}
+	if project == "" {
+		return all, nil
+	}
+	result := make([]*Endpoint, 0)
+	for _, e := range all {
+		if e.ProjectId == project {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+// Create allocates a new endpoint in project.
+func (c *Client) Create(project string, labels map[string]string) (*Endpoint, error) {
+	e := &Endpoint{}
+	req := &Endpoint{ProjectId: project, Labels: labels}
+	if err := c.do("POST", "/network/endpoint/", req, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (c *Client) Delete(id string) error {
+	return c.do("DELETE", "/network/endpoint/"+id, nil, nil)
+}
+
+// Find returns endpoint of project allocated for group on host or nil.
+func Find(endpoints []*Endpoint, group, host string) *Endpoint {
+	for _, e := range endpoints {
+		if e.Labels[LabelGroup] == group && e.Labels[LabelHost] == host {
+			return e
+		}
+	}
+	return nil
+}
+
+// Lookup returns endpoints already allocated for group on hosts, hosts
+// without one are left out. Nothing is created.
+func (c *Client) Lookup(project, group string, hosts []string) (map[string]*Endpoint, error) {
+	existing, err := c.List(project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list endpoints of project %s: %v", project, err)
+	}
+	result := make(map[string]*Endpoint)
+	for _, h := range hosts {
+		if e := Find(existing, group, h); e != nil {
+			result[h] = e
+		}
+	}
+	return result, nil
+}
+
+// Ensure returns endpoints for group on every host, reusing the ones
+// allocated before so addresses survive redeploys.
+func (c *Client) Ensure(project, group string, hosts []string) (map[string]*Endpoint, error) {
+	existing, err := c.List(project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list endpoints of project %s: %v", project, err)
+	}
+	result := make(map[string]*Endpoint)
+	for _, h := range hosts {
+		e := Find(existing, group, h)
+		if e == nil {
+			if e, err = c.Create(project, map[string]string{LabelGroup: group, LabelHost: h}); err != nil {
+				return nil, fmt.Errorf("failed to create endpoint for group %s on %s: %v", group, h, err)
+			}
+		}
+		result[h] = e
+	}
+	return result, nil
+}
+
+// Release deletes endpoints of group on hosts, hosts without endpoint are skipped.
+func (c *Client) Release(project, group string, hosts []string) error {
+	existing, err := c.List(project)
+	if err != nil {
+		return fmt.Errorf("failed to list endpoints of project %s: %v", project, err)
+	}
+	for _, h := range hosts {
+		if e := Find(existing, group, h); e != nil {
+			if err := c.Delete(e.Id); err != nil {
+				return fmt.Errorf("failed to delete endpoint %s of group %s on %s: %v", e.Id, group, h, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Properties returns workload properties carrying endpoint address.
+func (e *Endpoint) Properties() map[string]string {
+	return map[string]string{PropAddress: e.Address, PropHostname: e.Fqdn}
+}
@@ -0,0 +1,110 @@
+package ipbroker
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/credentials"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLeaks(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	endpoints := []*Endpoint{
+		{Id: "1", ProjectId: "P", Address: "fd00::1", Fqdn: "one", Created: now.Add(-time.Hour)},
+		{Id: "2", ProjectId: "P", Address: "fd00::2", Fqdn: "two", Created: now.Add(-time.Hour)},
+		{Id: "3", ProjectId: "P", Address: "fd00::3", Fqdn: "three", Created: now.Add(-time.Minute)},
+		{Id: "4", ProjectId: "A", Address: "fd00::4", Fqdn: "four"},
+		{Id: "5", ProjectId: "P", Address: "fd00::5", Fqdn: "five", Created: now.Add(-time.Hour)},
+	}
+	cstate := &clusterapi.ClusterState{Hosts: []*clusterapi.Host{{Workloads: []*clusterapi.Workload{
+		{Properties: map[string]string{PropAddress: "fd00::1"}},
+		{Properties: map[string]string{PropHostname: "five"}},
+	}}}}
+	tests := []struct {
+		name  string
+		grace time.Duration
+		want  []string
+	}{
+		{"no grace", 0, []string{"4 grace", "2 leak", "3 leak"}},
+		{"grace", 10 * time.Minute, []string{"4 grace", "2 leak", "3 grace"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, l := range Leaks(endpoints, InUse(cstate), tt.grace, now) {
+				mark := "leak"
+				if l.Grace {
+					mark = "grace"
+				}
+				got = append(got, l.Id+" "+mark)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Leaks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeRoundTrip(t *testing.T) {
+	fake := NewFake()
+	fake.Token = "secret"
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	if _, err := New(srv.URL).List(""); err == nil {
+		t.Fatal("request without token accepted")
+	}
+
+	os.Setenv("IPBROKER_TEST_TOKEN", "secret")
+	defer os.Unsetenv("IPBROKER_TEST_TOKEN")
+	c := New(srv.URL).WithTransport(&credentials.Transport{
+		Profile: &credentials.Profile{Name: credentials.IpBroker, Env: "IPBROKER_TEST_TOKEN"},
+	})
+
+	found, err := c.Lookup("P", "g", []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Fatalf("Lookup found %v before anything was allocated", found)
+	}
+
+	first, err := c.Ensure("P", "g", []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || first["a"].Address == first["b"].Address {
+		t.Fatalf("Ensure returned %v", first)
+	}
+	if p := first["a"].Properties(); p[PropAddress] != first["a"].Address || p[PropHostname] != first["a"].Fqdn {
+		t.Errorf("properties %v of %+v", p, first["a"])
+	}
+
+	again, err := c.Ensure("P", "g", []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again["a"].Id != first["a"].Id || again["b"].Id != first["b"].Id {
+		t.Errorf("Ensure reallocated endpoints: %v, then %v", first, again)
+	}
+	if found, err = c.Lookup("P", "g", []string{"a", "d"}); err != nil || len(found) != 1 || found["a"].Id != first["a"].Id {
+		t.Errorf("Lookup = %v, %v", found, err)
+	}
+	if others, err := c.List("Q"); err != nil || len(others) != 0 {
+		t.Errorf("List of another project = %v, %v", others, err)
+	}
+
+	if err := c.Release("P", "g", []string{"a", "d"}); err != nil {
+		t.Fatal(err)
+	}
+	left, err := c.List("P")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 2 || Find(left, "g", "a") != nil || Find(left, "g", "b") == nil {
+		t.Errorf("endpoints left after release: %v", left)
+	}
+}
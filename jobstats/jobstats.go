@@ -0,0 +1,135 @@
+// Package jobstats sums resources allocated by every scheduler per class of
+// hosts, the go version of count_jobs_in_cluster_state_one_min.py.
+package jobstats
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/graphite"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Class is a named set of hosts with fqdn matching Match.
+type Class struct {
+	Name  string
+	Match *regexp.Regexp
+}
+
+const Unknown = "unknown"
+
+// DefaultClasses are the host classes of the python script.
+var DefaultClasses = []Class{
+	{"rtc", regexp.MustCompile(`^.*\.vm\.search\.yandex\.net$`)},
+	{"r2", regexp.MustCompile(`^s1.*\.qloud\.yandex\.net$`)},
+	{"tsnet", regexp.MustCompile(`^tsnet.*search\.yandex\.net$`)},
+	{"qloud", regexp.MustCompile(`^pool.*\.qloud\.yandex\.net$`)},
+	{"zerling", regexp.MustCompile(`^zergling.*$`)},
+}
+
+// ParseClass parses class given as "name=regexp".
+func ParseClass(s string) (Class, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return Class{}, fmt.Errorf("bad host class %q, want name=regexp", s)
+	}
+	re, err := regexp.Compile(kv[1])
+	if err != nil {
+		return Class{}, fmt.Errorf("bad host class %s: %v", kv[0], err)
+	}
+	return Class{kv[0], re}, nil
+}
+
+// Classify returns name of the first class host matches or unknown.
+func Classify(classes []Class, host string) string {
+	for _, c := range classes {
+		if c.Match.MatchString(host) {
+			return c.Name
+		}
+	}
+	return Unknown
+}
+
+// Alloc is what one scheduler allocated on a class of hosts.
+type Alloc struct {
+	Jobs uint64
+	// cpu in percents of core
+	Cpu  uint64
+	Ram  uint64
+	Disk uint64
+}
+
+type Result struct {
+	// class -> scheduler -> allocation
+	Alloc map[string]map[string]*Alloc
+	// class -> health state -> number of hosts
+	Hosts map[string]map[string]int
+}
+
+// Count sums workload resources of cstate by class and scheduler and counts
+// hosts by class and health state.
+func Count(cstate *clusterapi.ClusterState, classes []Class) *Result {
+	r := &Result{
+		Alloc: make(map[string]map[string]*Alloc),
+		Hosts: make(map[string]map[string]int),
+	}
+	for _, h := range cstate.Hosts {
+		if h.Metadata == nil {
+			continue
+		}
+		class := Classify(classes, h.Metadata.Id)
+		if r.Alloc[class] == nil {
+			r.Alloc[class] = make(map[string]*Alloc)
+			r.Hosts[class] = make(map[string]int)
+		}
+		state := Unknown
+		if h.Metadata.Health != nil {
+			state = h.Metadata.Health.State.String()
+		}
+		r.Hosts[class][state]++
+
+		for _, wl := range h.Workloads {
+			scheduler := wl.SchedulerId
+			if scheduler == "" {
+				scheduler = Unknown
+			}
+			a := r.Alloc[class][scheduler]
+			if a == nil {
+				a = &Alloc{}
+				r.Alloc[class][scheduler] = a
+			}
+			res := client.WorkloadResources(wl)
+			a.Jobs++
+			a.Cpu += uint64(res.CpuPowerPercentsCore)
+			a.Ram += res.RamBytes
+			a.Disk += res.HddSpaceBytes
+		}
+	}
+	return r
+}
+
+// Lines formats result as graphite lines named like the python script:
+// <prefix>.<class>.<scheduler>.number_of_jobs and <prefix>.<class>.hosts.<state>.
+func (r *Result) Lines(prefix string, ts time.Time) []string {
+	lines := make([]string, 0)
+	for class, schedulers := range r.Alloc {
+		for scheduler, a := range schedulers {
+			path := prefix + "." + graphite.Node(class) + "." + graphite.Node(scheduler) + "."
+			lines = append(lines,
+				graphite.Line(path+"number_of_jobs", a.Jobs, ts),
+				graphite.Line(path+"cpu_alloc", a.Cpu, ts),
+				graphite.Line(path+"mem_alloc", a.Ram, ts),
+				graphite.Line(path+"disk_alloc", a.Disk, ts))
+		}
+	}
+	for class, states := range r.Hosts {
+		for state, n := range states {
+			lines = append(lines, graphite.Line(prefix+"."+graphite.Node(class)+".hosts."+state, n, ts))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
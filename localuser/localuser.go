@@ -0,0 +1,32 @@
+// Package localuser locates the user running the tools and their files in
+// ~/.capi_tools.
+package localuser
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+// Home returns home directory of the current user, $HOME if the user can
+// not be looked up.
+func Home() string {
+	if u, err := user.Current(); err == nil && u.HomeDir != "" {
+		return u.HomeDir
+	}
+	return os.Getenv("HOME")
+}
+
+// Name returns login of the current user, $USER if the user can not be
+// looked up.
+func Name() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// Path joins elem to ~/.capi_tools.
+func Path(elem ...string) string {
+	return filepath.Join(append([]string{Home(), ".capi_tools"}, elem...)...)
+}
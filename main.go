@@ -6,18 +6,22 @@ import (
 //	"capi_tools/capi/sched"
 	"capi_tools/capi/state"
 	"capi_tools/clusterapi"
+	"capi_tools/credentials"
+	"capi_tools/ipbroker"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"github.com/kr/pretty"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 )
 var capi_url string = "http://sit-dev-01-sas.haze.yandex.net:8081/proto/v0/state/full"
 
 //var capi_url string "http://iss00-prestable.search.yandex.net:8082/proto/v0/state/full"
 
 func main() {
+	log.SetOutput(credentials.RedactWriter(os.Stderr))
 	owner := &clusterapi.Owner{
 		OwnerId:   "dkulikovsky_task_owner_id",
 		Priority:  100,
@@ -44,7 +48,14 @@ func run_sample_workload(cstate []*state.Host, owner *clusterapi.Owner) {
 	// update workload params
 	// get ip and hostname from ip-broker
 	workload = capi.SetWlHost(host, workload)
-	workload = capi.SetWlNet("2a02:6b8:c03:22d:0:4097:5324:2bf5", "i-53242bf59a04.qloud-c.yandex.net", workload)
+	ib := ipbroker.New(ipbroker.DefaultURL).WithTransport(&credentials.Transport{
+		Profile: credentials.Default().For(credentials.IpBroker, ipbroker.DefaultURL),
+	})
+	endpoints, err := ib.Ensure(owner.ProjectId, "sample_workload", []string{host})
+	if err != nil {
+		log.Fatalf("Failed to get address from ip-broker: %v", err)
+	}
+	workload = capi.SetWlNet(endpoints[host].Address, endpoints[host].Fqdn, workload)
 
 	group := capi.GroupTransition(host, host_etag, workload, owner)
 	apply := capi.ApplyGroup(group)
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"capi_tools/graphite"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// WritePrometheus writes the latest point of every series in prometheus text format.
+func WritePrometheus(w io.Writer, prefix string, series []*Series) error {
+	typed := make(map[string]bool)
+	for _, s := range series {
+		if len(s.Points) == 0 {
+			continue
+		}
+		name := promName(prefix + "_" + s.Name)
+		if !typed[name] {
+			typed[name] = true
+			if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", name, s.Type); err != nil {
+				return err
+			}
+		}
+		// no timestamp, prometheus stamps the scrape itself and drops
+		// samples it considers too old
+		_, err := fmt.Fprintf(w, "%s{%s} %g\n", name, promLabels(s.Labels), s.Last().Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func promLabels(l Labels) string {
+	labels := fmt.Sprintf("host=%q,group=%q,service=%q,container=%q", l.Host, l.Group, l.Service, l.Container)
+	if l.Key != "" {
+		labels += fmt.Sprintf(",key=%q", l.Key)
+	}
+	return labels
+}
+
+// Handler serves the store in prometheus text format.
+func Handler(s *Store, prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WritePrometheus(w, prefix, s.Series())
+	})
+}
+
+// promName replaces characters prometheus does not allow in metric names.
+func promName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// GraphiteLines formats samples as graphite plaintext protocol lines
+// "<prefix>.<group>.<host>.<service>.<container>.<name>[.<key>] <value> <unix time>".
+func GraphiteLines(prefix string, samples []*Sample) []string {
+	lines := make([]string, 0, len(samples))
+	for _, s := range samples {
+		path := []string{prefix, s.Labels.Group, s.Labels.Host, s.Labels.Service, s.Labels.Container, s.Name}
+		if s.Labels.Key != "" {
+			path = append(path, s.Labels.Key)
+		}
+		for i, p := range path[1:] {
+			path[i+1] = graphite.Node(p)
+		}
+		lines = append(lines, fmt.Sprintf("%s %g %d", strings.Join(path, "."), s.Value, s.Time.Unix()))
+	}
+	return lines
+}
+
+// WriteGraphite writes samples to w in graphite plaintext protocol.
+func WriteGraphite(w io.Writer, prefix string, samples []*Sample) error {
+	for _, line := range GraphiteLines(prefix, samples) {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+// Package metrics turns counters agents report for workload containers into
+// time series and writes them in prometheus and graphite formats.
+package metrics
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/feedback"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	Counter Type = "counter"
+	Gauge   Type = "gauge"
+)
+
+// CounterNames are porto counters growing monotonically, everything else is a gauge.
+var CounterNames = map[string]bool{
+	"cpu_usage":        true,
+	"cpu_usage_system": true,
+	"cpu_wait":         true,
+	"cpu_throttled":    true,
+	"io_read":          true,
+	"io_write":         true,
+	"io_ops":           true,
+	"io_time":          true,
+	"net_bytes":        true,
+	"net_packets":      true,
+	"net_drops":        true,
+	"net_overlimits":   true,
+	"net_rx_bytes":     true,
+	"net_rx_packets":   true,
+	"net_rx_drops":     true,
+	"net_tx_bytes":     true,
+	"net_tx_packets":   true,
+	"net_tx_drops":     true,
+	"minor_faults":     true,
+	"major_faults":     true,
+	"oom_kills":        true,
+}
+
+// Labels identify the container a sample belongs to and the key of per key
+// counters like net_bytes of one interface.
+type Labels struct {
+	Host      string `json:"host"`
+	Group     string `json:"group"`
+	Service   string `json:"service"`
+	Container string `json:"container"`
+	Key       string `json:"key,omitempty"`
+}
+
+type Sample struct {
+	Name   string    `json:"name"`
+	Type   Type      `json:"type"`
+	Labels Labels    `json:"labels"`
+	Value  float64   `json:"value"`
+	Time   time.Time `json:"time"`
+}
+
+// Extract returns numeric counters of workload, values which are not numbers
+// are skipped, per key values like "eth0: 10; eth1: 20" become one sample per
+// key with the key in labels.
+func Extract(wl *clusterapi.Workload) []*Sample {
+	result := make([]*Sample, 0)
+	var reported time.Time
+	if wl.Feedback != nil && wl.Feedback.HostTimestamp != 0 {
+		reported = feedback.Time(wl.Feedback.HostTimestamp)
+	}
+	for _, m := range feedback.All(wl) {
+		c := m.CountersFeedback
+		if c == nil {
+			continue
+		}
+		labels := Labels{
+			Host:      client.WorkloadHost(wl),
+			Group:     client.WorkloadGroup(wl),
+			Service:   client.WorkloadService(wl),
+			Container: c.Container,
+		}
+		at := reported
+		if c.Timestamp != 0 {
+			at = feedback.Time(c.Timestamp)
+		}
+		for name, raw := range c.Counters {
+			for k, v := range parse(raw) {
+				typ := Gauge
+				if CounterNames[name] {
+					typ = Counter
+				}
+				l := labels
+				l.Key = k
+				result = append(result, &Sample{Name: name, Type: typ, Labels: l, Value: v, Time: at})
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Labels.Key < result[j].Labels.Key
+	})
+	return result
+}
+
+// parse reads a counter value, a plain number is returned with "" key.
+func parse(raw string) map[string]float64 {
+	result := make(map[string]float64)
+	raw = strings.TrimSpace(raw)
+	if v, err := strconv.ParseFloat(raw, 64); err == nil {
+		result[""] = v
+		return result
+	}
+	for _, part := range strings.Split(raw, ";") {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+			result[strings.TrimSpace(kv[0])] = v
+		}
+	}
+	return result
+}
+
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Series is the history of one counter of one container.
+type Series struct {
+	Name   string  `json:"name"`
+	Type   Type    `json:"type"`
+	Labels Labels  `json:"labels"`
+	Points []Point `json:"points"`
+}
+
+// Last returns the newest point.
+func (s *Series) Last() Point {
+	return s.Points[len(s.Points)-1]
+}
+
+// Store keeps the latest points of every series, safe for concurrent use.
+type Store struct {
+	// points kept per series
+	Retention int
+
+	mu     sync.Mutex
+	series map[string]*Series
+}
+
+func NewStore() *Store {
+	return &Store{Retention: 60, series: make(map[string]*Series)}
+}
+
+func key(name string, l Labels) string {
+	return strings.Join([]string{name, l.Host, l.Group, l.Service, l.Container, l.Key}, "|")
+}
+
+// Add appends samples to their series, a sample not newer than the last
+// point of its series is ignored.
+func (s *Store) Add(samples []*Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sm := range samples {
+		k := key(sm.Name, sm.Labels)
+		ser := s.series[k]
+		if ser == nil {
+			ser = &Series{Name: sm.Name, Type: sm.Type, Labels: sm.Labels}
+			s.series[k] = ser
+		}
+		if len(ser.Points) > 0 && !sm.Time.After(ser.Last().Time) {
+			continue
+		}
+		ser.Points = append(ser.Points, Point{sm.Time, sm.Value})
+		if len(ser.Points) > s.Retention {
+			ser.Points = ser.Points[len(ser.Points)-s.Retention:]
+		}
+	}
+}
+
+// Retain drops series of containers not among labels, so removed workloads
+// stop being exported.
+func (s *Store) Retain(labels map[Labels]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, ser := range s.series {
+		if !labels[ser.Labels] {
+			delete(s.series, k)
+		}
+	}
+}
+
+// Series returns copies of all series sorted by name and labels.
+func (s *Store) Series() []*Series {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.series))
+	for k := range s.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make([]*Series, 0, len(keys))
+	for _, k := range keys {
+		ser := *s.series[k]
+		ser.Points = append([]Point(nil), ser.Points...)
+		result = append(result, &ser)
+	}
+	return result
+}
+
+// Changes passes on samples with a value other than the one passed last
+// time for their series, or with the same value once Period passed since,
+// so unchanged counters are not resent on every state delta.
+type Changes struct {
+	Period time.Duration
+	sent   map[string]*Sample
+}
+
+func NewChanges(period time.Duration) *Changes {
+	return &Changes{Period: period, sent: make(map[string]*Sample)}
+}
+
+// Filter returns samples to send and remembers them as sent. A sample not
+// newer than the one sent last is dropped.
+func (c *Changes) Filter(samples []*Sample) []*Sample {
+	result := make([]*Sample, 0)
+	for _, sm := range samples {
+		k := key(sm.Name, sm.Labels)
+		if last, ok := c.sent[k]; ok {
+			if !sm.Time.After(last.Time) || sm.Value == last.Value && sm.Time.Sub(last.Time) < c.Period {
+				continue
+			}
+		}
+		c.sent[k] = sm
+		result = append(result, sm)
+	}
+	return result
+}
+
+// Retain forgets series of containers not among labels.
+func (c *Changes) Retain(labels map[Labels]bool) {
+	for k, sm := range c.sent {
+		if !labels[sm.Labels] {
+			delete(c.sent, k)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"bytes"
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want map[string]float64
+	}{
+		{"42", map[string]float64{"": 42}},
+		{" 1.5 ", map[string]float64{"": 1.5}},
+		{"eth0: 10; eth1: 20", map[string]float64{"eth0": 10, "eth1": 20}},
+		{"eth0: 10; broken; lo: x", map[string]float64{"eth0": 10}},
+		{"not a number", map[string]float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if got := parse(tt.raw); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parse(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func counters(ts uint64, values map[string]string) func(*clusterapi.Workload) {
+	return fixture.Metric(&clusterapi.FeedbackMessage{CountersFeedback: &clusterapi.CountersFeedback{
+		Container: "c", Timestamp: ts, Counters: values,
+	}})
+}
+
+func TestExtract(t *testing.T) {
+	wl := fixture.Workload("g", "a", counters(1000, map[string]string{
+		"cpu_usage":   "5",
+		"memory_used": "100",
+		"net_bytes":   "eth0: 10; eth1: 20",
+		"state":       "running",
+	}))
+	got := make([]string, 0)
+	for _, s := range Extract(wl) {
+		got = append(got, fmt.Sprintf("%s %s key=%s %g %d", s.Name, s.Type, s.Labels.Key, s.Value, s.Time.Unix()))
+	}
+	want := []string{
+		"cpu_usage counter key= 5 1000",
+		"memory_used gauge key= 100 1000",
+		"net_bytes counter key=eth0 10 1000",
+		"net_bytes counter key=eth1 20 1000",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Extract = %v, want %v", got, want)
+	}
+}
+
+func sample(name, key string, value float64, sec int64) *Sample {
+	return &Sample{
+		Name:   name,
+		Type:   Gauge,
+		Labels: Labels{Host: "a", Group: "g", Service: "svc", Container: "c", Key: key},
+		Value:  value,
+		Time:   time.Unix(sec, 0),
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := NewStore()
+	s.Retention = 2
+	s.Add([]*Sample{sample("m", "", 1, 10), sample("m", "", 2, 20)})
+	s.Add([]*Sample{sample("m", "", 3, 20), sample("m", "", 4, 30), sample("n", "x", 5, 30)})
+
+	got := make(map[string][]float64)
+	for _, ser := range s.Series() {
+		for _, p := range ser.Points {
+			got[ser.Name+ser.Labels.Key] = append(got[ser.Name+ser.Labels.Key], p.Value)
+		}
+	}
+	want := map[string][]float64{"m": {2, 4}, "nx": {5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("series %v, want %v", got, want)
+	}
+
+	s.Retain(map[Labels]bool{sample("n", "x", 0, 0).Labels: true})
+	if series := s.Series(); len(series) != 1 || series[0].Name != "n" {
+		t.Errorf("series after Retain: %v", series)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	c := NewChanges(time.Minute)
+	steps := []struct {
+		name    string
+		samples []*Sample
+		want    []string
+	}{
+		{"first seen", []*Sample{sample("m", "", 1, 0), sample("m", "eth0", 1, 0)}, []string{"m 1", "m.eth0 1"}},
+		{"unchanged", []*Sample{sample("m", "", 1, 10), sample("m", "eth0", 1, 10)}, []string{}},
+		{"changed", []*Sample{sample("m", "", 2, 20), sample("m", "eth0", 1, 20)}, []string{"m 2"}},
+		{"resent after period", []*Sample{sample("m", "", 2, 30), sample("m", "eth0", 1, 60)}, []string{"m.eth0 1"}},
+		{"not newer", []*Sample{sample("m", "", 5, 20)}, []string{}},
+	}
+	for _, st := range steps {
+		got := make([]string, 0)
+		for _, s := range c.Filter(st.samples) {
+			name := s.Name
+			if s.Labels.Key != "" {
+				name += "." + s.Labels.Key
+			}
+			got = append(got, fmt.Sprintf("%s %g", name, s.Value))
+		}
+		if !reflect.DeepEqual(got, st.want) {
+			t.Errorf("%s: Filter = %v, want %v", st.name, got, st.want)
+		}
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	s := NewStore()
+	s.Add([]*Sample{sample("net.bytes", "eth0", 10, 10), sample("net.bytes", "eth1", 20, 10), sample("ram", "", 3, 10)})
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, "capi", s.Series()); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE capi_net_bytes gauge
+capi_net_bytes{host="a",group="g",service="svc",container="c",key="eth0"} 10
+capi_net_bytes{host="a",group="g",service="svc",container="c",key="eth1"} 20
+# TYPE capi_ram gauge
+capi_ram{host="a",group="g",service="svc",container="c"} 3
+`
+	if buf.String() != want {
+		t.Errorf("WritePrometheus =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestGraphiteLines(t *testing.T) {
+	got := GraphiteLines("capi", []*Sample{sample("net_bytes", "eth0", 10, 100), sample("ram", "", 3, 100)})
+	want := []string{
+		"capi.g.a.svc.c.net_bytes.eth0 10 100",
+		"capi.g.a.svc.c.ram 3 100",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GraphiteLines = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,199 @@
+// Package placement chooses hosts for replicas of a group from free
+// resources of the cluster. It works on a local model of the cluster and
+// never talks to capi.
+package placement
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"fmt"
+	"sort"
+)
+
+// anti-affinity levels
+const (
+	None = "none"
+	// at most one replica of a group per host, never violated
+	Host = "host"
+	// replicas of a group in different racks when possible
+	Rack = "rack"
+)
+
+// Resources is what placement accounts for, cpu in percents of core.
+type Resources struct {
+	Cpu  uint64 `json:"cpu"`
+	Ram  uint64 `json:"ram"`
+	Disk uint64 `json:"disk"`
+}
+
+func (r Resources) Fits(free Resources) bool {
+	return r.Cpu <= free.Cpu && r.Ram <= free.Ram && r.Disk <= free.Disk
+}
+
+func (r Resources) Add(o Resources) Resources {
+	return Resources{r.Cpu + o.Cpu, r.Ram + o.Ram, r.Disk + o.Disk}
+}
+
+func (r Resources) Sub(o Resources) Resources {
+	sub := func(a, b uint64) uint64 {
+		if b > a {
+			return 0
+		}
+		return a - b
+	}
+	return Resources{sub(r.Cpu, o.Cpu), sub(r.Ram, o.Ram), sub(r.Disk, o.Disk)}
+}
+
+// FromComputing takes resources placement accounts for from c.
+func FromComputing(c *clusterapi.ComputingResources) Resources {
+	if c == nil {
+		return Resources{}
+	}
+	return Resources{uint64(c.CpuPowerPercentsCore), c.RamBytes, c.HddSpaceBytes}
+}
+
+type Node struct {
+	Id      string
+	Rack    string
+	Healthy bool
+	Total   Resources
+	Used    Resources
+	// replicas per group
+	Groups map[string]int
+}
+
+func (n *Node) Free() Resources {
+	return n.Total.Sub(n.Used)
+}
+
+// Fits reports whether r fits free resources of n, disk is not checked on
+// hosts not reporting it.
+func (n *Node) Fits(r Resources) bool {
+	if n.Total.Disk == 0 {
+		r.Disk = 0
+	}
+	return r.Fits(n.Free())
+}
+
+// Cluster is a local model of hosts and what is allocated on them.
+type Cluster struct {
+	Nodes []*Node
+	byId  map[string]*Node
+}
+
+// NewCluster models cstate, only UP hosts accept new replicas.
+func NewCluster(cstate *clusterapi.ClusterState) *Cluster {
+	c := &Cluster{byId: make(map[string]*Node)}
+	for _, h := range cstate.Hosts {
+		md := h.Metadata
+		if md == nil {
+			continue
+		}
+		n := &Node{
+			Id:      md.Id,
+			Healthy: md.Health != nil && md.Health.State == clusterapi.HostHealthState_UP,
+			Total:   FromComputing(md.ComputingResources),
+			Groups:  make(map[string]int),
+		}
+		if md.Location != nil {
+			n.Rack = md.Location.Building + "/" + md.Location.Line + "/" + md.Location.Rack
+		}
+		for _, wl := range h.Workloads {
+			n.Used = n.Used.Add(FromComputing(client.WorkloadResources(wl)))
+			n.Groups[client.WorkloadGroup(wl)]++
+		}
+		c.Nodes = append(c.Nodes, n)
+		c.byId[n.Id] = n
+	}
+	sort.Slice(c.Nodes, func(i, j int) bool { return c.Nodes[i].Id < c.Nodes[j].Id })
+	return c
+}
+
+// Clone returns an independent copy so strategies can be compared on the same start.
+func (c *Cluster) Clone() *Cluster {
+	cc := &Cluster{byId: make(map[string]*Node)}
+	for _, n := range c.Nodes {
+		nn := *n
+		nn.Groups = make(map[string]int)
+		for g, k := range n.Groups {
+			nn.Groups[g] = k
+		}
+		cc.Nodes = append(cc.Nodes, &nn)
+		cc.byId[nn.Id] = &nn
+	}
+	return cc
+}
+
+func (c *Cluster) Node(id string) *Node {
+	return c.byId[id]
+}
+
+// Request asks to place Replicas of Group, each needing Resources.
+type Request struct {
+	Group        string    `json:"group"`
+	Replicas     int       `json:"replicas"`
+	Resources    Resources `json:"resources"`
+	AntiAffinity string    `json:"anti_affinity,omitempty"`
+}
+
+// Strategy picks the host for the next replica among candidates with enough
+// free resources.
+type Strategy interface {
+	Name() string
+	Pick(r *Request, candidates []*Node) *Node
+}
+
+// Result is where replicas of a request went.
+type Result struct {
+	Request *Request
+	Hosts   []string
+	// replicas sharing a rack though rack anti-affinity was asked
+	Violations int
+}
+
+// Place puts all replicas of r on c or none of them.
+func Place(c *Cluster, r *Request, s Strategy) (*Result, error) {
+	res := &Result{Request: r}
+	racks := make(map[string]bool)
+	placed := make([]*Node, 0, r.Replicas)
+	undo := func() {
+		for _, n := range placed {
+			n.Used = n.Used.Sub(r.Resources)
+			n.Groups[r.Group]--
+		}
+	}
+
+	for i := 0; i < r.Replicas; i++ {
+		candidates := make([]*Node, 0)
+		spread := make([]*Node, 0)
+		for _, n := range c.Nodes {
+			if !n.Healthy || !n.Fits(r.Resources) {
+				continue
+			}
+			if (r.AntiAffinity == Host || r.AntiAffinity == Rack) && n.Groups[r.Group] > 0 {
+				continue
+			}
+			candidates = append(candidates, n)
+			if !racks[n.Rack] {
+				spread = append(spread, n)
+			}
+		}
+		if r.AntiAffinity == Rack && len(spread) > 0 {
+			candidates = spread
+		}
+		if len(candidates) == 0 {
+			undo()
+			return nil, fmt.Errorf("no host fits replica %d of %d of group %s", i+1, r.Replicas, r.Group)
+		}
+		n := s.Pick(r, candidates)
+		if r.AntiAffinity == Rack && racks[n.Rack] {
+			res.Violations++
+		}
+		racks[n.Rack] = true
+		n.Used = n.Used.Add(r.Resources)
+		n.Groups[r.Group]++
+		placed = append(placed, n)
+		res.Hosts = append(res.Hosts, n.Id)
+	}
+	return res, nil
+}
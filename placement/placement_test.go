@@ -0,0 +1,125 @@
+package placement
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"reflect"
+	"testing"
+)
+
+// host is a host with 1000 cpu and 1000 ram in rack running a workload of
+// each of groups and a workload of other group using used cpu and ram.
+func host(id, rack string, used uint64, groups ...string) *clusterapi.Host {
+	wls := []*clusterapi.Workload{fixture.Workload("other", id, fixture.Resources(uint32(used), used))}
+	for _, g := range groups {
+		wls = append(wls, fixture.Workload(g, id))
+	}
+	return fixture.Rack(fixture.Capacity(fixture.Host(id, wls...), 1000, 1000), rack)
+}
+
+func cluster(hosts ...*clusterapi.Host) *Cluster {
+	return NewCluster(fixture.State(hosts...))
+}
+
+func TestPlace(t *testing.T) {
+	sick := fixture.Health(host("c", "r2", 0), clusterapi.HostHealthState_DOWN)
+	tests := []struct {
+		name       string
+		cluster    *Cluster
+		request    Request
+		strategy   Strategy
+		hosts      []string
+		violations int
+		err        bool
+	}{
+		{
+			name:     "first fit",
+			cluster:  cluster(host("a", "r1", 900), host("b", "r1", 0), host("c", "r2", 0)),
+			request:  Request{Group: "g", Replicas: 2, Resources: Resources{Cpu: 200, Ram: 200}},
+			strategy: FirstFit{},
+			hosts:    []string{"b", "b"},
+		},
+		{
+			name:     "best fit packs",
+			cluster:  cluster(host("a", "r1", 500), host("b", "r1", 0)),
+			request:  Request{Group: "g", Replicas: 1, Resources: Resources{Cpu: 200, Ram: 200}},
+			strategy: BestFit{},
+			hosts:    []string{"a"},
+		},
+		{
+			name:     "worst fit spreads",
+			cluster:  cluster(host("a", "r1", 500), host("b", "r1", 0)),
+			request:  Request{Group: "g", Replicas: 1, Resources: Resources{Cpu: 200, Ram: 200}},
+			strategy: WorstFit{},
+			hosts:    []string{"b"},
+		},
+		{
+			name:     "unhealthy hosts are skipped",
+			cluster:  cluster(host("a", "r1", 1000), host("b", "r1", 1000), sick),
+			request:  Request{Group: "g", Replicas: 1, Resources: Resources{Cpu: 1, Ram: 1}},
+			strategy: FirstFit{},
+			err:      true,
+		},
+		{
+			name:     "host anti-affinity",
+			cluster:  cluster(host("a", "r1", 0, "g"), host("b", "r1", 0), host("c", "r2", 0)),
+			request:  Request{Group: "g", Replicas: 2, Resources: Resources{Cpu: 100, Ram: 100}, AntiAffinity: Host},
+			strategy: FirstFit{},
+			hosts:    []string{"b", "c"},
+		},
+		{
+			name:     "host anti-affinity is never violated",
+			cluster:  cluster(host("a", "r1", 0), host("b", "r1", 0)),
+			request:  Request{Group: "g", Replicas: 3, Resources: Resources{Cpu: 100, Ram: 100}, AntiAffinity: Host},
+			strategy: FirstFit{},
+			err:      true,
+		},
+		{
+			name:     "rack anti-affinity",
+			cluster:  cluster(host("a", "r1", 0), host("b", "r1", 0), host("c", "r2", 0)),
+			request:  Request{Group: "g", Replicas: 2, Resources: Resources{Cpu: 100, Ram: 100}, AntiAffinity: Rack},
+			strategy: FirstFit{},
+			hosts:    []string{"a", "c"},
+		},
+		{
+			name:       "rack anti-affinity counts violations",
+			cluster:    cluster(host("a", "r1", 0), host("b", "r1", 0), host("c", "r2", 0)),
+			request:    Request{Group: "g", Replicas: 3, Resources: Resources{Cpu: 100, Ram: 100}, AntiAffinity: Rack},
+			strategy:   FirstFit{},
+			hosts:      []string{"a", "c", "b"},
+			violations: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Place(tt.cluster, &tt.request, tt.strategy)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, placed on %v", res.Hosts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res.Hosts, tt.hosts) || res.Violations != tt.violations {
+				t.Errorf("placed on %v with %d violations, want %v with %d", res.Hosts, res.Violations, tt.hosts, tt.violations)
+			}
+		})
+	}
+}
+
+func TestPlaceRollsBackOnFailure(t *testing.T) {
+	c := cluster(host("a", "r1", 0), host("b", "r1", 800))
+	before := c.Clone()
+	r := &Request{Group: "g", Replicas: 3, Resources: Resources{Cpu: 400, Ram: 400}}
+	if res, err := Place(c, r, FirstFit{}); err == nil {
+		t.Fatalf("expected error, placed on %v", res.Hosts)
+	}
+	for _, n := range c.Nodes {
+		b := before.Node(n.Id)
+		if n.Used != b.Used || n.Groups["g"] != b.Groups["g"] {
+			t.Errorf("host %s used %+v with %d replicas, want %+v with %d", n.Id, n.Used, n.Groups["g"], b.Used, b.Groups["g"])
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package placement
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// FirstFit takes the first candidate in host order.
+type FirstFit struct{}
+
+func (FirstFit) Name() string { return "first_fit" }
+
+func (FirstFit) Pick(r *Request, candidates []*Node) *Node {
+	return candidates[0]
+}
+
+// BestFit packs replicas onto the hosts left with least free resources.
+type BestFit struct{}
+
+func (BestFit) Name() string { return "best_fit" }
+
+func (BestFit) Pick(r *Request, candidates []*Node) *Node {
+	return pick(candidates, func(n *Node) float64 { return -left(n, r) })
+}
+
+// WorstFit spreads replicas onto the hosts left with most free resources.
+type WorstFit struct{}
+
+func (WorstFit) Name() string { return "worst_fit" }
+
+func (WorstFit) Pick(r *Request, candidates []*Node) *Node {
+	return pick(candidates, func(n *Node) float64 { return left(n, r) })
+}
+
+// Random picks any candidate, seeded for reproducible runs.
+type Random struct {
+	rnd *rand.Rand
+}
+
+func NewRandom(seed int64) *Random {
+	return &Random{rand.New(rand.NewSource(seed))}
+}
+
+func (*Random) Name() string { return "random" }
+
+func (s *Random) Pick(r *Request, candidates []*Node) *Node {
+	return candidates[s.rnd.Intn(len(candidates))]
+}
+
+// left is the share of host cpu and ram remaining free after placing r.
+func left(n *Node, r *Request) float64 {
+	free := n.Free().Sub(r.Resources)
+	share := 0.0
+	if n.Total.Cpu > 0 {
+		share += float64(free.Cpu) / float64(n.Total.Cpu)
+	}
+	if n.Total.Ram > 0 {
+		share += float64(free.Ram) / float64(n.Total.Ram)
+	}
+	return share / 2
+}
+
+// pick returns candidate with the highest score, the first one on ties.
+func pick(candidates []*Node, score func(*Node) float64) *Node {
+	best, bestScore := candidates[0], score(candidates[0])
+	for _, n := range candidates[1:] {
+		if s := score(n); s > bestScore {
+			best, bestScore = n, s
+		}
+	}
+	return best
+}
+
+// Strategies returns strategies by name, random ones seeded with seed.
+func Strategies(names []string, seed int64) ([]Strategy, error) {
+	result := make([]Strategy, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case "first_fit":
+			result = append(result, FirstFit{})
+		case "best_fit":
+			result = append(result, BestFit{})
+		case "worst_fit":
+			result = append(result, WorstFit{})
+		case "random":
+			result = append(result, NewRandom(seed))
+		default:
+			return nil, fmt.Errorf("unknown strategy %s, want first_fit, best_fit, worst_fit or random", name)
+		}
+	}
+	return result, nil
+}
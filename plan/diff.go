@@ -0,0 +1,138 @@
+package plan
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldChange is a single differing leaf field, Path is built from proto json names.
+type FieldChange struct {
+	Path string `json:"path"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+func (c FieldChange) String() string {
+	return fmt.Sprintf("%s: %s => %s", c.Path, c.Old, c.New)
+}
+
+// Diff compares two values of the same type (usually proto messages)
+// field by field and returns changed leaves.
+func Diff(prefix string, a, b interface{}) []FieldChange {
+	changes := make([]FieldChange, 0)
+	diffValue(prefix, reflect.ValueOf(a), reflect.ValueOf(b), &changes)
+	return changes
+}
+
+func diffValue(path string, a, b reflect.Value, out *[]FieldChange) {
+	if !a.IsValid() || !b.IsValid() {
+		if a.IsValid() != b.IsValid() {
+			*out = append(*out, FieldChange{path, format(a), format(b)})
+		}
+		return
+	}
+
+	switch a.Kind() {
+	case reflect.Ptr:
+		if a.IsNil() && b.IsNil() {
+			return
+		}
+		// compare missing message against an empty one to get leaf paths
+		if a.IsNil() {
+			a = reflect.New(a.Type().Elem())
+		}
+		if b.IsNil() {
+			b = reflect.New(b.Type().Elem())
+		}
+		diffValue(path, a.Elem(), b.Elem(), out)
+	case reflect.Struct:
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" || strings.HasPrefix(f.Name, "XXX_") {
+				continue
+			}
+			diffValue(join(path, fieldName(f)), a.Field(i), b.Field(i), out)
+		}
+	case reflect.Map:
+		keys := make(map[string]reflect.Value)
+		for _, k := range a.MapKeys() {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+		for _, k := range b.MapKeys() {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			k := keys[name]
+			av, bv := a.MapIndex(k), b.MapIndex(k)
+			p := fmt.Sprintf("%s[%s]", path, name)
+			if !av.IsValid() || !bv.IsValid() {
+				if av.IsValid() || bv.IsValid() {
+					*out = append(*out, FieldChange{p, format(av), format(bv)})
+				}
+				continue
+			}
+			diffValue(p, av, bv, out)
+		}
+	case reflect.Slice:
+		n := a.Len()
+		if b.Len() > n {
+			n = b.Len()
+		}
+		for i := 0; i < n; i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= a.Len():
+				*out = append(*out, FieldChange{p, "<none>", format(b.Index(i))})
+			case i >= b.Len():
+				*out = append(*out, FieldChange{p, format(a.Index(i)), "<none>"})
+			default:
+				diffValue(p, a.Index(i), b.Index(i), out)
+			}
+		}
+	default:
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*out = append(*out, FieldChange{path, format(a), format(b)})
+		}
+	}
+}
+
+func fieldName(f reflect.StructField) string {
+	if tag := f.Tag.Get("json"); tag != "" {
+		if name := strings.Split(tag, ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func format(v reflect.Value) string {
+	if !v.IsValid() {
+		return "<none>"
+	}
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return "<none>"
+	}
+	if v.Kind() == reflect.String {
+		return strconv.Quote(v.String())
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
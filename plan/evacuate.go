@@ -0,0 +1,64 @@
+package plan
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Evacuate plans moving replicas of group between hosts, moves maps old host
+// to new one. Hosts may carry workloads of other groups, they are ignored.
+// others must hold metadata of the new hosts.
+func Evacuate(group string, hosts []*clusterapi.Host, others []*clusterapi.Host, moves map[string]string) (*Plan, error) {
+	current := make([]*clusterapi.Host, 0)
+	for _, h := range hosts {
+		if wls := client.GroupWorkloads(group, []*clusterapi.Host{h}); len(wls) > 0 {
+			current = append(current, &clusterapi.Host{Metadata: h.Metadata, Workloads: wls})
+		}
+	}
+	wls := client.GroupWorkloads(group, current)
+	if len(wls) == 0 {
+		return nil, fmt.Errorf("group %s has no workloads", group)
+	}
+
+	desired := make(map[string][]*clusterapi.Workload)
+	for _, h := range current {
+		desired[h.Metadata.Id] = h.Workloads
+	}
+	from := make([]string, 0, len(moves))
+	for old, host := range moves {
+		if _, ok := desired[old]; !ok {
+			return nil, fmt.Errorf("group %s has no workloads on host %s", group, old)
+		}
+		if _, ok := desired[host]; ok {
+			return nil, fmt.Errorf("group %s already has workloads on host %s", group, host)
+		}
+		next := make([]*clusterapi.Workload, 0, len(desired[old]))
+		for _, wl := range desired[old] {
+			wl = proto.Clone(wl).(*clusterapi.Workload)
+			wl.Feedback = nil
+			wl.TransitionTimestamp = uint64(time.Now().Unix())
+			if wl.Id != nil && wl.Id.Slot != nil {
+				wl.Id.Slot.Host = host
+			}
+			next = append(next, wl)
+		}
+		desired[host] = next
+		delete(desired, old)
+		from = append(from, old)
+	}
+	sort.Strings(from)
+
+	gen := Generation(wls)
+	p, err := makePlan(group, wls[0].Owner, desired, current, others, gen, gen)
+	if err != nil {
+		return nil, err
+	}
+	p.Message = fmt.Sprintf("evacuate group %s from %s", group, strings.Join(from, ", "))
+	return p, nil
+}
@@ -0,0 +1,449 @@
+// Package plan computes changes a task makes to its group and applies them
+// only if hosts did not change since planning.
+package plan
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/spec"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+type Action string
+
+const (
+	Add    Action = "add"
+	Modify Action = "modify"
+	Remove Action = "remove"
+	Keep   Action = "keep"
+)
+
+var actionSign = map[Action]string{Add: "+", Modify: "~", Remove: "-", Keep: " "}
+
+// HostChange is what happens to group workloads on one host.
+type HostChange struct {
+	Host   string        `json:"host"`
+	Etag   int64         `json:"etag"`
+	Action Action        `json:"action"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// Plan is a group transition together with the host etags it was computed against.
+type Plan struct {
+	GroupId        string                      `json:"groupId"`
+	Created        time.Time                   `json:"created"`
+	Endpoint       string                      `json:"endpoint"`
+	FromGeneration string                      `json:"fromGeneration"`
+	Generation     string                      `json:"generation"`
+	Message        string                      `json:"message,omitempty"`
+	Changes        []*HostChange               `json:"changes"`
+	Transition     *clusterapi.GroupTransition `json:"transition"`
+}
+
+// Build fetches current state of task group and plans the task against it.
+func Build(c *client.Client, s *spec.Spec) (*Plan, error) {
+	current, others, err := fetch(c, s.GroupId(), s.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Make(s, current, others)
+	if err != nil {
+		return nil, err
+	}
+	p.Endpoint = c.URL()
+	return p, nil
+}
+
+// BuildRestore fetches current state of group and plans bringing back
+// workloads of a previously applied transition t.
+func BuildRestore(c *client.Client, t *clusterapi.GroupTransition) (*Plan, error) {
+	hosts := make([]string, 0, len(t.Transitions))
+	for _, tr := range t.Transitions {
+		hosts = append(hosts, tr.HostId)
+	}
+	current, others, err := fetch(c, t.GroupId, hosts)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Restore(t, current, others)
+	if err != nil {
+		return nil, err
+	}
+	p.Endpoint = c.URL()
+	return p, nil
+}
+
+// fetch returns state of group and of hosts not running the group yet.
+func fetch(c *client.Client, group string, hosts []string) ([]*clusterapi.Host, []*clusterapi.Host, error) {
+	current, err := c.GroupState(group)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get state of group %s: %v", group, err)
+	}
+
+	known := make(map[string]bool)
+	for _, h := range current {
+		known[h.Metadata.Id] = true
+	}
+	missing := make([]string, 0)
+	for _, h := range hosts {
+		if !known[h] {
+			missing = append(missing, h)
+		}
+	}
+	others, err := c.HostsState(missing)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get state of hosts %v: %v", missing, err)
+	}
+	return current, others, nil
+}
+
+// Make plans task s. current is group state as returned by client.GroupState,
+// others carries metadata of task hosts not running the group yet.
+func Make(s *spec.Spec, current []*clusterapi.Host, others []*clusterapi.Host) (*Plan, error) {
+	group := s.GroupId()
+	hosts := s.Hosts
+	if len(hosts) == 0 {
+		for _, h := range current {
+			hosts = append(hosts, h.Metadata.Id)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts to run group %s on, set hosts in task", group)
+	}
+
+	from := Generation(client.GroupWorkloads(group, current))
+	gen := from
+	if !sameFingerprint(client.GroupWorkloads(group, current), s.Fingerprint()) {
+		gen = NextGeneration(from)
+	}
+
+	desired := make(map[string][]*clusterapi.Workload)
+	for _, h := range hosts {
+		desired[h] = []*clusterapi.Workload{s.Workload(h, gen)}
+	}
+	p, err := makePlan(group, s.CapiOwner(), desired, current, others, from, gen)
+	if err != nil {
+		return nil, err
+	}
+	p.Message = fmt.Sprintf("apply task %s version %s to group %s", s.SlotService(), s.Version, group)
+	return p, nil
+}
+
+// Restore plans bringing group back to workloads of transition t. Restored
+// workloads get a new generation so generations keep growing.
+func Restore(t *clusterapi.GroupTransition, current []*clusterapi.Host, others []*clusterapi.Host) (*Plan, error) {
+	from := Generation(client.GroupWorkloads(t.GroupId, current))
+	gen := NextGeneration(from)
+
+	desired := make(map[string][]*clusterapi.Workload)
+	for _, tr := range t.Transitions {
+		if len(tr.Workloads) == 0 {
+			continue
+		}
+		wls := make([]*clusterapi.Workload, 0, len(tr.Workloads))
+		for _, wl := range tr.Workloads {
+			wl = proto.Clone(wl).(*clusterapi.Workload)
+			wl.Feedback = nil
+			wl.Generation = gen
+			wl.TransitionTimestamp = uint64(time.Now().Unix())
+			wls = append(wls, wl)
+		}
+		desired[tr.HostId] = wls
+	}
+	if len(desired) == 0 {
+		return nil, fmt.Errorf("transition of group %s has no workloads to restore", t.GroupId)
+	}
+	p, err := makePlan(t.GroupId, t.Owner, desired, current, others, from, gen)
+	if err != nil {
+		return nil, err
+	}
+	p.Message = fmt.Sprintf("restore group %s from operation %s", t.GroupId, t.GroupOperationId)
+	return p, nil
+}
+
+func makePlan(group string, owner *clusterapi.Owner, desired map[string][]*clusterapi.Workload,
+	current []*clusterapi.Host, others []*clusterapi.Host, from, gen string) (*Plan, error) {
+	meta := make(map[string]*clusterapi.HostMetadata)
+	for _, h := range others {
+		meta[h.Metadata.Id] = h.GetMetadata()
+	}
+	running := make(map[string]*clusterapi.Host)
+	for _, h := range current {
+		meta[h.Metadata.Id] = h.GetMetadata()
+		running[h.Metadata.Id] = h
+	}
+
+	p := &Plan{
+		GroupId:        group,
+		Created:        time.Now(),
+		FromGeneration: from,
+		Generation:     gen,
+		Changes:        make([]*HostChange, 0),
+		Transition: &clusterapi.GroupTransition{
+			GroupId:     group,
+			Owner:       owner,
+			Transitions: make([]*clusterapi.Transition, 0),
+		},
+	}
+
+	for h, wls := range desired {
+		m, ok := meta[h]
+		if !ok {
+			return nil, fmt.Errorf("host %s not found in cluster state", h)
+		}
+
+		change := &HostChange{Host: h, Etag: m.Etag, Action: Add}
+		if r, ok := running[h]; ok {
+			change.Fields = CompareWorkloads(r.GetWorkloads(), wls)
+			change.Action = Modify
+			if len(change.Fields) == 0 {
+				change.Action = Keep
+				// keep workloads untouched so their transition timestamp stays
+				wls = make([]*clusterapi.Workload, 0, len(r.Workloads))
+				for _, wl := range r.Workloads {
+					wl = proto.Clone(wl).(*clusterapi.Workload)
+					wl.Feedback = nil
+					wls = append(wls, wl)
+				}
+			}
+		}
+		p.Changes = append(p.Changes, change)
+		p.Transition.Transitions = append(p.Transition.Transitions, &clusterapi.Transition{
+			HostId:        h,
+			HostStateEtag: m.Etag,
+			Workloads:     wls,
+		})
+	}
+
+	for h, r := range running {
+		if _, ok := desired[h]; ok {
+			continue
+		}
+		p.Changes = append(p.Changes, &HostChange{Host: h, Etag: r.Metadata.Etag, Action: Remove})
+		// empty transition still pins host etag
+		p.Transition.Transitions = append(p.Transition.Transitions, &clusterapi.Transition{
+			HostId:        h,
+			HostStateEtag: r.Metadata.Etag,
+			Workloads:     []*clusterapi.Workload{},
+		})
+	}
+
+	sort.Slice(p.Changes, func(i, j int) bool { return p.Changes[i].Host < p.Changes[j].Host })
+	sort.Slice(p.Transition.Transitions, func(i, j int) bool {
+		return p.Transition.Transitions[i].HostId < p.Transition.Transitions[j].HostId
+	})
+	return p, nil
+}
+
+// CompareWorkloads diffs two workload lists of one host. Workloads are
+// matched by slot service, preferring the same generation when a slot holds
+// several of them (e.g. ACTIVE and PREPARED versions).
+func CompareWorkloads(old []*clusterapi.Workload, new []*clusterapi.Workload) []FieldChange {
+	changes := make([]FieldChange, 0)
+	single := len(old) <= 1 && len(new) <= 1
+	matched := make(map[*clusterapi.Workload]bool)
+
+	match := func(n *clusterapi.Workload, sameGen bool) *clusterapi.Workload {
+		for _, o := range old {
+			if matched[o] || client.WorkloadService(o) != client.WorkloadService(n) {
+				continue
+			}
+			if !sameGen || o.Generation == n.Generation {
+				return o
+			}
+		}
+		return nil
+	}
+
+	for _, n := range new {
+		o := match(n, true)
+		if o == nil {
+			o = match(n, false)
+		}
+		if o == nil {
+			changes = append(changes, FieldChange{workloadName(n), "<none>", "present"})
+			continue
+		}
+		matched[o] = true
+		prefix := ""
+		if !single {
+			prefix = workloadName(n)
+		}
+		changes = append(changes, Diff(prefix, strip(o), strip(n))...)
+	}
+	for _, o := range old {
+		if !matched[o] {
+			changes = append(changes, FieldChange{workloadName(o), "present", "<none>"})
+		}
+	}
+	return changes
+}
+
+func workloadName(wl *clusterapi.Workload) string {
+	return fmt.Sprintf("workload[%s@%s]", client.WorkloadService(wl), wl.Generation)
+}
+
+// strip drops workload fields which are not part of the desired state.
+func strip(wl *clusterapi.Workload) *clusterapi.Workload {
+	c := proto.Clone(wl).(*clusterapi.Workload)
+	c.Feedback = nil
+	c.TransitionTimestamp = 0
+	c.Generation = ""
+	return c
+}
+
+func sameFingerprint(wls []*clusterapi.Workload, fp string) bool {
+	if len(wls) == 0 {
+		return false
+	}
+	for _, wl := range wls {
+		if client.WorkloadFingerprint(wl) != fp {
+			return false
+		}
+	}
+	return true
+}
+
+// Generation returns the highest generation among workloads, "0" if there is none.
+func Generation(wls []*clusterapi.Workload) string {
+	max := 0
+	for _, wl := range wls {
+		if g, err := strconv.Atoi(wl.Generation); err == nil && g > max {
+			max = g
+		}
+	}
+	return strconv.Itoa(max)
+}
+
+// NextGeneration returns generation following gen.
+func NextGeneration(gen string) string {
+	g, _ := strconv.Atoi(gen)
+	return strconv.Itoa(g + 1)
+}
+
+// Empty reports whether applying the plan changes nothing.
+func (p *Plan) Empty() bool {
+	for _, c := range p.Changes {
+		if c.Action != Keep {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns number of hosts with action a.
+func (p *Plan) Count(a Action) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == a {
+			n++
+		}
+	}
+	return n
+}
+
+// Summary describes plan in one line: message, generations and changes.
+func (p *Plan) Summary() string {
+	msg := p.Message
+	if msg == "" {
+		msg = "apply group " + p.GroupId
+	}
+	return fmt.Sprintf("%s, generation %s => %s: %d to add, %d to modify, %d to remove",
+		msg, p.FromGeneration, p.Generation, p.Count(Add), p.Count(Modify), p.Count(Remove))
+}
+
+// Print writes human readable plan to w.
+func (p *Plan) Print(w io.Writer) {
+	fmt.Fprintf(w, "group %s, generation %s => %s\n", p.GroupId, p.FromGeneration, p.Generation)
+	for _, c := range p.Changes {
+		fmt.Fprintf(w, "  %s %s (etag %d)\n", actionSign[c.Action], c.Host, c.Etag)
+		for _, f := range c.Fields {
+			fmt.Fprintf(w, "      %s\n", f)
+		}
+	}
+	fmt.Fprintf(w, "Plan: %d to add, %d to change, %d to remove, %d unchanged.\n",
+		p.Count(Add), p.Count(Modify), p.Count(Remove), p.Count(Keep))
+}
+
+// Check verifies that hosts still have the etags the plan was made against.
+func (p *Plan) Check(hosts []*clusterapi.Host) error {
+	etags := make(map[string]int64)
+	for _, h := range hosts {
+		etags[h.Metadata.Id] = h.Metadata.Etag
+	}
+	stale := make([]string, 0)
+	for _, c := range p.Changes {
+		etag, ok := etags[c.Host]
+		if !ok {
+			stale = append(stale, fmt.Sprintf("%s (gone)", c.Host))
+		} else if etag != c.Etag {
+			stale = append(stale, fmt.Sprintf("%s (etag %d => %d)", c.Host, c.Etag, etag))
+		}
+	}
+	if len(stale) > 0 {
+		return fmt.Errorf("plan is stale, hosts changed since planning: %v", stale)
+	}
+	return nil
+}
+
+// Hosts returns all hosts touched by the plan.
+func (p *Plan) Hosts() []string {
+	hosts := make([]string, 0, len(p.Changes))
+	for _, c := range p.Changes {
+		hosts = append(hosts, c.Host)
+	}
+	return hosts
+}
+
+// Apply re-checks host etags and applies plan transition.
+func Apply(c *client.Client, p *Plan) error {
+	hosts, err := c.HostsState(p.Hosts())
+	if err != nil {
+		return fmt.Errorf("failed to get state of planned hosts: %v", err)
+	}
+	if err := p.Check(hosts); err != nil {
+		return err
+	}
+
+	if p.Transition.GroupOperationId == "" {
+		p.Transition.GroupOperationId = client.NewOperationId()
+	}
+	resp, err := c.Apply(&clusterapi.ApplyGroupTransitionRequest{
+		GroupTransitions:   []*clusterapi.GroupTransition{p.Transition},
+		SchedulerSignature: c.Sign(p.Transition.GroupOperationId, p.Summary()),
+	})
+	if err != nil {
+		return err
+	}
+	return client.ApplyError(resp)
+}
+
+// Save writes plan to path as json.
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Load reads plan saved by Save.
+func Load(path string) (*Plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := new(Plan)
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %v", path, err)
+	}
+	return p, nil
+}
@@ -0,0 +1,181 @@
+package plan
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"capi_tools/spec"
+	"reflect"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	v1 := fixture.Spec("1")
+	tests := []struct {
+		name    string
+		task    *spec.Spec
+		current []*clusterapi.Host
+		others  []*clusterapi.Host
+		actions map[string]Action
+		from    string
+		gen     string
+		err     bool
+	}{
+		{
+			name:    "new group",
+			task:    fixture.Spec("1", "a", "b"),
+			others:  fixture.Hosts("a", "b"),
+			actions: map[string]Action{"a": Add, "b": Add},
+			from:    "0",
+			gen:     "1",
+		},
+		{
+			name:    "unchanged group",
+			task:    fixture.Spec("1", "a", "b"),
+			current: fixture.Running(v1, "1", "a", "b"),
+			actions: map[string]Action{"a": Keep, "b": Keep},
+			from:    "1",
+			gen:     "1",
+		},
+		{
+			name:    "hosts kept when task has none",
+			task:    fixture.Spec("1"),
+			current: fixture.Running(v1, "3", "a", "b"),
+			actions: map[string]Action{"a": Keep, "b": Keep},
+			from:    "3",
+			gen:     "3",
+		},
+		{
+			name:    "new version",
+			task:    fixture.Spec("2", "a"),
+			current: fixture.Running(v1, "1", "a"),
+			actions: map[string]Action{"a": Modify},
+			from:    "1",
+			gen:     "2",
+		},
+		{
+			name:    "moved replica",
+			task:    fixture.Spec("1", "a", "c"),
+			current: fixture.Running(v1, "1", "a", "b"),
+			others:  fixture.Hosts("c"),
+			actions: map[string]Action{"a": Keep, "b": Remove, "c": Add},
+			from:    "1",
+			gen:     "1",
+		},
+		{
+			name:   "unknown host",
+			task:   fixture.Spec("1", "a", "x"),
+			others: fixture.Hosts("a"),
+			err:    true,
+		},
+		{
+			name: "no hosts",
+			task: fixture.Spec("1"),
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Make(tt.task, tt.current, tt.others)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got plan %s", p.Summary())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			actions := make(map[string]Action)
+			for _, c := range p.Changes {
+				actions[c.Host] = c.Action
+			}
+			if !reflect.DeepEqual(actions, tt.actions) {
+				t.Errorf("actions %v, want %v", actions, tt.actions)
+			}
+			if p.FromGeneration != tt.from || p.Generation != tt.gen {
+				t.Errorf("generation %s => %s, want %s => %s", p.FromGeneration, p.Generation, tt.from, tt.gen)
+			}
+			if len(p.Transition.Transitions) != len(tt.actions) {
+				t.Errorf("%d transitions, want %d", len(p.Transition.Transitions), len(tt.actions))
+			}
+			for _, tr := range p.Transition.Transitions {
+				if n := len(tr.Workloads); (actions[tr.HostId] == Remove) != (n == 0) {
+					t.Errorf("host %s (%s) has %d workloads", tr.HostId, actions[tr.HostId], n)
+				}
+			}
+		})
+	}
+}
+
+func TestMakeKeepsUnchangedWorkloads(t *testing.T) {
+	s := fixture.Spec("1", "a")
+	current := fixture.Running(s, "1", "a")
+	current[0].Workloads[0].TransitionTimestamp = 42
+	p, err := Make(s, current, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Empty() {
+		t.Fatalf("plan is not empty: %s", p.Summary())
+	}
+	if ts := p.Transition.Transitions[0].Workloads[0].TransitionTimestamp; ts != 42 {
+		t.Errorf("transition timestamp %d, want 42", ts)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type inner struct {
+		Value string `json:"value"`
+	}
+	type outer struct {
+		Name  string            `json:"name"`
+		Count int               `json:"count,omitempty"`
+		Inner *inner            `json:"inner"`
+		Tags  map[string]string `json:"tags"`
+		List  []string          `json:"list"`
+	}
+	tests := []struct {
+		name string
+		a, b *outer
+		want []FieldChange
+	}{
+		{
+			name: "equal",
+			a:    &outer{Name: "x", Tags: map[string]string{"k": "v"}},
+			b:    &outer{Name: "x", Tags: map[string]string{"k": "v"}},
+			want: []FieldChange{},
+		},
+		{
+			name: "scalars",
+			a:    &outer{Name: "x", Count: 1},
+			b:    &outer{Name: "y", Count: 2},
+			want: []FieldChange{{"w.name", `"x"`, `"y"`}, {"w.count", "1", "2"}},
+		},
+		{
+			name: "missing message compares to empty one",
+			a:    &outer{},
+			b:    &outer{Inner: &inner{Value: "v"}},
+			want: []FieldChange{{"w.inner.value", `""`, `"v"`}},
+		},
+		{
+			name: "map keys",
+			a:    &outer{Tags: map[string]string{"a": "1", "b": "2"}},
+			b:    &outer{Tags: map[string]string{"b": "3", "c": "4"}},
+			want: []FieldChange{{"w.tags[a]", `"1"`, "<none>"}, {"w.tags[b]", `"2"`, `"3"`}, {"w.tags[c]", "<none>", `"4"`}},
+		},
+		{
+			name: "slice length",
+			a:    &outer{List: []string{"a", "b"}},
+			b:    &outer{List: []string{"a"}},
+			want: []FieldChange{{"w.list[1]", `"b"`, "<none>"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff("w", tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package plan
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/spec"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// workload target states
+const (
+	Active   = "ACTIVE"
+	Prepared = "PREPARED"
+	Removed  = "REMOVED"
+)
+
+// ValidTargetState reports whether capi accepts state as workload target state.
+func ValidTargetState(state string) bool {
+	return state == Active || state == Prepared || state == Removed
+}
+
+// BuildPrepare fetches current state of task group and plans the first
+// step of a two step deploy, see Prepare.
+func BuildPrepare(c *client.Client, s *spec.Spec) (*Plan, error) {
+	current, others, err := fetch(c, s.GroupId(), s.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Prepare(s, current, others)
+	if err != nil {
+		return nil, err
+	}
+	p.Endpoint = c.URL()
+	return p, nil
+}
+
+// Prepare plans the first step of a two step deploy: new workloads are
+// created PREPARED next to the ACTIVE ones, so resources get downloaded
+// without touching the running version. Activate finishes the deploy.
+func Prepare(s *spec.Spec, current []*clusterapi.Host, others []*clusterapi.Host) (*Plan, error) {
+	p, err := Make(s, current, others)
+	if err != nil {
+		return nil, err
+	}
+	p.Message = fmt.Sprintf("prepare task %s version %s in group %s", s.SlotService(), s.Version, s.GroupId())
+
+	running := make(map[string][]*clusterapi.Workload)
+	for _, h := range current {
+		running[h.Metadata.Id] = h.Workloads
+	}
+	changes := make(map[string]*HostChange)
+	for _, ch := range p.Changes {
+		changes[ch.Host] = ch
+	}
+	for _, t := range p.Transition.Transitions {
+		ch := changes[t.HostId]
+		if ch.Action != Add && ch.Action != Modify {
+			continue
+		}
+		for _, wl := range t.Workloads {
+			wl.TargetState = Prepared
+		}
+		// versions prepared before are replaced by the new one
+		for _, wl := range running[t.HostId] {
+			if wl.TargetState != Active {
+				continue
+			}
+			wl = proto.Clone(wl).(*clusterapi.Workload)
+			wl.Feedback = nil
+			t.Workloads = append(t.Workloads, wl)
+		}
+		if len(running[t.HostId]) == 0 {
+			continue
+		}
+		ch.Fields = CompareWorkloads(running[t.HostId], t.Workloads)
+		ch.Action = Modify
+		if len(ch.Fields) == 0 {
+			ch.Action = Keep
+		}
+	}
+	return p, nil
+}
+
+// BuildActivate fetches group state and plans switching the newest PREPARED
+// generation to ACTIVE, workloads of older generations on those hosts are dropped.
+func BuildActivate(c *client.Client, group string) (*Plan, error) {
+	current, err := c.GroupState(group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state of group %s: %v", group, err)
+	}
+	p, err := Activate(group, current)
+	if err != nil {
+		return nil, err
+	}
+	p.Endpoint = c.URL()
+	return p, nil
+}
+
+// Activate plans switching the newest generation of group to ACTIVE.
+func Activate(group string, current []*clusterapi.Host) (*Plan, error) {
+	wls := client.GroupWorkloads(group, current)
+	if len(wls) == 0 {
+		return nil, fmt.Errorf("group %s has no workloads", group)
+	}
+	gen := Generation(wls)
+
+	desired := make(map[string][]*clusterapi.Workload)
+	for _, h := range current {
+		prepared := false
+		for _, wl := range h.Workloads {
+			if wl.Generation == gen && wl.TargetState == Prepared {
+				prepared = true
+			}
+		}
+		if !prepared {
+			desired[h.Metadata.Id] = h.Workloads
+			continue
+		}
+
+		next := make([]*clusterapi.Workload, 0)
+		for _, wl := range h.Workloads {
+			if wl.Generation != gen {
+				continue
+			}
+			wl = proto.Clone(wl).(*clusterapi.Workload)
+			wl.Feedback = nil
+			wl.TargetState = Active
+			wl.TransitionTimestamp = uint64(time.Now().Unix())
+			next = append(next, wl)
+		}
+		desired[h.Metadata.Id] = next
+	}
+	p, err := makePlan(group, wls[0].Owner, desired, current, nil, gen, gen)
+	if err != nil {
+		return nil, err
+	}
+	p.Message = fmt.Sprintf("activate generation %s of group %s", gen, group)
+	return p, nil
+}
+
+// BuildSetState fetches group state and plans moving group workloads on hosts to state.
+func BuildSetState(c *client.Client, group string, hosts []string, state string) (*Plan, error) {
+	current, err := c.GroupState(group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state of group %s: %v", group, err)
+	}
+	p, err := SetState(group, current, hosts, state)
+	if err != nil {
+		return nil, err
+	}
+	p.Endpoint = c.URL()
+	return p, nil
+}
+
+// SetState plans changing target state of group workloads on hosts, all
+// other replicas stay as they are.
+func SetState(group string, current []*clusterapi.Host, hosts []string, state string) (*Plan, error) {
+	if !ValidTargetState(state) {
+		return nil, fmt.Errorf("bad target state %s, want one of %s, %s, %s", state, Active, Prepared, Removed)
+	}
+	wls := client.GroupWorkloads(group, current)
+	if len(wls) == 0 {
+		return nil, fmt.Errorf("group %s has no workloads", group)
+	}
+
+	selected := make(map[string]bool)
+	for _, h := range hosts {
+		selected[h] = true
+	}
+
+	desired := make(map[string][]*clusterapi.Workload)
+	for _, h := range current {
+		if !selected[h.Metadata.Id] {
+			desired[h.Metadata.Id] = h.Workloads
+			continue
+		}
+		delete(selected, h.Metadata.Id)
+
+		next := make([]*clusterapi.Workload, 0, len(h.Workloads))
+		for _, wl := range h.Workloads {
+			wl = proto.Clone(wl).(*clusterapi.Workload)
+			wl.Feedback = nil
+			if wl.TargetState != state {
+				wl.TargetState = state
+				wl.TransitionTimestamp = uint64(time.Now().Unix())
+			}
+			next = append(next, wl)
+		}
+		desired[h.Metadata.Id] = next
+	}
+	for h := range selected {
+		return nil, fmt.Errorf("group %s has no workloads on host %s", group, h)
+	}
+
+	gen := Generation(wls)
+	p, err := makePlan(group, wls[0].Owner, desired, current, nil, gen, gen)
+	if err != nil {
+		return nil, err
+	}
+	p.Message = fmt.Sprintf("set %s on %d hosts of group %s", state, len(hosts), group)
+	return p, nil
+}
+
+// Divergence is target and current state of one workload.
+type Divergence struct {
+	Host       string
+	Service    string
+	Generation string
+	Target     string
+	Current    string
+}
+
+// Diverged reports whether workload has not reached its target state.
+func (d *Divergence) Diverged() bool {
+	return d.Target != d.Current
+}
+
+// Divergences returns target and current state of every group workload.
+func Divergences(group string, current []*clusterapi.Host) []*Divergence {
+	result := make([]*Divergence, 0)
+	for _, wl := range client.GroupWorkloads(group, current) {
+		d := &Divergence{
+			Host:       client.WorkloadHost(wl),
+			Service:    client.WorkloadService(wl),
+			Generation: wl.Generation,
+			Target:     wl.TargetState,
+			Current:    "UNKNOWN",
+		}
+		if wl.Feedback != nil && wl.Feedback.CurrentState != "" {
+			d.Current = wl.Feedback.CurrentState
+		}
+		result = append(result, d)
+	}
+	return result
+}
@@ -0,0 +1,76 @@
+package plan
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"reflect"
+	"testing"
+)
+
+func TestPrepare(t *testing.T) {
+	v1, v2 := fixture.Spec("1"), fixture.Spec("2")
+	prepared := fixture.Running(v1, "1", "a")
+	wl := v2.Workload("a", "2")
+	wl.TargetState = Prepared
+	prepared[0].Workloads = append(prepared[0].Workloads, wl)
+
+	tests := []struct {
+		name    string
+		task    string
+		current []*clusterapi.Host
+		others  []*clusterapi.Host
+		action  Action
+		// generation and target state of workloads in the transition
+		workloads []string
+	}{
+		{
+			name:      "new host",
+			task:      "2",
+			others:    fixture.Hosts("a"),
+			action:    Add,
+			workloads: []string{"1 PREPARED"},
+		},
+		{
+			name:      "next to the running version",
+			task:      "2",
+			current:   fixture.Running(v1, "1", "a"),
+			action:    Modify,
+			workloads: []string{"2 PREPARED", "1 ACTIVE"},
+		},
+		{
+			name:      "replaces a prepared version",
+			task:      "3",
+			current:   prepared,
+			action:    Modify,
+			workloads: []string{"3 PREPARED", "1 ACTIVE"},
+		},
+		{
+			name:      "unchanged",
+			task:      "1",
+			current:   fixture.Running(v1, "1", "a"),
+			action:    Keep,
+			workloads: []string{"1 ACTIVE"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Prepare(fixture.Spec(tt.task, "a"), tt.current, tt.others)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Changes) != 1 || p.Changes[0].Action != tt.action {
+				t.Fatalf("changes %v, want %s of a", p.Changes, tt.action)
+			}
+			if (tt.action == Modify) != (len(p.Changes[0].Fields) > 0) {
+				t.Errorf("%s with fields %v", tt.action, p.Changes[0].Fields)
+			}
+			got := make([]string, 0)
+			for _, wl := range p.Transition.Transitions[0].Workloads {
+				got = append(got, wl.Generation+" "+wl.TargetState)
+			}
+			if !reflect.DeepEqual(got, tt.workloads) {
+				t.Errorf("workloads %v, want %v", got, tt.workloads)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+// Package prompt asks the operator before destructive actions.
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Confirm prints msg to stderr and tells whether the answer read from stdin
+// is yes.
+func Confirm(msg string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", msg)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,219 @@
+// Package quota checks group transitions against local per project limits
+// before they are sent, capi only answers QuotaViolationException without
+// saying which resource is over. Limits live in ~/.capi_tools/quota.yaml:
+//
+//	projects:
+//	  CAPIDEVNETS:
+//	    cpu: 3200
+//	    ram: 256G
+//	    disk: 2T
+package quota
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/localuser"
+	"capi_tools/placement"
+	"capi_tools/spec"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Resource names in reports.
+const (
+	Cpu  = "cpu"
+	Ram  = "ram"
+	Disk = "disk"
+)
+
+// Limit of a project, cpu in percents of core, ram and disk in spec sizes.
+// Missing or zero value is no limit.
+type Limit struct {
+	Cpu  uint64 `yaml:"cpu"`
+	Ram  string `yaml:"ram"`
+	Disk string `yaml:"disk"`
+}
+
+type Config struct {
+	Projects map[string]*Limit `yaml:"projects"`
+
+	limits map[string]placement.Resources
+}
+
+// DefaultPath is ~/.capi_tools/quota.yaml.
+func DefaultPath() string {
+	return localuser.Path("quota.yaml")
+}
+
+// Load reads config from path, missing file has no limits.
+func Load(path string) (*Config, error) {
+	c := &Config{Projects: make(map[string]*Limit)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, c.parse()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if err := c.parse(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) parse() error {
+	c.limits = make(map[string]placement.Resources)
+	for project, l := range c.Projects {
+		if l == nil {
+			continue
+		}
+		ram, err := spec.ParseBytes(l.Ram)
+		if err != nil {
+			return fmt.Errorf("project %s: bad ram %q: %v", project, l.Ram, err)
+		}
+		disk, err := spec.ParseBytes(l.Disk)
+		if err != nil {
+			return fmt.Errorf("project %s: bad disk %q: %v", project, l.Disk, err)
+		}
+		c.limits[project] = placement.Resources{Cpu: l.Cpu, Ram: ram, Disk: disk}
+	}
+	return nil
+}
+
+// Limits returns limits of project and whether it has any.
+func (c *Config) Limits(project string) (placement.Resources, bool) {
+	l, ok := c.limits[project]
+	return l, ok
+}
+
+// Usage sums resources of workloads in cstate by owner project.
+func Usage(cstate *clusterapi.ClusterState) map[string]placement.Resources {
+	usage := make(map[string]placement.Resources)
+	for _, h := range cstate.Hosts {
+		for _, wl := range h.Workloads {
+			if wl.Owner == nil {
+				continue
+			}
+			usage[wl.Owner.ProjectId] = usage[wl.Owner.ProjectId].Add(placement.FromComputing(client.WorkloadResources(wl)))
+		}
+	}
+	return usage
+}
+
+// Delta is how much resources of its project t adds, workloads of the group
+// on a host are replaced by the ones of its transition. Freed resources are
+// returned separately as usage can not go negative.
+func Delta(cstate *clusterapi.ClusterState, t *clusterapi.GroupTransition) (added, freed placement.Resources) {
+	touched := make(map[string]bool)
+	for _, tr := range t.Transitions {
+		touched[tr.HostId] = true
+		for _, wl := range tr.Workloads {
+			added = added.Add(placement.FromComputing(client.WorkloadResources(wl)))
+		}
+	}
+	for _, h := range cstate.Hosts {
+		if h.Metadata == nil || !touched[h.Metadata.Id] {
+			continue
+		}
+		for _, wl := range h.Workloads {
+			if client.WorkloadGroup(wl) == t.GroupId {
+				freed = freed.Add(placement.FromComputing(client.WorkloadResources(wl)))
+			}
+		}
+	}
+	return added, freed
+}
+
+// Line is usage of one resource of a project.
+type Line struct {
+	Project  string `json:"project"`
+	Resource string `json:"resource"`
+	Used     uint64 `json:"used"`
+	// usage after the checked transition, equal to Used in reports
+	Planned uint64 `json:"planned"`
+	// 0 is no limit
+	Limit uint64 `json:"limit"`
+}
+
+// Over reports whether planned usage exceeds a set limit.
+func (l *Line) Over() bool {
+	return l.Limit > 0 && l.Planned > l.Limit
+}
+
+// Percent is planned usage in percents of limit, 0 without limit.
+func (l *Line) Percent() float64 {
+	if l.Limit == 0 {
+		return 0
+	}
+	return 100 * float64(l.Planned) / float64(l.Limit)
+}
+
+func lines(project string, used, planned, limit placement.Resources) []*Line {
+	return []*Line{
+		{project, Cpu, used.Cpu, planned.Cpu, limit.Cpu},
+		{project, Ram, used.Ram, planned.Ram, limit.Ram},
+		{project, Disk, used.Disk, planned.Disk, limit.Disk},
+	}
+}
+
+// Report lists usage against limits of projects, all projects with usage or
+// limits when projects is empty.
+func (c *Config) Report(cstate *clusterapi.ClusterState, projects []string) []*Line {
+	usage := Usage(cstate)
+	if len(projects) == 0 {
+		seen := make(map[string]bool)
+		for p := range usage {
+			seen[p] = true
+		}
+		for p := range c.limits {
+			seen[p] = true
+		}
+		for p := range seen {
+			projects = append(projects, p)
+		}
+	}
+	sort.Strings(projects)
+
+	report := make([]*Line, 0, 3*len(projects))
+	for _, p := range projects {
+		limit, _ := c.Limits(p)
+		report = append(report, lines(p, usage[p], usage[p], limit)...)
+	}
+	return report
+}
+
+// Check returns usage of the project of t after applying it. Error lists
+// resources t grows over limit, a project already over its limit may still
+// shrink or keep its usage. Projects without limits always pass.
+func (c *Config) Check(cstate *clusterapi.ClusterState, t *clusterapi.GroupTransition) ([]*Line, error) {
+	if t.Owner == nil {
+		return nil, fmt.Errorf("group %s has no owner", t.GroupId)
+	}
+	project := t.Owner.ProjectId
+	limit, ok := c.Limits(project)
+	if !ok {
+		return nil, nil
+	}
+	used := Usage(cstate)[project]
+	added, freed := Delta(cstate, t)
+	check := lines(project, used, used.Add(added).Sub(freed), limit)
+
+	over := make([]string, 0)
+	for _, l := range check {
+		if l.Over() && l.Planned > l.Used {
+			over = append(over, fmt.Sprintf("%s %d > %d", l.Resource, l.Planned, l.Limit))
+		}
+	}
+	if len(over) > 0 {
+		return check, fmt.Errorf("group %s exceeds quota of project %s: %s", t.GroupId, project, strings.Join(over, ", "))
+	}
+	return check, nil
+}
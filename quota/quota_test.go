@@ -0,0 +1,203 @@
+package quota
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"capi_tools/placement"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// workload of group in project using cpu percents, cpu megabytes of ram
+// and cpu gigabytes of disk
+func workload(group, project string, cpu uint32) *clusterapi.Workload {
+	return fixture.Workload(group, "", fixture.OwnedBy(fixture.Owner, project),
+		fixture.Resources(cpu, uint64(cpu)<<20), fixture.Disk(uint64(cpu)<<30))
+}
+
+func transition(group, project string, hosts map[string]uint32) *clusterapi.GroupTransition {
+	t := &clusterapi.GroupTransition{GroupId: group, Owner: &clusterapi.Owner{OwnerId: fixture.Owner, ProjectId: project}}
+	for h, cpu := range hosts {
+		tr := &clusterapi.Transition{HostId: h}
+		if cpu > 0 {
+			tr.Workloads = []*clusterapi.Workload{workload(group, project, cpu)}
+		}
+		t.Transitions = append(t.Transitions, tr)
+	}
+	return t
+}
+
+var cstate = fixture.State(
+	fixture.Host("a", workload("g", "P", 100), workload("other", "P", 300)),
+	fixture.Host("b", workload("g", "P", 100), workload("foreign", "Q", 500)),
+	fixture.Host("c"),
+)
+
+func TestDelta(t *testing.T) {
+	tests := []struct {
+		name         string
+		transition   *clusterapi.GroupTransition
+		added, freed uint64
+	}{
+		{
+			name:       "resize",
+			transition: transition("g", "P", map[string]uint32{"a": 200, "b": 200}),
+			added:      400,
+			freed:      200,
+		},
+		{
+			name:       "move",
+			transition: transition("g", "P", map[string]uint32{"a": 0, "c": 100}),
+			added:      100,
+			freed:      100,
+		},
+		{
+			name:       "new group",
+			transition: transition("new", "P", map[string]uint32{"a": 50, "b": 50}),
+			added:      100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, freed := Delta(cstate, tt.transition)
+			if added.Cpu != tt.added || freed.Cpu != tt.freed {
+				t.Errorf("Delta = +%d -%d cpu, want +%d -%d", added.Cpu, freed.Cpu, tt.added, tt.freed)
+			}
+			if added.Ram != tt.added<<20 || freed.Ram != tt.freed<<20 {
+				t.Errorf("Delta = +%d -%d ram, want +%d -%d", added.Ram, freed.Ram, tt.added<<20, tt.freed<<20)
+			}
+			if added.Disk != tt.added<<30 || freed.Disk != tt.freed<<30 {
+				t.Errorf("Delta = +%d -%d disk, want +%d -%d", added.Disk, freed.Disk, tt.added<<30, tt.freed<<30)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      placement.Resources
+		transition *clusterapi.GroupTransition
+		planned    uint64
+		err        bool
+	}{
+		{
+			name:       "within limit",
+			limit:      placement.Resources{Cpu: 600},
+			transition: transition("g", "P", map[string]uint32{"a": 100, "b": 100, "c": 100}),
+			planned:    600,
+		},
+		{
+			name:       "over limit",
+			limit:      placement.Resources{Cpu: 600},
+			transition: transition("g", "P", map[string]uint32{"a": 200, "b": 200}),
+			planned:    700,
+			err:        true,
+		},
+		{
+			name:       "shrinking stays within limit",
+			limit:      placement.Resources{Cpu: 600},
+			transition: transition("g", "P", map[string]uint32{"a": 100, "b": 0}),
+			planned:    400,
+		},
+		{
+			name:       "already over, shrinking passes",
+			limit:      placement.Resources{Cpu: 300},
+			transition: transition("g", "P", map[string]uint32{"a": 100, "b": 0}),
+			planned:    400,
+		},
+		{
+			name:       "already over, keeping usage passes",
+			limit:      placement.Resources{Cpu: 300},
+			transition: transition("g", "P", map[string]uint32{"a": 0, "c": 100}),
+			planned:    500,
+		},
+		{
+			name:       "already over, growing fails",
+			limit:      placement.Resources{Cpu: 300},
+			transition: transition("g", "P", map[string]uint32{"a": 150}),
+			planned:    550,
+			err:        true,
+		},
+		{
+			name:       "disk over limit",
+			limit:      placement.Resources{Disk: 600 << 30},
+			transition: transition("g", "P", map[string]uint32{"a": 200, "b": 200}),
+			planned:    700,
+			err:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{limits: map[string]placement.Resources{"P": tt.limit}}
+			check, err := c.Check(cstate, tt.transition)
+			if (err != nil) != tt.err {
+				t.Fatalf("Check error %v, want error %v", err, tt.err)
+			}
+			if check[0].Resource != Cpu || check[0].Used != 500 || check[0].Planned != tt.planned {
+				t.Errorf("cpu line %+v, want used 500 planned %d", check[0], tt.planned)
+			}
+		})
+	}
+
+	c := &Config{limits: map[string]placement.Resources{"P": {Cpu: 600}}}
+	if check, err := c.Check(cstate, transition("g", "Q", map[string]uint32{"a": 10000})); check != nil || err != nil {
+		t.Errorf("project without limits checked: %v, %v", check, err)
+	}
+	if _, err := c.Check(cstate, &clusterapi.GroupTransition{GroupId: "g"}); err == nil {
+		t.Error("transition without owner passed")
+	}
+}
+
+func TestSpecDisk(t *testing.T) {
+	s := fixture.Spec("1", "c")
+	s.Resources.Disk = "2G"
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tr := &clusterapi.GroupTransition{
+		GroupId:     s.GroupId(),
+		Owner:       s.CapiOwner(),
+		Transitions: []*clusterapi.Transition{{HostId: "c", Workloads: []*clusterapi.Workload{s.Workload("c", "1")}}},
+	}
+	if added, _ := Delta(cstate, tr); added.Disk != 2<<30 {
+		t.Errorf("disk of task workload = %d, want %d", added.Disk, 2<<30)
+	}
+
+	s.Resources.Disk = "lots"
+	if err := s.Validate(); err == nil {
+		t.Error("bad disk accepted")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := Load(filepath.Join(dir, "missing.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Limits("P"); ok {
+		t.Error("missing file has limits")
+	}
+
+	path := filepath.Join(dir, "quota.yaml")
+	ioutil.WriteFile(path, []byte("projects:\n  P:\n    cpu: 3200\n    ram: 2G\n    disk: 1T\n"), 0644)
+	if c, err = Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if l, ok := c.Limits("P"); !ok || l != (placement.Resources{Cpu: 3200, Ram: 2 << 30, Disk: 1 << 40}) {
+		t.Errorf("limits of P are %+v", l)
+	}
+
+	ioutil.WriteFile(path, []byte("projects:\n  P:\n    ram: lots\n"), 0644)
+	if _, err = Load(path); err == nil {
+		t.Error("bad ram accepted")
+	}
+}
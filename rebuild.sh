@@ -1,4 +1,4 @@
 #!/bin/sh
-for i in taskInfo destroy sampleApply hostState; do
+for i in taskInfo destroy sampleApply hostState capictl countJobs fakeIpBroker; do
     go install capi_tools/${i}
 done
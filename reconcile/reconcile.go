@@ -0,0 +1,367 @@
+// Package reconcile keeps groups of a directory of task specs in the state
+// the specs describe: missing groups are created, drift is planned away,
+// replicas on DOWN hosts are replaced and groups no file defines any more are
+// destroyed when pruning is on.
+package reconcile
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/placement"
+	"capi_tools/plan"
+	"capi_tools/spec"
+	"capi_tools/watch"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// states of a group
+const (
+	InSync    = "in-sync"
+	Drifted   = "drifted"
+	Applied   = "applied"
+	Failed    = "failed"
+	Invalid   = "invalid"
+	Orphaned  = "orphaned"
+	Destroyed = "destroyed"
+)
+
+type Options struct {
+	// directory with task yaml files
+	Dir string
+	// run git pull in Dir every PullInterval, 0 disables it
+	PullInterval time.Duration
+	// reconcile all groups at least this often even without state changes
+	Interval time.Duration
+	// leave a group alone after applying it so capi state can settle
+	Cooldown time.Duration
+	// destroy groups no file defines any more
+	Prune bool
+	// only report what would be done
+	DryRun bool
+	// json file remembering which groups came from which file
+	StatePath string
+	Strategy  placement.Strategy
+	// called before a spec is planned, e.g. to look up host properties,
+	// must not change anything
+	BeforePlan func(s *spec.Spec) error
+	// called before a plan is made for applying, e.g. to allocate host
+	// properties, never in dry run
+	BeforeApply func(s *spec.Spec) error
+	// called after a plan was applied successfully
+	AfterApply func(p *plan.Plan)
+}
+
+// Status is what the reconciler knows about one group.
+type Status struct {
+	Group     string     `json:"group"`
+	File      string     `json:"file,omitempty"`
+	State     string     `json:"state"`
+	Message   string     `json:"message,omitempty"`
+	Hosts     []string   `json:"hosts,omitempty"`
+	Operation string     `json:"operation,omitempty"`
+	Checked   time.Time  `json:"checked"`
+	Applied   *time.Time `json:"applied,omitempty"`
+}
+
+type Reconciler struct {
+	c    *client.Client
+	m    *watch.Mirror
+	opts Options
+
+	// group -> file it was last loaded from, persisted in StatePath
+	known    map[string]string
+	lastPass time.Time
+	lastPull time.Time
+
+	mu     sync.Mutex
+	status map[string]*Status
+}
+
+func New(c *client.Client, opts Options) (*Reconciler, error) {
+	if opts.Strategy == nil {
+		opts.Strategy = placement.BestFit{}
+	}
+	r := &Reconciler{
+		c:      c,
+		m:      watch.New(c, "", ""),
+		opts:   opts,
+		known:  make(map[string]string),
+		status: make(map[string]*Status),
+	}
+	if opts.StatePath == "" {
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(opts.StatePath)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &r.known); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", opts.StatePath, err)
+	}
+	return r, nil
+}
+
+func (r *Reconciler) saveKnown() {
+	if r.opts.StatePath == "" || r.opts.DryRun {
+		return
+	}
+	data, err := json.MarshalIndent(r.known, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(r.opts.StatePath), 0755); err == nil {
+			err = ioutil.WriteFile(r.opts.StatePath, data, 0644)
+		}
+	}
+	if err != nil {
+		log.Printf("failed to save reconciled groups to %s: %v", r.opts.StatePath, err)
+	}
+}
+
+// Run follows cluster state and reconciles on every change and Interval.
+func (r *Reconciler) Run() {
+	for {
+		if r.opts.PullInterval > 0 && time.Since(r.lastPull) >= r.opts.PullInterval {
+			r.lastPull = time.Now()
+			if out, err := exec.Command("git", "-C", r.opts.Dir, "pull", "--ff-only", "-q").CombinedOutput(); err != nil {
+				log.Printf("git pull in %s failed: %v: %s", r.opts.Dir, err, out)
+			}
+		}
+		changed, err := r.m.Sync()
+		if err != nil {
+			log.Printf("error: %v", err)
+			time.Sleep(r.m.Timeout)
+			continue
+		}
+		if changed || time.Since(r.lastPass) >= r.opts.Interval {
+			r.Pass()
+		}
+	}
+}
+
+// Pass reconciles every group once against the mirrored state.
+func (r *Reconciler) Pass() {
+	r.lastPass = time.Now()
+	cstate := r.m.State()
+	specs, files, errs := LoadDir(r.opts.Dir)
+
+	seen := make(map[string]bool)
+	for path, err := range errs {
+		r.set(&Status{Group: path, File: path, State: Invalid, Message: err.Error()})
+		seen[path] = true
+	}
+	// a group whose file fails to load is not orphaned
+	for g, file := range r.known {
+		if _, ok := errs[file]; ok {
+			seen[g] = true
+		}
+	}
+
+	groups := make([]string, 0, len(specs))
+	for g := range specs {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	// replicas placed for one group are taken for the next ones
+	cluster := placement.NewCluster(cstate)
+	for _, g := range groups {
+		seen[g] = true
+		if r.known[g] != files[g] {
+			r.known[g] = files[g]
+			r.saveKnown()
+		}
+		if r.settling(g) {
+			continue
+		}
+		r.reconcile(specs[g], files[g], cstate, cluster)
+	}
+
+	for g, file := range r.known {
+		if seen[g] {
+			continue
+		}
+		seen[g] = true
+		// with a broken directory a missing group may be a walk error or a
+		// group moved to a file which failed to load, otherwise its file was
+		// deleted or defines another group now
+		if len(errs) > 0 || r.settling(g) {
+			continue
+		}
+		r.orphan(g, file, cstate)
+	}
+
+	r.mu.Lock()
+	for g := range r.status {
+		if !seen[g] {
+			delete(r.status, g)
+		}
+	}
+	r.mu.Unlock()
+}
+
+func (r *Reconciler) reconcile(s *spec.Spec, file string, cstate *clusterapi.ClusterState, cluster *placement.Cluster) {
+	group := s.GroupId()
+	st := &Status{Group: group, File: file, Checked: time.Now()}
+	if prev := r.get(group); prev != nil {
+		st.Applied, st.Operation = prev.Applied, prev.Operation
+	}
+	defer r.set(st)
+
+	hosts, err := Hosts(s, cstate, cluster, r.opts.Strategy)
+	if err != nil {
+		st.State, st.Message = Failed, err.Error()
+		return
+	}
+	st.Hosts = hosts
+	p, err := r.plan(s, hosts, cstate, r.opts.BeforePlan)
+	if err != nil {
+		st.State, st.Message = Failed, err.Error()
+		return
+	}
+	if p.Empty() {
+		st.State = InSync
+		return
+	}
+	if r.opts.DryRun {
+		st.State, st.Message = Drifted, p.Summary()
+		return
+	}
+	if r.opts.BeforeApply != nil {
+		if p, err = r.plan(s, hosts, cstate, r.opts.BeforeApply); err != nil {
+			st.State, st.Message = Failed, err.Error()
+			return
+		}
+	}
+	p.Endpoint = r.c.URL()
+	p.Message = fmt.Sprintf("reconcile group %s with %s", group, filepath.Base(file))
+
+	log.Printf("%s", p.Summary())
+	if err := plan.Apply(r.c, p); err != nil {
+		st.State, st.Message = Failed, err.Error()
+		return
+	}
+	st.State, st.Message = Applied, p.Summary()
+	now := time.Now()
+	st.Applied, st.Operation = &now, p.Transition.GroupOperationId
+	if r.opts.AfterApply != nil {
+		r.opts.AfterApply(p)
+	}
+}
+
+// plan plans the group of s onto hosts after prepare set up the task.
+func (r *Reconciler) plan(s *spec.Spec, hosts []string, cstate *clusterapi.ClusterState, prepare func(*spec.Spec) error) (*plan.Plan, error) {
+	group := s.GroupId()
+	task := *s
+	task.Hosts = hosts
+	task.Scheduler = r.c.SchedulerId()
+	if prepare != nil {
+		if err := prepare(&task); err != nil {
+			return nil, err
+		}
+	}
+
+	wanted := make(map[string]bool)
+	for _, h := range hosts {
+		wanted[h] = true
+	}
+	current := make([]*clusterapi.Host, 0)
+	others := make([]*clusterapi.Host, 0)
+	for _, h := range cstate.Hosts {
+		if h.Metadata == nil {
+			continue
+		}
+		if wls := client.GroupWorkloads(group, []*clusterapi.Host{h}); len(wls) > 0 {
+			current = append(current, &clusterapi.Host{Metadata: h.Metadata, Workloads: wls})
+		} else if wanted[h.Metadata.Id] {
+			others = append(others, h)
+		}
+	}
+	return plan.Make(&task, current, others)
+}
+
+// orphan handles a group no spec file defines any more.
+func (r *Reconciler) orphan(group, file string, cstate *clusterapi.ClusterState) {
+	st := &Status{Group: group, File: file, Checked: time.Now()}
+	defer r.set(st)
+
+	var owner *clusterapi.Owner
+	for _, wl := range client.GroupWorkloads(group, cstate.Hosts) {
+		owner = wl.Owner
+		st.Hosts = append(st.Hosts, client.WorkloadHost(wl))
+	}
+	if owner == nil {
+		st.State, st.Message = Destroyed, "no workloads left"
+		delete(r.known, group)
+		r.saveKnown()
+		return
+	}
+	if !r.opts.Prune || r.opts.DryRun {
+		st.State, st.Message = Orphaned, fmt.Sprintf("%s no longer defines it, destroy is off", file)
+		return
+	}
+
+	operation := client.NewOperationId()
+	resp, err := r.c.Destroy(&clusterapi.DestroyRequest{
+		GroupsToDestroy:    []*clusterapi.DestroyGroupRequest{{GroupId: group, Owner: owner}},
+		SchedulerSignature: r.c.Sign(operation, fmt.Sprintf("destroy group %s, %s no longer defines it", group, file)),
+	})
+	if err == nil {
+		for _, res := range resp.Results {
+			if res.Exception != nil {
+				err = fmt.Errorf("%s", client.DescribeException(res.Exception))
+			}
+		}
+	}
+	if err != nil {
+		st.State, st.Message = Failed, fmt.Sprintf("failed to destroy: %v", err)
+		return
+	}
+	log.Printf("group %s destroyed, %s no longer defines it, operation %s", group, file, operation)
+	now := time.Now()
+	st.State, st.Operation, st.Applied = Destroyed, operation, &now
+}
+
+// settling reports whether group was changed less than Cooldown ago.
+func (r *Reconciler) settling(group string) bool {
+	st := r.get(group)
+	return st != nil && st.Applied != nil && (st.State == Applied || st.State == Destroyed) &&
+		time.Since(*st.Applied) < r.opts.Cooldown
+}
+
+func (r *Reconciler) get(group string) *Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status[group]
+}
+
+func (r *Reconciler) set(st *Status) {
+	if st.Checked.IsZero() {
+		st.Checked = time.Now()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status[st.Group] = st
+}
+
+// Status returns status of all groups sorted by group.
+func (r *Reconciler) Status() []*Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]*Status, 0, len(r.status))
+	for _, st := range r.status {
+		c := *st
+		result = append(result, &c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Group < result[j].Group })
+	return result
+}
@@ -0,0 +1,150 @@
+package reconcile
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"capi_tools/spec"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func groupState(groups ...string) []*clusterapi.Host {
+	wls := make([]*clusterapi.Workload, 0, len(groups))
+	for _, g := range groups {
+		wls = append(wls, fixture.Workload(g, "a"))
+	}
+	return []*clusterapi.Host{fixture.Host("a", wls...)}
+}
+
+func TestPassPrune(t *testing.T) {
+	tests := []struct {
+		name string
+		// file name -> content written to the spec directory
+		files map[string]string
+		// group -> file name it was loaded from before
+		known     map[string]string
+		destroyed []string
+		// group or file name -> state after the pass
+		states map[string]string
+	}{
+		{
+			name:      "deleted file",
+			known:     map[string]string{"g1": "g1.yaml"},
+			destroyed: []string{"g1"},
+			states:    map[string]string{"g1": Destroyed},
+		},
+		{
+			name:   "broken file",
+			files:  map[string]string{"g1.yaml": "owner: [o"},
+			known:  map[string]string{"g1": "g1.yaml"},
+			states: map[string]string{"g1.yaml": Invalid},
+		},
+		{
+			name:   "deleted file next to a broken one",
+			files:  map[string]string{"g2.yaml": "owner: o\n"},
+			known:  map[string]string{"g1": "g1.yaml", "g2": "g2.yaml"},
+			states: map[string]string{"g2.yaml": Invalid},
+		},
+		{
+			name:      "file now defines another group",
+			files:     map[string]string{"g1.yaml": "owner: o\nproject_id: P\ngroup: g1\nresources:\n  ram: 1G\n"},
+			known:     map[string]string{"g0": "g1.yaml"},
+			destroyed: []string{"g0"},
+			states:    map[string]string{"g0": Destroyed},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "reconcile")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range tt.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			groups := make([]string, 0, len(tt.known))
+			for g := range tt.known {
+				groups = append(groups, g)
+			}
+			sort.Strings(groups)
+			capi := fixture.NewCapi(groupState(groups...)...)
+			srv := httptest.NewServer(capi)
+			defer srv.Close()
+			r, err := New(client.New(srv.URL), Options{Dir: dir, Prune: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for g, name := range tt.known {
+				r.known[g] = filepath.Join(dir, name)
+			}
+			r.m.Reset(fixture.State(groupState(groups...)...))
+
+			r.Pass()
+
+			sort.Strings(capi.Destroyed)
+			if len(capi.Destroyed) != len(tt.destroyed) {
+				t.Fatalf("destroyed %v, want %v", capi.Destroyed, tt.destroyed)
+			}
+			for i := range tt.destroyed {
+				if capi.Destroyed[i] != tt.destroyed[i] {
+					t.Fatalf("destroyed %v, want %v", capi.Destroyed, tt.destroyed)
+				}
+			}
+			for group, state := range tt.states {
+				// invalid files are reported by path
+				if filepath.Ext(group) == ".yaml" {
+					group = filepath.Join(dir, group)
+				}
+				st := r.get(group)
+				if st == nil || st.State != state {
+					t.Errorf("status of %s is %+v, want %s", group, st, state)
+				}
+			}
+		})
+	}
+}
+
+func TestPassDryRunChangesNothing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	task := "owner: o\nproject_id: P\ngroup: g1\nhosts: [a]\nresources:\n  ram: 1G\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "g1.yaml"), []byte(task), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	capi := fixture.NewCapi(groupState()...)
+	srv := httptest.NewServer(capi)
+	defer srv.Close()
+	planned, applied := 0, 0
+	r, err := New(client.New(srv.URL), Options{
+		Dir:         dir,
+		DryRun:      true,
+		BeforePlan:  func(*spec.Spec) error { planned++; return nil },
+		BeforeApply: func(*spec.Spec) error { applied++; return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.m.Reset(fixture.State(groupState()...))
+
+	r.Pass()
+
+	if st := r.get("g1"); st == nil || st.State != Drifted {
+		t.Fatalf("status of g1 is %+v, want %s", st, Drifted)
+	}
+	if planned != 1 || applied != 0 {
+		t.Errorf("BeforePlan called %d times, BeforeApply %d times, want 1 and 0", planned, applied)
+	}
+}
@@ -0,0 +1,101 @@
+package reconcile
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/placement"
+	"capi_tools/spec"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LoadDir reads task specs of dir and its subdirectories by group id. Files
+// which fail to load or repeat a group are returned as errors by path.
+func LoadDir(dir string) (map[string]*spec.Spec, map[string]string, map[string]error) {
+	specs := make(map[string]*spec.Spec)
+	files := make(map[string]string)
+	errs := make(map[string]error)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			errs[path] = err
+			return nil
+		}
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") && path != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+		s, err := spec.Load(path)
+		if err != nil {
+			errs[path] = err
+			return nil
+		}
+		if other, ok := files[s.GroupId()]; ok {
+			errs[path] = fmt.Errorf("group %s is already defined in %s", s.GroupId(), other)
+			return nil
+		}
+		specs[s.GroupId()] = s
+		files[s.GroupId()] = path
+		return nil
+	})
+	if err != nil {
+		errs[dir] = err
+	}
+	return specs, files, errs
+}
+
+// Hosts chooses hosts for the group of s: hosts listed in the spec, else
+// replicas running on hosts which are not DOWN, topped up or trimmed to
+// s.Replicas. Without replicas the running hosts are kept as they are.
+// New hosts are reserved on cluster, so groups placed one after another on
+// the same cluster do not pick the same free resources.
+func Hosts(s *spec.Spec, cstate *clusterapi.ClusterState, cluster *placement.Cluster, strategy placement.Strategy) ([]string, error) {
+	if len(s.Hosts) > 0 {
+		return s.Hosts, nil
+	}
+	group := s.GroupId()
+	running := make([]string, 0)
+	keep := make([]string, 0)
+	for _, h := range cstate.Hosts {
+		if h.Metadata == nil || len(client.GroupWorkloads(group, []*clusterapi.Host{h})) == 0 {
+			continue
+		}
+		running = append(running, h.Metadata.Id)
+		if h.Metadata.Health == nil || h.Metadata.Health.State != clusterapi.HostHealthState_DOWN {
+			keep = append(keep, h.Metadata.Id)
+		}
+	}
+	sort.Strings(running)
+	sort.Strings(keep)
+	if s.Replicas == 0 {
+		if len(running) == 0 {
+			return nil, fmt.Errorf("group %s runs nowhere, set hosts or replicas", group)
+		}
+		return running, nil
+	}
+	if len(keep) >= s.Replicas {
+		return keep[:s.Replicas], nil
+	}
+
+	var resources placement.Resources
+	if e := s.Entity(); e.Instance != nil && e.Instance.Container != nil {
+		resources = placement.FromComputing(e.Instance.Container.ComputingResources)
+	}
+	res, err := placement.Place(cluster, &placement.Request{
+		Group:        group,
+		Replicas:     s.Replicas - len(keep),
+		Resources:    resources,
+		AntiAffinity: placement.Host,
+	}, strategy)
+	if err != nil {
+		return nil, err
+	}
+	return append(keep, res.Hosts...), nil
+}
@@ -0,0 +1,145 @@
+package reconcile
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"capi_tools/placement"
+	"capi_tools/spec"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestLoadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"a.yaml":         "owner: o\nproject_id: P\ngroup: ga\n",
+		"sub/b.yml":      "owner: o\nproject_id: P\nservice: svc\n",
+		"sub/dup.yaml":   "owner: o\nproject_id: P\ngroup: ga\n",
+		"bad.yaml":       "owner: o\n",
+		"notes.txt":      "not a task",
+		".git/hook.yaml": "owner: [",
+		"broken/c.yaml":  "owner: [",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	specs, paths, errs := LoadDir(dir)
+	groups := make([]string, 0, len(specs))
+	for g := range specs {
+		groups = append(groups, g)
+		if paths[g] == "" {
+			t.Errorf("group %s has no file", g)
+		}
+	}
+	sort.Strings(groups)
+	if want := []string{"ga", "o_svc"}; !reflect.DeepEqual(groups, want) {
+		t.Errorf("groups %v, want %v", groups, want)
+	}
+	if paths["o_svc"] != filepath.Join(dir, "sub/b.yml") {
+		t.Errorf("o_svc loaded from %s", paths["o_svc"])
+	}
+	failed := make([]string, 0, len(errs))
+	for path := range errs {
+		rel, _ := filepath.Rel(dir, path)
+		failed = append(failed, rel)
+	}
+	sort.Strings(failed)
+	// a.yaml and sub/dup.yaml define the same group, the later one in walk order fails
+	if want := []string{"bad.yaml", "broken/c.yaml", "sub/dup.yaml"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("errors for %v, want %v", failed, want)
+	}
+}
+
+func stateHost(id string, health clusterapi.HostHealthState, groups ...string) *clusterapi.Host {
+	wls := make([]*clusterapi.Workload, 0, len(groups))
+	for _, g := range groups {
+		wls = append(wls, fixture.Workload(g, id))
+	}
+	return fixture.Health(fixture.Host(id, wls...), health)
+}
+
+func TestHosts(t *testing.T) {
+	up, down := clusterapi.HostHealthState_UP, clusterapi.HostHealthState_DOWN
+	cstate := fixture.State(
+		stateHost("a", up, "g"),
+		stateHost("b", down, "g"),
+		stateHost("c", up, "g"),
+		stateHost("d", up),
+		stateHost("e", down),
+	)
+	tests := []struct {
+		name     string
+		hosts    []string
+		replicas int
+		group    string
+		want     []string
+		err      bool
+	}{
+		{name: "spec hosts", hosts: []string{"x", "y"}, want: []string{"x", "y"}},
+		{name: "running hosts kept without replicas", want: []string{"a", "b", "c"}},
+		{name: "replicas trimmed", replicas: 1, want: []string{"a"}},
+		{name: "down host replaced", replicas: 3, want: []string{"a", "c", "d"}},
+		{name: "not enough hosts", replicas: 4, err: true},
+		{name: "new group", group: "new", replicas: 2, want: []string{"a", "c"}},
+		{name: "group runs nowhere", group: "new", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := tt.group
+			if group == "" {
+				group = "g"
+			}
+			s := fixture.Spec("1", tt.hosts...)
+			s.Group, s.Replicas = group, tt.replicas
+			hosts, err := Hosts(s, cstate, placement.NewCluster(cstate), placement.FirstFit{})
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got %v", hosts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(hosts, tt.want) {
+				t.Errorf("Hosts = %v, want %v", hosts, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostsSharedCluster(t *testing.T) {
+	up := clusterapi.HostHealthState_UP
+	cstate := fixture.State(stateHost("a", up), stateHost("b", up))
+	cluster := placement.NewCluster(cstate)
+
+	// a fits one replica only, the second group has to go to b
+	first, second := fixture.Spec("1"), fixture.Spec("1")
+	first.Group, second.Group = "g1", "g2"
+	first.Replicas, second.Replicas = 1, 1
+	first.Resources.Cpu, second.Resources.Cpu = 600, 500
+	hosts := make([]string, 0, 2)
+	for _, s := range []*spec.Spec{first, second} {
+		h, err := Hosts(s, cstate, cluster, placement.FirstFit{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts = append(hosts, h...)
+	}
+	if !reflect.DeepEqual(hosts, []string{"a", "b"}) {
+		t.Errorf("groups placed on %v, want [a b]", hosts)
+	}
+}
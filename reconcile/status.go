@@ -0,0 +1,28 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Handler serves status of all groups on /status and of one group on
+// /status/<group> as json.
+func Handler(r *Reconciler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		group := strings.Trim(strings.TrimPrefix(req.URL.Path, "/status"), "/")
+		var body interface{} = r.Status()
+		if group != "" {
+			st := r.get(group)
+			if st == nil {
+				http.Error(w, "unknown group "+group, http.StatusNotFound)
+				return
+			}
+			body = st
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(body)
+	})
+}
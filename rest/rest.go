@@ -0,0 +1,230 @@
+// Package rest converts cluster state in the REST v0 json format served at
+// /rest/v0/state/<version> to and from clusterapi.ClusterState.
+//
+// The REST format carries less than the proto one: hosts with their health
+// and resources, and entities with scheduler and computing requirements.
+// Everything else is lost converting proto state to REST.
+package rest
+
+import (
+	"capi_tools/clusterapi"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// resource names used as keys of computing resources
+const (
+	RAM      = "ru.yandex.schedulers.cluster.api.computing.RAM"
+	CPUPower = "ru.yandex.schedulers.cluster.api.computing.CPUPower"
+	HDDSpace = "ru.yandex.schedulers.cluster.api.computing.HDDSpace"
+)
+
+type State struct {
+	Hosts map[string]*Host `json:"hosts"`
+}
+
+type Host struct {
+	ComputingResources Resources `json:"computingResources"`
+	HostHealth         Health    `json:"hostHealth"`
+	Entities           []*Entity `json:"entities"`
+}
+
+type Health struct {
+	State string `json:"state,omitempty"`
+}
+
+type Entity struct {
+	SchedulerId           SchedulerId `json:"schedulerId"`
+	ComputingRequirements Resources   `json:"computingRequirements"`
+}
+
+type SchedulerId struct {
+	Name string `json:"name,omitempty"`
+}
+
+type Resources struct {
+	Resources map[string]*Resource `json:"resources"`
+}
+
+// Resource is one computing resource, RAM and HDDSpace use Capacity in bytes,
+// CPUPower uses PowerPercents of a core.
+type Resource struct {
+	Capacity      uint64 `json:"capacity,omitempty"`
+	PowerPercents uint32 `json:"powerPercents,omitempty"`
+}
+
+// IsREST reports whether data looks like REST state: hosts is an object
+// keyed by fqdn rather than a list.
+func IsREST(data []byte) bool {
+	var probe struct {
+		Hosts json.RawMessage `json:"hosts"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimSpace(string(probe.Hosts)), "{")
+}
+
+// Decode parses REST json into cluster state, hosts sorted by fqdn.
+func Decode(data []byte) (*clusterapi.ClusterState, error) {
+	s := &State{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse rest state: %v", err)
+	}
+	return s.ClusterState()
+}
+
+// ClusterState converts REST state to proto one.
+func (s *State) ClusterState() (*clusterapi.ClusterState, error) {
+	ids := make([]string, 0, len(s.Hosts))
+	for id := range s.Hosts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	cstate := &clusterapi.ClusterState{Hosts: make([]*clusterapi.Host, 0, len(ids))}
+	for _, id := range ids {
+		h := s.Hosts[id]
+		md := &clusterapi.HostMetadata{
+			Id:                 id,
+			ComputingResources: h.ComputingResources.computing(),
+		}
+		if h.HostHealth.State != "" {
+			// a state newer than our proto leaves health unknown, like a
+			// host reporting none
+			if state, ok := clusterapi.HostHealthState_value[h.HostHealth.State]; ok {
+				md.Health = &clusterapi.HostHealth{State: clusterapi.HostHealthState(state)}
+			} else {
+				log.Printf("rest: host %s has unknown health state %s, health left unknown", id, h.HostHealth.State)
+			}
+		}
+
+		host := &clusterapi.Host{Metadata: md, Workloads: make([]*clusterapi.Workload, 0, len(h.Entities))}
+		for _, e := range h.Entities {
+			host.Workloads = append(host.Workloads, &clusterapi.Workload{
+				SchedulerId: e.SchedulerId.Name,
+				Id: &clusterapi.WorkloadId{
+					Slot: &clusterapi.Slot{Host: id},
+				},
+				Entity: &clusterapi.Entity{
+					Instance: &clusterapi.Instance{
+						Container: &clusterapi.Container{ComputingResources: e.ComputingRequirements.computing()},
+					},
+				},
+			})
+		}
+		cstate.Hosts = append(cstate.Hosts, host)
+	}
+	return cstate, nil
+}
+
+// Encode converts cluster state to REST json.
+func Encode(cstate *clusterapi.ClusterState) ([]byte, error) {
+	return json.MarshalIndent(FromClusterState(cstate), "", "    ")
+}
+
+// FromClusterState converts proto state to REST one.
+func FromClusterState(cstate *clusterapi.ClusterState) *State {
+	s := &State{Hosts: make(map[string]*Host)}
+	for _, h := range cstate.Hosts {
+		md := h.Metadata
+		if md == nil {
+			continue
+		}
+		host := &Host{
+			ComputingResources: fromComputing(md.ComputingResources),
+			Entities:           make([]*Entity, 0, len(h.Workloads)),
+		}
+		if md.Health != nil {
+			host.HostHealth.State = md.Health.State.String()
+		}
+		for _, wl := range h.Workloads {
+			var res *clusterapi.ComputingResources
+			switch {
+			case wl.Entity == nil:
+			case wl.Entity.Instance != nil && wl.Entity.Instance.Container != nil:
+				res = wl.Entity.Instance.Container.ComputingResources
+			case wl.Entity.Job != nil && wl.Entity.Job.Container != nil:
+				res = wl.Entity.Job.Container.ComputingResources
+			}
+			host.Entities = append(host.Entities, &Entity{
+				SchedulerId:           SchedulerId{wl.SchedulerId},
+				ComputingRequirements: fromComputing(res),
+			})
+		}
+		s.Hosts[md.Id] = host
+	}
+	return s
+}
+
+func (r Resources) computing() *clusterapi.ComputingResources {
+	c := &clusterapi.ComputingResources{}
+	if ram := r.Resources[RAM]; ram != nil {
+		c.RamBytes = ram.Capacity
+	}
+	if cpu := r.Resources[CPUPower]; cpu != nil {
+		c.CpuPowerPercentsCore = cpu.PowerPercents
+	}
+	if hdd := r.Resources[HDDSpace]; hdd != nil {
+		c.HddSpaceBytes = hdd.Capacity
+	}
+	return c
+}
+
+func fromComputing(c *clusterapi.ComputingResources) Resources {
+	r := Resources{Resources: make(map[string]*Resource)}
+	if c == nil {
+		return r
+	}
+	r.Resources[RAM] = &Resource{Capacity: c.RamBytes}
+	r.Resources[CPUPower] = &Resource{PowerPercents: c.CpuPowerPercentsCore}
+	r.Resources[HDDSpace] = &Resource{Capacity: c.HddSpaceBytes}
+	return r
+}
+
+// Load reads cluster state from a file in either REST or proto json format.
+func Load(path string) (*clusterapi.ClusterState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes REST or proto json state.
+func Parse(data []byte) (*clusterapi.ClusterState, error) {
+	if IsREST(data) {
+		return Decode(data)
+	}
+	cstate := &clusterapi.ClusterState{}
+	if err := json.Unmarshal(data, cstate); err != nil {
+		return nil, fmt.Errorf("failed to parse state: %v", err)
+	}
+	return cstate, nil
+}
+
+// Get fetches state from a REST endpoint like
+// http://capi-sas.yandex-team.ru:29100/rest/v0/state/0 through rt, e.g. one
+// adding credentials, http.DefaultTransport if nil.
+func Get(url string, rt http.RoundTripper) (*clusterapi.ClusterState, error) {
+	c := &http.Client{Transport: rt, Timeout: 5 * time.Minute}
+	resp, err := c.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
@@ -0,0 +1,105 @@
+package rest
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const restState = `{
+    "hosts": {
+        "a": {
+            "computingResources": {"resources": {
+                "ru.yandex.schedulers.cluster.api.computing.RAM": {"capacity": 1073741824},
+                "ru.yandex.schedulers.cluster.api.computing.CPUPower": {"powerPercents": 1000},
+                "ru.yandex.schedulers.cluster.api.computing.HDDSpace": {"capacity": 5000}
+            }},
+            "hostHealth": {"state": "UP"},
+            "entities": [{
+                "schedulerId": {"name": "capictl"},
+                "computingRequirements": {"resources": {
+                    "ru.yandex.schedulers.cluster.api.computing.RAM": {"capacity": 100},
+                    "ru.yandex.schedulers.cluster.api.computing.CPUPower": {"powerPercents": 50},
+                    "ru.yandex.schedulers.cluster.api.computing.HDDSpace": {}
+                }}
+            }]
+        },
+        "b": {
+            "computingResources": {"resources": {}},
+            "hostHealth": {"state": "DECOMMISSIONED"},
+            "entities": []
+        }
+    }
+}`
+
+// TestConvertRoundTrip converts rest state to proto json and back the way
+// capictl convert does.
+func TestConvertRoundTrip(t *testing.T) {
+	if !IsREST([]byte(restState)) {
+		t.Fatal("rest state not recognized")
+	}
+	cstate, err := Parse([]byte(restState))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := cstate.Hosts[0].Metadata.Health; h == nil || h.State != clusterapi.HostHealthState_UP {
+		t.Errorf("health of a %v, want UP", h)
+	}
+	if h := cstate.Hosts[1].Metadata.Health; h != nil {
+		t.Errorf("unknown health of b decoded as %v", h)
+	}
+
+	protoJSON, err := json.Marshal(cstate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsREST(protoJSON) {
+		t.Fatal("proto state recognized as rest")
+	}
+	again, err := Parse(protoJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restJSON, err := Encode(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got, want State
+	if err := json.Unmarshal(restJSON, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(restState), &want); err != nil {
+		t.Fatal(err)
+	}
+	// unknown health is lost, resources are always written out
+	want.Hosts["b"].HostHealth.State = ""
+	want.Hosts["b"].ComputingResources = fromComputing(&clusterapi.ComputingResources{})
+	want.Hosts["a"].Entities[0].ComputingRequirements.Resources[HDDSpace] = &Resource{}
+	if !reflect.DeepEqual(got, want) {
+		gj, _ := json.Marshal(got)
+		wj, _ := json.Marshal(want)
+		t.Errorf("round trip gave\n%s\nwant\n%s", gj, wj)
+	}
+}
+
+func TestFromClusterState(t *testing.T) {
+	wl := fixture.Workload("g", "a", fixture.Resources(50, 100))
+	wl.SchedulerId = "capictl"
+	cstate := fixture.State(fixture.Health(fixture.Host("a", wl), clusterapi.HostHealthState_MAINTENANCE))
+
+	back, err := FromClusterState(cstate).ClusterState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := back.Hosts[0].Metadata
+	if md.Id != "a" || md.Health.State != clusterapi.HostHealthState_MAINTENANCE || md.ComputingResources.CpuPowerPercentsCore != 1000 {
+		t.Errorf("host came back as %v", md)
+	}
+	got := back.Hosts[0].Workloads[0]
+	if got.SchedulerId != "capictl" || got.Entity.Instance.Container.ComputingResources.RamBytes != 100 {
+		t.Errorf("workload came back as %v", got)
+	}
+}
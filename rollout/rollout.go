@@ -0,0 +1,334 @@
+// Package rollout applies a plan to a group in batches of hosts, waiting for
+// every batch to become ACTIVE before touching the next one.
+package rollout
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/plan"
+	"capi_tools/prompt"
+	"capi_tools/wait"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// what to do when a batch fails
+const (
+	Abort    = "abort"
+	Pause    = "pause"
+	Rollback = "rollback"
+)
+
+type Options struct {
+	// hosts updated at once
+	MaxUnavailable int
+	// new hosts brought up at once before existing ones are touched,
+	// 0 means new hosts are added together with updated ones
+	MaxSurge int
+	// how long to wait for a batch to become ACTIVE
+	Timeout time.Duration
+	// one of Abort, Pause, Rollback
+	OnFailure string
+}
+
+type Rollout struct {
+	c    *client.Client
+	p    *plan.Plan
+	opts Options
+	// group workloads per host before rollout started
+	previous map[string][]*clusterapi.Workload
+	// target workloads per host
+	target map[string][]*clusterapi.Workload
+	done   map[string]bool
+	// host etags after the last transition this rollout applied, a host
+	// changed by anybody else since fails the next batch
+	etags map[string]int64
+}
+
+// errStale is returned by a batch when capi refuses it because planned
+// hosts changed behind the rollout.
+var errStale = errors.New("hosts changed since the previous batch")
+
+// Batches splits plan changes into steps: surge of new hosts first, then
+// updates and new hosts left, removals last. Unchanged hosts are skipped.
+func Batches(p *plan.Plan, opts Options) [][]*plan.HostChange {
+	adds := make([]*plan.HostChange, 0)
+	updates := make([]*plan.HostChange, 0)
+	removes := make([]*plan.HostChange, 0)
+	for _, c := range p.Changes {
+		switch c.Action {
+		case plan.Add:
+			if opts.MaxSurge > 0 {
+				adds = append(adds, c)
+			} else {
+				updates = append(updates, c)
+			}
+		case plan.Modify:
+			updates = append(updates, c)
+		case plan.Remove:
+			removes = append(removes, c)
+		}
+	}
+
+	unavailable := opts.MaxUnavailable
+	if unavailable < 1 {
+		unavailable = 1
+	}
+	batches := split(adds, opts.MaxSurge)
+	batches = append(batches, split(updates, unavailable)...)
+	return append(batches, split(removes, unavailable)...)
+}
+
+func split(changes []*plan.HostChange, size int) [][]*plan.HostChange {
+	result := make([][]*plan.HostChange, 0)
+	for len(changes) > 0 {
+		n := size
+		if n > len(changes) {
+			n = len(changes)
+		}
+		result = append(result, changes[:n])
+		changes = changes[n:]
+	}
+	return result
+}
+
+func New(c *client.Client, p *plan.Plan, opts Options) *Rollout {
+	target := make(map[string][]*clusterapi.Workload)
+	for _, t := range p.Transition.Transitions {
+		target[t.HostId] = t.Workloads
+	}
+	return &Rollout{
+		c:      c,
+		p:      p,
+		opts:   opts,
+		target: target,
+		done:   make(map[string]bool),
+	}
+}
+
+// Run rolls the plan out batch by batch.
+func (r *Rollout) Run() error {
+	hosts, err := r.c.HostsState(r.p.Hosts())
+	if err != nil {
+		return fmt.Errorf("failed to get state of planned hosts: %v", err)
+	}
+	if err := r.p.Check(hosts); err != nil {
+		return err
+	}
+	if r.p.Transition.GroupOperationId == "" {
+		r.p.Transition.GroupOperationId = client.NewOperationId()
+	}
+	r.previous = make(map[string][]*clusterapi.Workload)
+	r.etags = make(map[string]int64)
+	for _, h := range hosts {
+		r.etags[h.Metadata.Id] = h.Metadata.Etag
+		if wls := client.GroupWorkloads(r.p.GroupId, []*clusterapi.Host{h}); len(wls) > 0 {
+			r.previous[h.Metadata.Id] = wls
+		}
+	}
+
+	batches := Batches(r.p, r.opts)
+	for i, batch := range batches {
+		names := make([]string, 0, len(batch))
+		for _, c := range batch {
+			names = append(names, fmt.Sprintf("%s%s", sign(c.Action), c.Host))
+		}
+		log.Printf("group %s: batch %d/%d [%s]", r.p.GroupId, i+1, len(batches), strings.Join(names, " "))
+
+		err := r.step(batch, fmt.Sprintf("rolling update batch %d/%d of group %s", i+1, len(batches), r.p.GroupId))
+		if err == nil {
+			err = r.wait(batch)
+		}
+		if err == nil {
+			continue
+		}
+
+		log.Printf("group %s: batch %d/%d failed: %v", r.p.GroupId, i+1, len(batches), err)
+		switch r.opts.OnFailure {
+		case Pause:
+			if err == errStale && prompt.Confirm(fmt.Sprintf("batch %d/%d: %v, continue over those changes?", i+1, len(batches), err)) {
+				if rerr := r.refresh(); rerr != nil {
+					return rerr
+				}
+				continue
+			}
+			if err != errStale && prompt.Confirm(fmt.Sprintf("batch %d/%d failed, continue rollout?", i+1, len(batches))) {
+				continue
+			}
+			return fmt.Errorf("rollout of group %s stopped at batch %d/%d: %v", r.p.GroupId, i+1, len(batches), err)
+		case Rollback:
+			if err == errStale {
+				return fmt.Errorf("batch %d/%d of group %s: %v, not rolling back over them", i+1, len(batches), r.p.GroupId, err)
+			}
+			if rerr := r.Rollback(); rerr != nil {
+				return fmt.Errorf("batch %d/%d failed: %v, rollback failed too: %v", i+1, len(batches), err, rerr)
+			}
+			return fmt.Errorf("batch %d/%d failed: %v, group %s rolled back to generation %s",
+				i+1, len(batches), err, r.p.GroupId, r.p.FromGeneration)
+		default:
+			return fmt.Errorf("rollout of group %s aborted at batch %d/%d: %v", r.p.GroupId, i+1, len(batches), err)
+		}
+	}
+	return nil
+}
+
+// step applies group state with hosts of batch and all previous batches at target.
+func (r *Rollout) step(batch []*plan.HostChange, message string) error {
+	for _, c := range batch {
+		r.done[c.Host] = true
+	}
+	wanted := make(map[string][]*clusterapi.Workload)
+	for _, host := range r.p.Hosts() {
+		if r.done[host] {
+			wanted[host] = r.target[host]
+		} else if wls, ok := r.previous[host]; ok {
+			wanted[host] = wls
+		}
+	}
+	return r.apply(wanted, message)
+}
+
+// Rollback returns every host of the group to workloads it had before rollout.
+func (r *Rollout) Rollback() error {
+	log.Printf("group %s: rolling back to generation %s", r.p.GroupId, r.p.FromGeneration)
+	wanted := make(map[string][]*clusterapi.Workload)
+	for _, host := range r.p.Hosts() {
+		wanted[host] = r.previous[host]
+	}
+	return r.apply(wanted, fmt.Sprintf("rollback of group %s to generation %s", r.p.GroupId, r.p.FromGeneration))
+}
+
+// apply sends wanted workloads per host with etags the hosts had after the
+// previous transition of this rollout, then remembers etags the hosts got.
+func (r *Rollout) apply(wanted map[string][]*clusterapi.Workload, message string) error {
+	hosts := make([]string, 0, len(wanted))
+	for h := range wanted {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+
+	group := &clusterapi.GroupTransition{
+		GroupId:          r.p.GroupId,
+		Owner:            r.p.Transition.Owner,
+		GroupOperationId: r.p.Transition.GroupOperationId,
+		Transitions:      make([]*clusterapi.Transition, 0, len(hosts)),
+	}
+	for _, host := range hosts {
+		wls := make([]*clusterapi.Workload, 0)
+		for _, wl := range wanted[host] {
+			wl = proto.Clone(wl).(*clusterapi.Workload)
+			wl.Feedback = nil
+			wls = append(wls, wl)
+		}
+		group.Transitions = append(group.Transitions, &clusterapi.Transition{
+			HostId:        host,
+			HostStateEtag: r.etags[host],
+			Workloads:     wls,
+		})
+	}
+
+	resp, err := r.c.Apply(&clusterapi.ApplyGroupTransitionRequest{
+		GroupTransitions:   []*clusterapi.GroupTransition{group},
+		SchedulerSignature: r.c.Sign(group.GroupOperationId, message),
+	})
+	if err != nil {
+		return err
+	}
+	for _, res := range resp.Results {
+		if res.Exception.GetTransitionValidationException().GetEtagFailureException() != nil {
+			return errStale
+		}
+	}
+	if err := client.ApplyError(resp); err != nil {
+		return err
+	}
+	return r.remember(hosts, wanted)
+}
+
+// remember takes etags of hosts still running the workloads just applied,
+// a host already running something else was changed by somebody else.
+func (r *Rollout) remember(hosts []string, wanted map[string][]*clusterapi.Workload) error {
+	cstate, err := r.c.HostsState(hosts)
+	if err != nil {
+		return fmt.Errorf("failed to get host etags after transition: %v", err)
+	}
+	for _, h := range cstate {
+		wls := client.GroupWorkloads(r.p.GroupId, []*clusterapi.Host{h})
+		if !sameWorkloads(wls, wanted[h.Metadata.Id]) {
+			return errStale
+		}
+		r.etags[h.Metadata.Id] = h.Metadata.Etag
+	}
+	return nil
+}
+
+// refresh takes current etags of planned hosts, accepting changes made by
+// somebody else.
+func (r *Rollout) refresh() error {
+	cstate, err := r.c.HostsState(r.p.Hosts())
+	if err != nil {
+		return fmt.Errorf("failed to refresh host etags: %v", err)
+	}
+	for _, h := range cstate {
+		r.etags[h.Metadata.Id] = h.Metadata.Etag
+	}
+	return nil
+}
+
+// sameWorkloads tells whether a and b hold the same generations and target
+// states, feedback and order aside.
+func sameWorkloads(a, b []*clusterapi.Workload) bool {
+	count := make(map[string]int)
+	for _, wl := range a {
+		count[wl.Generation+" "+wl.TargetState]++
+	}
+	for _, wl := range b {
+		count[wl.Generation+" "+wl.TargetState]--
+	}
+	for _, n := range count {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// wait follows group until all hosts of batch reach target.
+func (r *Rollout) wait(batch []*plan.HostChange) error {
+	hosts := make([]string, 0, len(batch))
+	removed := make([]string, 0)
+	for _, c := range batch {
+		if c.Action == plan.Remove {
+			removed = append(removed, c.Host)
+		} else {
+			hosts = append(hosts, c.Host)
+		}
+	}
+	if len(hosts) == 0 {
+		hosts = removed
+	}
+	return wait.Group(r.c, r.p.GroupId, wait.Options{
+		Generation: r.p.Generation,
+		Hosts:      hosts,
+		Removed:    removed,
+		Timeout:    r.opts.Timeout,
+		Out:        os.Stderr,
+	})
+}
+
+func sign(a plan.Action) string {
+	switch a {
+	case plan.Add:
+		return "+"
+	case plan.Remove:
+		return "-"
+	}
+	return "~"
+}
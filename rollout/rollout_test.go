@@ -0,0 +1,202 @@
+package rollout
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"capi_tools/plan"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func changes(actions ...string) *plan.Plan {
+	p := &plan.Plan{GroupId: "g"}
+	kinds := map[byte]plan.Action{'+': plan.Add, '~': plan.Modify, '-': plan.Remove, '=': plan.Keep}
+	for _, a := range actions {
+		p.Changes = append(p.Changes, &plan.HostChange{Host: a[1:], Action: kinds[a[0]]})
+	}
+	return p
+}
+
+func names(batches [][]*plan.HostChange) [][]string {
+	result := make([][]string, 0, len(batches))
+	for _, b := range batches {
+		hosts := make([]string, 0, len(b))
+		for _, c := range b {
+			hosts = append(hosts, c.Host)
+		}
+		result = append(result, hosts)
+	}
+	return result
+}
+
+func TestBatches(t *testing.T) {
+	tests := []struct {
+		name string
+		plan *plan.Plan
+		opts Options
+		want [][]string
+	}{
+		{
+			name: "one host at a time by default",
+			plan: changes("~a", "~b", "=c"),
+			want: [][]string{{"a"}, {"b"}},
+		},
+		{
+			name: "max unavailable",
+			plan: changes("~a", "~b", "~c", "~d", "~e"),
+			opts: Options{MaxUnavailable: 2},
+			want: [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+		{
+			name: "adds go with updates without surge",
+			plan: changes("-a", "~b", "+c"),
+			opts: Options{MaxUnavailable: 2},
+			want: [][]string{{"b", "c"}, {"a"}},
+		},
+		{
+			name: "surge first, removals last",
+			plan: changes("-a", "~b", "+c", "+d", "+e"),
+			opts: Options{MaxSurge: 2},
+			want: [][]string{{"c", "d"}, {"e"}, {"b"}, {"a"}},
+		},
+		{
+			name: "nothing to do",
+			plan: changes("=a"),
+			want: [][]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := names(Batches(tt.plan, tt.opts)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Batches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// generations returns generations of group workloads per host of capi.
+func generations(capi *fixture.Capi, group string) map[string][]string {
+	result := make(map[string][]string)
+	for _, h := range capi.Hosts {
+		gens := make([]string, 0)
+		for _, wl := range client.GroupWorkloads(group, []*clusterapi.Host{h}) {
+			gens = append(gens, wl.Generation)
+		}
+		result[h.Metadata.Id] = gens
+	}
+	return result
+}
+
+func TestRun(t *testing.T) {
+	v1 := fixture.Spec("1")
+	capi := fixture.NewCapi(append(fixture.Running(v1, "1", "a", "b"), fixture.Host("c"))...)
+	srv := httptest.NewServer(capi)
+	defer srv.Close()
+
+	c := client.New(srv.URL)
+	current, err := c.GroupState(v1.GroupId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	others, err := c.HostsState([]string{"c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := plan.Make(fixture.Spec("2", "b", "c"), current, others)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := New(c, p, Options{Timeout: 5 * time.Second}).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(capi.Applied) != 3 {
+		t.Errorf("%d transitions applied, want one per batch", len(capi.Applied))
+	}
+	want := map[string][]string{"a": {}, "b": {"2"}, "c": {"2"}}
+	if got := generations(capi, p.GroupId); !reflect.DeepEqual(got, want) {
+		t.Errorf("rolled out to %v, want %v", got, want)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	v1, v2 := fixture.Spec("1"), fixture.Spec("2")
+	capi := fixture.NewCapi(append(fixture.Running(v1, "1", "a", "b"), fixture.Host("c"))...)
+	srv := httptest.NewServer(capi)
+	defer srv.Close()
+
+	p := changes("~a", "-b", "+c")
+	p.GroupId = v1.GroupId()
+	p.FromGeneration, p.Generation = "1", "2"
+	p.Transition = &clusterapi.GroupTransition{
+		GroupId: p.GroupId,
+		Transitions: []*clusterapi.Transition{
+			{HostId: "a", Workloads: []*clusterapi.Workload{v2.Workload("a", "2")}},
+			{HostId: "b", Workloads: []*clusterapi.Workload{}},
+			{HostId: "c", Workloads: []*clusterapi.Workload{v2.Workload("c", "2")}},
+		},
+	}
+	r := New(client.New(srv.URL), p, Options{})
+	r.previous = map[string][]*clusterapi.Workload{
+		"a": {v1.Workload("a", "1")},
+		"b": {v1.Workload("b", "1")},
+	}
+	r.etags = map[string]int64{"a": 1, "b": 2, "c": 1}
+
+	if err := r.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if len(capi.Applied) != 1 {
+		t.Fatalf("%d transitions applied, want 1", len(capi.Applied))
+	}
+	want := map[string][]string{"a": {"1"}, "b": {"1"}, "c": {}}
+	if got := generations(capi, p.GroupId); !reflect.DeepEqual(got, want) {
+		t.Errorf("rolled back to %v, want %v", got, want)
+	}
+}
+
+func TestConcurrentChange(t *testing.T) {
+	v1 := fixture.Spec("1")
+	capi := fixture.NewCapi(fixture.Running(v1, "1", "a", "b", "c")...)
+	srv := httptest.NewServer(capi)
+	defer srv.Close()
+
+	c := client.New(srv.URL)
+	current, err := c.GroupState(v1.GroupId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := plan.Make(fixture.Spec("2", "a", "b", "c"), current, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(c, p, Options{})
+	r.previous = make(map[string][]*clusterapi.Workload)
+	r.etags = make(map[string]int64)
+	for _, h := range current {
+		r.previous[h.Metadata.Id] = h.Workloads
+		r.etags[h.Metadata.Id] = h.Metadata.Etag
+	}
+
+	batches := Batches(p, r.opts)
+	if err := r.step(batches[0], "first batch"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.step(batches[1], "second batch"); err != nil {
+		t.Fatalf("second batch after the rollout's own change: %v", err)
+	}
+	capi.Touch("c")
+	if err := r.step(batches[2], "third batch"); err != errStale {
+		t.Errorf("third batch after somebody changed c = %v, want %v", err, errStale)
+	}
+	if err := r.Rollback(); err != errStale {
+		t.Errorf("rollback after somebody changed c = %v, want %v", err, errStale)
+	}
+	want := map[string][]string{"a": {"2"}, "b": {"2"}, "c": {"1"}}
+	if got := generations(capi, p.GroupId); !reflect.DeepEqual(got, want) {
+		t.Errorf("group left at %v, want %v", got, want)
+	}
+}
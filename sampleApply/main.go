@@ -3,6 +3,7 @@ package main
 import (
 	"capi/sched"
 	"capi/task"
+	"capi_tools/credentials"
 	"flag"
 	"io/ioutil"
 	"log"
@@ -18,6 +19,7 @@ var taskF = flag.String("task", "", "path to task.yaml")
 //var capi_url string "http://iss00-prestable.search.yandex.net:8082/proto/v0/state/full"
 
 func main() {
+	log.SetOutput(credentials.RedactWriter(os.Stderr))
 	flag.Parse()
 	if *taskF == "" {
 		flag.PrintDefaults()
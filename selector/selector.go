@@ -0,0 +1,183 @@
+// Package selector picks groups from cluster state by group id glob, owner,
+// project and workload properties, e.g. to destroy everything left after
+// an experiment with one request.
+package selector
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Selector matches workloads, zero fields match everything. Filter is a
+// capi workload filter expression applied by capi when state is fetched.
+type Selector struct {
+	Group   string
+	Owner   string
+	Project string
+	// property name -> value glob
+	Properties map[string]string
+	Filter     string
+}
+
+// ParseProperty parses "name=glob" into s.Properties.
+func (s *Selector) ParseProperty(v string) error {
+	i := strings.Index(v, "=")
+	if i <= 0 {
+		return fmt.Errorf("bad property %q, want name=value", v)
+	}
+	if _, err := path.Match(v[i+1:], ""); err != nil {
+		return fmt.Errorf("bad property pattern %q: %v", v, err)
+	}
+	if s.Properties == nil {
+		s.Properties = make(map[string]string)
+	}
+	s.Properties[v[:i]] = v[i+1:]
+	return nil
+}
+
+// Empty reports whether s has no conditions and so matches every group.
+func (s *Selector) Empty() bool {
+	return s.Group == "" && s.Owner == "" && s.Project == "" && len(s.Properties) == 0 && s.Filter == ""
+}
+
+// String describes conditions of s like "group=exp-* project=P".
+func (s *Selector) String() string {
+	conds := make([]string, 0)
+	if s.Group != "" {
+		conds = append(conds, "group="+s.Group)
+	}
+	if s.Owner != "" {
+		conds = append(conds, "owner="+s.Owner)
+	}
+	if s.Project != "" {
+		conds = append(conds, "project="+s.Project)
+	}
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		conds = append(conds, "property "+name+"="+s.Properties[name])
+	}
+	if s.Filter != "" {
+		conds = append(conds, "filter "+s.Filter)
+	}
+	return strings.Join(conds, " ")
+}
+
+// Validate checks group pattern.
+func (s *Selector) Validate() error {
+	if _, err := path.Match(s.Group, ""); err != nil {
+		return fmt.Errorf("bad group pattern %q: %v", s.Group, err)
+	}
+	return nil
+}
+
+// Match reports whether wl passes all local conditions of s.
+func (s *Selector) Match(wl *clusterapi.Workload) bool {
+	group := client.WorkloadGroup(wl)
+	if group == "" {
+		return false
+	}
+	if s.Group != "" {
+		if ok, _ := path.Match(s.Group, group); !ok {
+			return false
+		}
+	}
+	if s.Owner != "" && (wl.Owner == nil || wl.Owner.OwnerId != s.Owner) {
+		return false
+	}
+	if s.Project != "" && (wl.Owner == nil || wl.Owner.ProjectId != s.Project) {
+		return false
+	}
+	for name, pattern := range s.Properties {
+		v, ok := wl.Properties[name]
+		if !ok {
+			return false
+		}
+		if ok, _ := path.Match(pattern, v); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Group is a group with at least one workload matched by a selector, Hosts
+// and Workloads count all its workloads, matched or not.
+type Group struct {
+	GroupId   string            `json:"groupId"`
+	Owner     *clusterapi.Owner `json:"owner"`
+	Hosts     []string          `json:"hosts"`
+	Workloads int               `json:"workloads"`
+}
+
+// Select returns groups of cstate matched by s sorted by id.
+func Select(cstate *clusterapi.ClusterState, s *Selector) []*Group {
+	groups := make(map[string]*Group)
+	for _, h := range cstate.Hosts {
+		for _, wl := range h.Workloads {
+			if !s.Match(wl) {
+				continue
+			}
+			id := client.WorkloadGroup(wl)
+			if groups[id] == nil {
+				groups[id] = &Group{GroupId: id, Owner: wl.Owner}
+			}
+		}
+	}
+	result := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].GroupId < result[j].GroupId })
+	Complete(cstate, result)
+	return result
+}
+
+// Complete sets hosts and workload counts of groups from every workload of
+// theirs in cstate, it must not be filtered by workload then.
+func Complete(cstate *clusterapi.ClusterState, groups []*Group) {
+	byId := make(map[string]*Group, len(groups))
+	for _, g := range groups {
+		g.Hosts = make([]string, 0)
+		g.Workloads = 0
+		byId[g.GroupId] = g
+	}
+	for _, h := range cstate.Hosts {
+		onHost := make(map[string]bool)
+		for _, wl := range h.Workloads {
+			g := byId[client.WorkloadGroup(wl)]
+			if g == nil {
+				continue
+			}
+			if g.Owner == nil {
+				g.Owner = wl.Owner
+			}
+			g.Workloads++
+			if h.Metadata != nil && !onHost[g.GroupId] {
+				onHost[g.GroupId] = true
+				g.Hosts = append(g.Hosts, h.Metadata.Id)
+			}
+		}
+	}
+	for _, g := range groups {
+		sort.Strings(g.Hosts)
+	}
+}
+
+// DestroyRequest builds one request removing all groups.
+func DestroyRequest(groups []*Group) *clusterapi.DestroyRequest {
+	req := &clusterapi.DestroyRequest{GroupsToDestroy: make([]*clusterapi.DestroyGroupRequest, 0, len(groups))}
+	for _, g := range groups {
+		req.GroupsToDestroy = append(req.GroupsToDestroy, &clusterapi.DestroyGroupRequest{
+			GroupId: g.GroupId,
+			Owner:   g.Owner,
+		})
+	}
+	return req
+}
@@ -0,0 +1,110 @@
+package selector
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"reflect"
+	"testing"
+)
+
+func workload(group, owner, project string, props map[string]string) *clusterapi.Workload {
+	opts := []func(*clusterapi.Workload){fixture.OwnedBy(owner, project)}
+	for name, v := range props {
+		opts = append(opts, fixture.Property(name, v))
+	}
+	return fixture.Workload(group, "", opts...)
+}
+
+func TestMatch(t *testing.T) {
+	wl := workload("exp-42", "alice", "P", map[string]string{"stage": "canary-1", "empty": ""})
+	tests := []struct {
+		name     string
+		selector Selector
+		wl       *clusterapi.Workload
+		want     bool
+	}{
+		{"empty selector", Selector{}, wl, true},
+		{"group glob", Selector{Group: "exp-*"}, wl, true},
+		{"other group", Selector{Group: "prod-*"}, wl, false},
+		{"glob matches whole id", Selector{Group: "exp"}, wl, false},
+		{"owner", Selector{Owner: "alice"}, wl, true},
+		{"other owner", Selector{Owner: "bob"}, wl, false},
+		{"project", Selector{Project: "P"}, wl, true},
+		{"other project", Selector{Project: "Q"}, wl, false},
+		{"property glob", Selector{Properties: map[string]string{"stage": "canary-*"}}, wl, true},
+		{"other property value", Selector{Properties: map[string]string{"stage": "prod"}}, wl, false},
+		{"missing property", Selector{Properties: map[string]string{"zone": "*"}}, wl, false},
+		{"empty property value", Selector{Properties: map[string]string{"empty": ""}}, wl, true},
+		{"all conditions", Selector{Group: "exp-*", Owner: "alice", Project: "P", Properties: map[string]string{"stage": "*"}}, wl, true},
+		{"one condition fails", Selector{Group: "exp-*", Owner: "alice", Project: "Q"}, wl, false},
+		{"no owner", Selector{Owner: "alice"}, &clusterapi.Workload{Id: wl.Id}, false},
+		{"no group", Selector{}, &clusterapi.Workload{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.selector.Match(tt.wl); got != tt.want {
+				t.Errorf("%s matched %v, want %v", tt.selector.String(), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProperty(t *testing.T) {
+	s := &Selector{}
+	for _, v := range []string{"stage=canary-*", "empty="} {
+		if err := s.ParseProperty(v); err != nil {
+			t.Errorf("%s: %v", v, err)
+		}
+	}
+	if s.Properties["stage"] != "canary-*" || s.Properties["empty"] != "" {
+		t.Errorf("properties %v", s.Properties)
+	}
+	for _, v := range []string{"stage", "=x", "stage=[a"} {
+		if err := s.ParseProperty(v); err == nil {
+			t.Errorf("%s accepted", v)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	cstate := fixture.State(
+		fixture.Host("b", workload("exp-1", "o", "P", nil), workload("prod", "o", "P", nil)),
+		fixture.Host("a", workload("exp-1", "o", "P", nil), workload("exp-2", "o", "P", nil)),
+	)
+	groups := Select(cstate, &Selector{Group: "exp-*"})
+	if len(groups) != 2 || groups[0].GroupId != "exp-1" || groups[1].GroupId != "exp-2" {
+		t.Fatalf("selected %v", groups)
+	}
+	if g := groups[0]; len(g.Hosts) != 2 || g.Hosts[0] != "a" || g.Hosts[1] != "b" || g.Workloads != 2 {
+		t.Errorf("group exp-1 is %+v", g)
+	}
+	req := DestroyRequest(groups)
+	if len(req.GroupsToDestroy) != 2 || req.GroupsToDestroy[0].Owner == nil {
+		t.Errorf("destroy request %v", req)
+	}
+}
+
+func TestSelectWholeGroup(t *testing.T) {
+	canary := map[string]string{"stage": "canary"}
+	cstate := fixture.State(
+		fixture.Host("a", workload("web", "o", "P", canary)),
+		fixture.Host("b", workload("web", "o", "P", nil)),
+		fixture.Host("c", workload("web", "o", "P", nil), workload("db", "o", "P", nil)),
+	)
+	groups := Select(cstate, &Selector{Properties: map[string]string{"stage": "canary"}})
+	if len(groups) != 1 {
+		t.Fatalf("selected %v", groups)
+	}
+	want := &Group{GroupId: "web", Owner: groups[0].Owner, Hosts: []string{"a", "b", "c"}, Workloads: 3}
+	if !reflect.DeepEqual(groups[0], want) {
+		t.Errorf("group web is %+v, want every host of it %+v", groups[0], want)
+	}
+
+	// state filtered by capi has only the matched workload
+	filtered := fixture.State(fixture.Host("a", workload("web", "o", "P", canary)))
+	groups = Select(filtered, &Selector{Filter: "stage == canary"})
+	Complete(cstate, groups)
+	if len(groups) != 1 || !reflect.DeepEqual(groups[0].Hosts, want.Hosts) || groups[0].Workloads != 3 {
+		t.Errorf("completed group is %+v, want %+v", groups[0], want)
+	}
+}
@@ -0,0 +1,200 @@
+// Package simulate replays group requests against a saved cluster state
+// with different placement strategies and reports how well each did.
+package simulate
+
+import (
+	"bufio"
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/placement"
+	"capi_tools/watch"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+const gb = 1 << 30
+
+// Synthetic generates n requests of typical sizes, same seed gives same requests.
+func Synthetic(n int, seed int64, antiAffinity string) []*placement.Request {
+	rnd := rand.New(rand.NewSource(seed))
+	cpus := []uint64{100, 200, 400, 800, 1600}
+	rams := []uint64{1, 2, 4, 8, 16, 32}
+	disks := []uint64{10, 50, 100, 200}
+	result := make([]*placement.Request, 0, n)
+	for i := 0; i < n; i++ {
+		result = append(result, &placement.Request{
+			Group:    fmt.Sprintf("synthetic-%d", i),
+			Replicas: 1 + rnd.Intn(10),
+			Resources: placement.Resources{
+				Cpu:  cpus[rnd.Intn(len(cpus))],
+				Ram:  rams[rnd.Intn(len(rams))] * gb,
+				Disk: disks[rnd.Intn(len(disks))] * gb,
+			},
+			AntiAffinity: antiAffinity,
+		})
+	}
+	return result
+}
+
+// LoadRequests reads requests from a json lines file.
+func LoadRequests(path string) ([]*placement.Request, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result := make([]*placement.Request, 0)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		r := &placement.Request{}
+		if err := json.Unmarshal(sc.Bytes(), r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		result = append(result, r)
+	}
+	return result, sc.Err()
+}
+
+// Recorded turns workloads created between consecutive states into requests,
+// one per group and state, sized like the first created workload. Removals
+// are not replayed.
+func Recorded(states []*clusterapi.ClusterState, antiAffinity string) []*placement.Request {
+	result := make([]*placement.Request, 0)
+	for i := 1; i < len(states); i++ {
+		before := make(map[string]bool)
+		for _, h := range states[i-1].Hosts {
+			for _, wl := range h.Workloads {
+				before[watch.Key(wl.Id)] = true
+			}
+		}
+		byGroup := make(map[string]*placement.Request)
+		groups := make([]string, 0)
+		for _, h := range states[i].Hosts {
+			for _, wl := range h.Workloads {
+				if before[watch.Key(wl.Id)] {
+					continue
+				}
+				g := client.WorkloadGroup(wl)
+				r := byGroup[g]
+				if r == nil {
+					r = &placement.Request{
+						Group:        g,
+						Resources:    placement.FromComputing(client.WorkloadResources(wl)),
+						AntiAffinity: antiAffinity,
+					}
+					byGroup[g] = r
+					groups = append(groups, g)
+				}
+				r.Replicas++
+			}
+		}
+		sort.Strings(groups)
+		for _, g := range groups {
+			result = append(result, byGroup[g])
+		}
+	}
+	return result
+}
+
+// Report is the outcome of replaying requests with one strategy.
+type Report struct {
+	Strategy      string  `json:"strategy"`
+	Requests      int     `json:"requests"`
+	Placed        int     `json:"placed"`
+	Rejected      int     `json:"rejected"`
+	RejectionRate float64 `json:"rejection_rate"`
+	Replicas      int     `json:"replicas"`
+	Violations    int     `json:"anti_affinity_violations"`
+	CpuUtil       float64 `json:"cpu_utilization"`
+	RamUtil       float64 `json:"ram_utilization"`
+	// share of free ram on hosts unable to fit a median request
+	Fragmentation float64 `json:"fragmentation"`
+	HostsUsed     int     `json:"hosts_used"`
+}
+
+// Run replays requests on a copy of cluster with strategy.
+func Run(cluster *placement.Cluster, requests []*placement.Request, s placement.Strategy) *Report {
+	c := cluster.Clone()
+	rep := &Report{Strategy: s.Name(), Requests: len(requests)}
+	for _, r := range requests {
+		res, err := placement.Place(c, r, s)
+		if err != nil {
+			rep.Rejected++
+			continue
+		}
+		rep.Placed++
+		rep.Replicas += len(res.Hosts)
+		rep.Violations += res.Violations
+	}
+	if rep.Requests > 0 {
+		rep.RejectionRate = float64(rep.Rejected) / float64(rep.Requests)
+	}
+
+	var total, used placement.Resources
+	for _, n := range c.Nodes {
+		if !n.Healthy {
+			continue
+		}
+		total = total.Add(n.Total)
+		used = used.Add(n.Used)
+		if n.Used.Cpu > 0 || n.Used.Ram > 0 {
+			rep.HostsUsed++
+		}
+	}
+	rep.CpuUtil = ratio(used.Cpu, total.Cpu)
+	rep.RamUtil = ratio(used.Ram, total.Ram)
+	rep.Fragmentation = Fragmentation(c, median(requests))
+	return rep
+}
+
+// Fragmentation returns the share of free ram of healthy hosts which can not
+// fit r.
+func Fragmentation(c *placement.Cluster, r placement.Resources) float64 {
+	var free, stranded uint64
+	for _, n := range c.Nodes {
+		if !n.Healthy {
+			continue
+		}
+		f := n.Free()
+		free += f.Ram
+		if !n.Fits(r) {
+			stranded += f.Ram
+		}
+	}
+	return ratio(stranded, free)
+}
+
+// median returns median size of a replica over requests.
+func median(requests []*placement.Request) placement.Resources {
+	if len(requests) == 0 {
+		return placement.Resources{}
+	}
+	cpu := make([]uint64, 0, len(requests))
+	ram := make([]uint64, 0, len(requests))
+	disk := make([]uint64, 0, len(requests))
+	for _, r := range requests {
+		cpu = append(cpu, r.Resources.Cpu)
+		ram = append(ram, r.Resources.Ram)
+		disk = append(disk, r.Resources.Disk)
+	}
+	mid := func(v []uint64) uint64 {
+		sort.Slice(v, func(i, j int) bool { return v[i] < v[j] })
+		return v[len(v)/2]
+	}
+	return placement.Resources{Cpu: mid(cpu), Ram: mid(ram), Disk: mid(disk)}
+}
+
+func ratio(a, b uint64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
@@ -0,0 +1,194 @@
+package snapshot
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/feedback"
+	"capi_tools/plan"
+	"capi_tools/watch"
+	"fmt"
+	"sort"
+)
+
+// workload changes
+const (
+	Created      = "created"
+	Removed      = "removed"
+	TargetChange = "target_changed"
+	StateChange  = "state_changed"
+)
+
+type HealthChange struct {
+	Host string `json:"host"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+type ResourceChange struct {
+	Host    string             `json:"host"`
+	Changes []plan.FieldChange `json:"changes"`
+}
+
+type WorkloadChange struct {
+	Host    string `json:"host"`
+	Service string `json:"service"`
+	Group   string `json:"group"`
+	Owner   string `json:"owner"`
+	Change  string `json:"change"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+}
+
+// GroupSummary counts workload changes of one group.
+type GroupSummary struct {
+	Group   string         `json:"group"`
+	Owner   string         `json:"owner"`
+	Changes map[string]int `json:"changes"`
+}
+
+type Diff struct {
+	HostsAdded   []string          `json:"hosts_added"`
+	HostsRemoved []string          `json:"hosts_removed"`
+	Health       []*HealthChange   `json:"health_changed"`
+	Resources    []*ResourceChange `json:"resources_changed"`
+	Workloads    []*WorkloadChange `json:"workloads"`
+	Groups       []*GroupSummary   `json:"groups"`
+}
+
+// Empty reports whether nothing changed.
+func (d *Diff) Empty() bool {
+	return len(d.HostsAdded)+len(d.HostsRemoved)+len(d.Health)+len(d.Resources)+len(d.Workloads) == 0
+}
+
+// Compare returns what changed from state a to state b.
+func Compare(a, b *clusterapi.ClusterState) *Diff {
+	d := &Diff{
+		HostsAdded:   make([]string, 0),
+		HostsRemoved: make([]string, 0),
+		Health:       make([]*HealthChange, 0),
+		Resources:    make([]*ResourceChange, 0),
+		Workloads:    make([]*WorkloadChange, 0),
+		Groups:       make([]*GroupSummary, 0),
+	}
+	hostsA, hostsB := hosts(a), hosts(b)
+	for id, hb := range hostsB {
+		ha, ok := hostsA[id]
+		if !ok {
+			d.HostsAdded = append(d.HostsAdded, id)
+			continue
+		}
+		if oh, nh := health(ha), health(hb); oh != nh {
+			d.Health = append(d.Health, &HealthChange{id, oh, nh})
+		}
+		if changes := plan.Diff("", resources(ha), resources(hb)); len(changes) > 0 {
+			d.Resources = append(d.Resources, &ResourceChange{id, changes})
+		}
+	}
+	for id := range hostsA {
+		if _, ok := hostsB[id]; !ok {
+			d.HostsRemoved = append(d.HostsRemoved, id)
+		}
+	}
+	sort.Strings(d.HostsAdded)
+	sort.Strings(d.HostsRemoved)
+	sort.Slice(d.Health, func(i, j int) bool { return d.Health[i].Host < d.Health[j].Host })
+	sort.Slice(d.Resources, func(i, j int) bool { return d.Resources[i].Host < d.Resources[j].Host })
+
+	wlsA, wlsB := workloads(a), workloads(b)
+	for k, wb := range wlsB {
+		wa, ok := wlsA[k]
+		switch {
+		case !ok:
+			d.Workloads = append(d.Workloads, change(wb, Created, "", wb.TargetState))
+		case wa.TargetState != wb.TargetState:
+			d.Workloads = append(d.Workloads, change(wb, TargetChange, wa.TargetState, wb.TargetState))
+		case feedback.CurrentState(wa) != feedback.CurrentState(wb):
+			d.Workloads = append(d.Workloads, change(wb, StateChange, feedback.CurrentState(wa), feedback.CurrentState(wb)))
+		}
+	}
+	for k, wa := range wlsA {
+		if _, ok := wlsB[k]; !ok {
+			d.Workloads = append(d.Workloads, change(wa, Removed, wa.TargetState, ""))
+		}
+	}
+	sort.Slice(d.Workloads, func(i, j int) bool {
+		a, b := d.Workloads[i], d.Workloads[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.Service < b.Service
+	})
+
+	byGroup := make(map[string]*GroupSummary)
+	for _, w := range d.Workloads {
+		k := w.Group + "|" + w.Owner
+		if byGroup[k] == nil {
+			byGroup[k] = &GroupSummary{Group: w.Group, Owner: w.Owner, Changes: make(map[string]int)}
+			d.Groups = append(d.Groups, byGroup[k])
+		}
+		byGroup[k].Changes[w.Change]++
+	}
+	return d
+}
+
+func hosts(cstate *clusterapi.ClusterState) map[string]*clusterapi.HostMetadata {
+	result := make(map[string]*clusterapi.HostMetadata)
+	for _, h := range cstate.Hosts {
+		if h.Metadata != nil {
+			result[h.Metadata.Id] = h.Metadata
+		}
+	}
+	return result
+}
+
+func health(md *clusterapi.HostMetadata) string {
+	if md.Health == nil {
+		return "unknown"
+	}
+	return md.Health.State.String()
+}
+
+func resources(md *clusterapi.HostMetadata) *clusterapi.ComputingResources {
+	if md.ComputingResources == nil {
+		return &clusterapi.ComputingResources{}
+	}
+	return md.ComputingResources
+}
+
+// workloads maps workloads by watch.Key, rest dumps have no workload ids
+// so workloads sharing a key are told apart by their order on the host.
+func workloads(cstate *clusterapi.ClusterState) map[string]*clusterapi.Workload {
+	result := make(map[string]*clusterapi.Workload)
+	for _, h := range cstate.Hosts {
+		for _, wl := range h.Workloads {
+			k := watch.Key(wl.Id)
+			for n := 1; result[k] != nil; n++ {
+				k = fmt.Sprintf("%s#%d", watch.Key(wl.Id), n)
+			}
+			result[k] = wl
+		}
+	}
+	return result
+}
+
+func change(wl *clusterapi.Workload, kind, old, new string) *WorkloadChange {
+	owner := ""
+	if wl.Owner != nil {
+		owner = wl.Owner.OwnerId
+		if wl.Owner.ProjectId != "" {
+			owner += "/" + wl.Owner.ProjectId
+		}
+	}
+	return &WorkloadChange{
+		Host:    client.WorkloadHost(wl),
+		Service: client.WorkloadService(wl),
+		Group:   client.WorkloadGroup(wl),
+		Owner:   owner,
+		Change:  kind,
+		Old:     old,
+		New:     new,
+	}
+}
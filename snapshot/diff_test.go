@@ -0,0 +1,98 @@
+package snapshot
+
+import (
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"reflect"
+	"testing"
+)
+
+// brief is "<group> <host> <change> <old> <new>" per workload change.
+func brief(d *Diff) []string {
+	result := make([]string, 0, len(d.Workloads))
+	for _, w := range d.Workloads {
+		result = append(result, w.Group+" "+w.Host+" "+w.Change+" "+w.Old+" "+w.New)
+	}
+	return result
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name      string
+		a, b      *clusterapi.ClusterState
+		added     []string
+		removed   []string
+		health    int
+		resources int
+		workloads []string
+	}{
+		{
+			name: "same state",
+			a:    fixture.State(fixture.Host("a", fixture.Workload("g", "a", fixture.Current("ACTIVE")))),
+			b:    fixture.State(fixture.Host("a", fixture.Workload("g", "a", fixture.Current("ACTIVE")))),
+		},
+		{
+			name:      "hosts",
+			a:         fixture.State(fixture.Host("a"), fixture.Host("b")),
+			b:         fixture.State(fixture.Capacity(fixture.Health(fixture.Host("b"), clusterapi.HostHealthState_DOWN), 2000, 1<<30), fixture.Host("c")),
+			added:     []string{"c"},
+			removed:   []string{"a"},
+			health:    1,
+			resources: 1,
+		},
+		{
+			name: "workloads",
+			a: fixture.State(
+				fixture.Host("a", fixture.Workload("g", "a", fixture.Current("ACTIVE")), fixture.Workload("old", "a", fixture.Current("ACTIVE"))),
+				fixture.Host("b", fixture.Workload("g", "b", fixture.Target("PREPARED"), fixture.Current("PREPARED"))),
+			),
+			b: fixture.State(
+				fixture.Host("a", fixture.Workload("g", "a", fixture.Current("PREPARED")), fixture.Workload("new", "a")),
+				fixture.Host("b", fixture.Workload("g", "b", fixture.Current("PREPARED"))),
+			),
+			workloads: []string{
+				"g a state_changed ACTIVE PREPARED",
+				"g b target_changed PREPARED ACTIVE",
+				"new a created  ACTIVE",
+				"old a removed ACTIVE ",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Compare(tt.a, tt.b)
+			if tt.added == nil {
+				tt.added = []string{}
+			}
+			if tt.removed == nil {
+				tt.removed = []string{}
+			}
+			if tt.workloads == nil {
+				tt.workloads = []string{}
+			}
+			if !reflect.DeepEqual(d.HostsAdded, tt.added) || !reflect.DeepEqual(d.HostsRemoved, tt.removed) {
+				t.Errorf("hosts +%v -%v, want +%v -%v", d.HostsAdded, d.HostsRemoved, tt.added, tt.removed)
+			}
+			if len(d.Health) != tt.health || len(d.Resources) != tt.resources {
+				t.Errorf("%d health and %d resource changes, want %d and %d", len(d.Health), len(d.Resources), tt.health, tt.resources)
+			}
+			if got := brief(d); !reflect.DeepEqual(got, tt.workloads) {
+				t.Errorf("workload changes %q, want %q", got, tt.workloads)
+			}
+			if d.Empty() != (len(tt.added)+len(tt.removed)+tt.health+tt.resources+len(tt.workloads) == 0) {
+				t.Errorf("Empty() = %v", d.Empty())
+			}
+		})
+	}
+}
+
+func TestCompareGroups(t *testing.T) {
+	d := Compare(
+		fixture.State(fixture.Host("a")),
+		fixture.State(fixture.Host("a", fixture.Workload("g", "a"), fixture.Workload("h", "a")),
+			fixture.Host("b", fixture.Workload("g", "b"))),
+	)
+	if len(d.Groups) != 2 || d.Groups[0].Group != "g" || d.Groups[0].Changes[Created] != 2 || d.Groups[0].Owner != "o/P" {
+		t.Errorf("groups %+v", d.Groups)
+	}
+}
@@ -0,0 +1,107 @@
+// Package snapshot saves cluster state to gzipped json files with metadata
+// and compares two snapshots.
+package snapshot
+
+import (
+	"bytes"
+	"capi_tools/clusterapi"
+	"capi_tools/localuser"
+	"capi_tools/rest"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type Snapshot struct {
+	Endpoint string                     `json:"endpoint"`
+	Taken    time.Time                  `json:"taken"`
+	Version  *clusterapi.ClusterVersion `json:"version,omitempty"`
+	Hosts    int                        `json:"hosts"`
+	State    *clusterapi.ClusterState   `json:"state"`
+}
+
+// New wraps state got from endpoint now.
+func New(endpoint string, cstate *clusterapi.ClusterState) *Snapshot {
+	return &Snapshot{
+		Endpoint: endpoint,
+		Taken:    time.Now(),
+		Version:  cstate.Version,
+		Hosts:    len(cstate.Hosts),
+		State:    cstate,
+	}
+}
+
+// DefaultDir is ~/.capi_tools/snapshots.
+func DefaultDir() string {
+	return localuser.Path("snapshots")
+}
+
+// FileName returns name of snapshot file like cluster_state_<unix ts>.json.gz.
+func (s *Snapshot) FileName() string {
+	return fmt.Sprintf("cluster_state_%d.json.gz", s.Taken.Unix())
+}
+
+// Save writes snapshot gzipped to path.
+func Save(path string, s *Snapshot) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	if err := json.NewEncoder(zw).Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads a snapshot written by Save. Plain json state in proto or rest
+// format, like the dumps of the python cron, is accepted too and gets the
+// file modification time as Taken.
+func Load(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		if data, err = ioutil.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+	}
+
+	var probe struct {
+		State json.RawMessage `json:"state"`
+	}
+	if err := json.Unmarshal(data, &probe); err == nil && probe.State != nil {
+		s := &Snapshot{}
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		return s, nil
+	}
+
+	cstate, err := rest.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	s := New("", cstate)
+	if fi, err := os.Stat(path); err == nil {
+		s.Taken = fi.ModTime()
+	}
+	return s, nil
+}
@@ -0,0 +1,262 @@
+// Package spec reads task yaml files and turns them into capi workloads.
+package spec
+
+import (
+	"capi_tools/clusterapi"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// SchedulerId is set on workloads created by capi_tools unless the spec
+// names another scheduler.
+const SchedulerId = "capi_tools"
+
+const defaultPriority = 100
+
+type Resources struct {
+	// cpu in percents of core
+	Cpu uint32 `yaml:"cpu"`
+	// ram in megabytes, suffixes K, M, G, T are accepted
+	Ram string `yaml:"ram"`
+	// disk space in the same units as ram, counted against project quota
+	Disk string `yaml:"disk"`
+}
+
+type Volume struct {
+	Mount string `yaml:"mount"`
+	Url   string `yaml:"url"`
+}
+
+// Spec is a task yaml file.
+type Spec struct {
+	Owner      string            `yaml:"owner"`
+	Priority   int64             `yaml:"priority"`
+	Version    string            `yaml:"version"`
+	Service    string            `yaml:"service"`
+	Group      string            `yaml:"group"`
+	ProjectId  string            `yaml:"project_id"`
+	Command    string            `yaml:"command"`
+	StartHook  string            `yaml:"start_hook"`
+	StatusHook string            `yaml:"status_hook"`
+	Resources  Resources         `yaml:"resources"`
+	Volumes    map[string]Volume `yaml:"volumes"`
+	Properties map[string]string `yaml:"properties"`
+	// hosts to run on, if empty hosts currently running the group are kept
+	Hosts []string `yaml:"hosts"`
+	// number of hosts the reconciler keeps the group on when hosts is empty
+	Replicas int `yaml:"replicas"`
+	// properties of workloads on one host set on top of Properties, like
+	// network addresses, they do not change the fingerprint
+	HostProperties map[string]map[string]string `yaml:"-"`
+	// scheduler workloads are written by, SchedulerId if empty
+	Scheduler string `yaml:"-"`
+}
+
+// Load reads and validates task yaml.
+func Load(path string) (*Spec, error) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spec{}
+	if err := yaml.Unmarshal(source, s); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("bad task %s: %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *Spec) Validate() error {
+	if s.Owner == "" {
+		return fmt.Errorf("owner is not set")
+	}
+	if s.ProjectId == "" {
+		return fmt.Errorf("project_id is not set")
+	}
+	if s.Service == "" && s.Group == "" {
+		return fmt.Errorf("either service or group must be set")
+	}
+	if _, err := ParseBytes(s.Resources.Ram); err != nil {
+		return fmt.Errorf("bad ram: %v", err)
+	}
+	if _, err := ParseBytes(s.Resources.Disk); err != nil {
+		return fmt.Errorf("bad disk: %v", err)
+	}
+	return nil
+}
+
+// GroupId is group name, explicit or <owner>_<service>.
+func (s *Spec) GroupId() string {
+	if s.Group != "" {
+		return s.Group
+	}
+	return s.Owner + "_" + s.Service
+}
+
+// SchedulerName is scheduler id workloads of the task carry.
+func (s *Spec) SchedulerName() string {
+	if s.Scheduler != "" {
+		return s.Scheduler
+	}
+	return SchedulerId
+}
+
+// SlotService is slot service name for workloads of the task.
+func (s *Spec) SlotService() string {
+	if s.Service != "" {
+		return s.Service
+	}
+	return s.Group
+}
+
+// CapiOwner is owner the task is applied on behalf of.
+func (s *Spec) CapiOwner() *clusterapi.Owner {
+	priority := s.Priority
+	if priority == 0 {
+		priority = defaultPriority
+	}
+	return &clusterapi.Owner{
+		OwnerId:   s.Owner,
+		Priority:  priority,
+		ProjectId: s.ProjectId,
+	}
+}
+
+// Entity builds instance description of the task.
+func (s *Spec) Entity() *clusterapi.Entity {
+	ram, _ := ParseBytes(s.Resources.Ram)
+	disk, _ := ParseBytes(s.Resources.Disk)
+
+	resources := make(map[string]*clusterapi.Resourcelike)
+	if s.StartHook != "" {
+		resources["iss_hook_start"] = urlResource(s.StartHook)
+	}
+	if s.StatusHook != "" {
+		resources["iss_hook_status"] = urlResource(s.StatusHook)
+	}
+
+	names := make([]string, 0, len(s.Volumes))
+	for name := range s.Volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	volumes := make([]*clusterapi.Volume, 0, len(names))
+	for _, name := range names {
+		v := s.Volumes[name]
+		volumes = append(volumes, &clusterapi.Volume{
+			MountPoint: v.Mount,
+			Uuid:       name,
+			Layers:     []*clusterapi.Resource{{Uuid: name, Urls: []string{v.Url}}},
+		})
+	}
+
+	constraints := make(map[string]string)
+	if s.Command != "" {
+		constraints["command"] = s.Command
+	}
+
+	return &clusterapi.Entity{
+		Instance: &clusterapi.Instance{
+			Container: &clusterapi.Container{
+				Id: s.SlotService(),
+				ComputingResources: &clusterapi.ComputingResources{
+					CpuPowerPercentsCore: s.Resources.Cpu,
+					RamBytes:             ram,
+					HddSpaceBytes:        disk,
+				},
+				Constraints: constraints,
+			},
+			Volumes:   volumes,
+			Resources: resources,
+		},
+	}
+}
+
+func urlResource(url string) *clusterapi.Resourcelike {
+	return &clusterapi.Resourcelike{
+		Resource: &clusterapi.Resource{
+			Uuid: fmt.Sprintf("%x", sha1.Sum([]byte(url))),
+			Urls: []string{url},
+		},
+	}
+}
+
+// Fingerprint identifies task content, it changes whenever entity,
+// properties or version change.
+func (s *Spec) Fingerprint() string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%s\n", s.Version, proto.CompactTextString(s.Entity()))
+	keys := make([]string, 0, len(s.Properties))
+	for k := range s.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\n", k, s.Properties[k])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:16]
+}
+
+// Workload builds workload of the task for host.
+func (s *Spec) Workload(host string, generation string) *clusterapi.Workload {
+	props := make(map[string]string, len(s.Properties))
+	for k, v := range s.Properties {
+		props[k] = v
+	}
+	for k, v := range s.HostProperties[host] {
+		props[k] = v
+	}
+	return &clusterapi.Workload{
+		Entity:      s.Entity(),
+		Owner:       s.CapiOwner(),
+		SchedulerId: s.SchedulerName(),
+		Properties:  props,
+		Id: &clusterapi.WorkloadId{
+			Slot: &clusterapi.Slot{Service: s.SlotService(), Host: host},
+			Configuration: &clusterapi.ConfigurationId{
+				GroupId:               s.GroupId(),
+				GroupStateFingerprint: s.Fingerprint(),
+			},
+		},
+		TargetState:         "ACTIVE",
+		TransitionTimestamp: uint64(time.Now().Unix()),
+		Generation:          generation,
+	}
+}
+
+// ParseBytes parses size like "100", "512M" or "1G", plain numbers are megabytes.
+func ParseBytes(v string) (uint64, error) {
+	v = strings.TrimSpace(strings.ToUpper(v))
+	if v == "" {
+		return 0, nil
+	}
+	mult := uint64(1 << 20)
+	switch v[len(v)-1] {
+	case 'K':
+		mult = 1 << 10
+	case 'M':
+		mult = 1 << 20
+	case 'G':
+		mult = 1 << 30
+	case 'T':
+		mult = 1 << 40
+	}
+	if v[len(v)-1] < '0' || v[len(v)-1] > '9' {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(n * float64(mult)), nil
+}
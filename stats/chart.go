@@ -0,0 +1,247 @@
+package stats
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strconv"
+	"unicode"
+)
+
+// chart geometry shared by svg and png output, one panel per histogram
+const (
+	chartWidth  = 1000
+	panelHeight = 320
+	marginLeft  = 60
+	marginRight = 20
+	marginTop   = 40
+	marginBot   = 40
+)
+
+type panel struct {
+	h      *Histogram
+	top    int
+	plotW  int
+	plotH  int
+	max    int
+	yTicks []int
+}
+
+func layout(hists []*Histogram) []panel {
+	panels := make([]panel, 0, len(hists))
+	for i, h := range hists {
+		p := panel{
+			h:     h,
+			top:   i * panelHeight,
+			plotW: chartWidth - marginLeft - marginRight,
+			plotH: panelHeight - marginTop - marginBot,
+			max:   h.MaxCount(),
+		}
+		if p.max == 0 {
+			p.max = 1
+		}
+		step := niceStep(p.max)
+		for y := 0; y <= p.max; y += step {
+			p.yTicks = append(p.yTicks, y)
+		}
+		panels = append(panels, p)
+	}
+	return panels
+}
+
+// niceStep returns 1, 2 or 5 times a power of 10 giving about 5 ticks up to max.
+func niceStep(max int) int {
+	step := 1
+	for {
+		for _, m := range []int{1, 2, 5} {
+			if max/(step*m) <= 5 {
+				return step * m
+			}
+		}
+		step *= 10
+	}
+}
+
+func (p panel) barX(i int) (int, int) {
+	n := len(p.h.Buckets)
+	return marginLeft + i*p.plotW/n, marginLeft + (i+1)*p.plotW/n
+}
+
+func (p panel) y(count int) int {
+	return p.top + marginTop + p.plotH - count*p.plotH/p.max
+}
+
+// labelEvery returns how often to label buckets so labels do not overlap.
+func (p panel) labelEvery() int {
+	n := len(p.h.Buckets)
+	every := 1
+	for n/every > 20 {
+		every++
+	}
+	return every
+}
+
+// WriteSVG renders histograms as bar charts stacked vertically.
+func WriteSVG(w io.Writer, hists []*Histogram) error {
+	panels := layout(hists)
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`+"\n",
+		chartWidth, len(panels)*panelHeight)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	for _, p := range panels {
+		base := p.y(0)
+		fmt.Fprintf(w, `<text x="%d" y="%d" font-size="14" text-anchor="middle">%s</text>`+"\n",
+			chartWidth/2, p.top+25, html.EscapeString(fmt.Sprintf("%s %s by host, %d hosts", p.h.Title, p.h.Metric, p.h.Hosts)))
+		for _, t := range p.yTicks {
+			fmt.Fprintf(w, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#ddd"/>`+"\n", marginLeft, p.y(t), marginLeft+p.plotW, p.y(t))
+			fmt.Fprintf(w, `<text x="%d" y="%d" text-anchor="end">%d</text>`+"\n", marginLeft-5, p.y(t)+4, t)
+		}
+		every := p.labelEvery()
+		for i, b := range p.h.Buckets {
+			x1, x2 := p.barX(i)
+			fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" fill="steelblue"><title>%g-%g %s: %d hosts</title></rect>`+"\n",
+				x1, p.y(b.Count), x2-x1-1, base-p.y(b.Count), b.Low, b.High, html.EscapeString(p.h.Unit), b.Count)
+			if i%every == 0 {
+				fmt.Fprintf(w, `<text x="%d" y="%d" text-anchor="middle">%g</text>`+"\n", x1, base+15, b.Low)
+			}
+		}
+		fmt.Fprintf(w, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", marginLeft, base, marginLeft+p.plotW, base)
+		fmt.Fprintf(w, `<text x="%d" y="%d" text-anchor="middle">%s</text>`+"\n",
+			chartWidth/2, base+32, html.EscapeString(p.h.Metric+", "+p.h.Unit))
+	}
+	_, err = fmt.Fprintln(w, "</svg>")
+	return err
+}
+
+var (
+	white = color.RGBA{255, 255, 255, 255}
+	black = color.RGBA{0, 0, 0, 255}
+	grid  = color.RGBA{221, 221, 221, 255}
+	bar   = color.RGBA{70, 130, 180, 255}
+)
+
+// WritePNG renders histograms like WriteSVG with the pixel font of tick
+// values, there must be at least one histogram.
+func WritePNG(w io.Writer, hists []*Histogram) error {
+	if len(hists) == 0 {
+		return fmt.Errorf("no histograms to draw")
+	}
+	panels := layout(hists)
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, len(panels)*panelHeight))
+	fill(img, 0, 0, chartWidth, len(panels)*panelHeight, white)
+	for _, p := range panels {
+		base := p.y(0)
+		title := fmt.Sprintf("%s %s by host, %d hosts", p.h.Title, p.h.Metric, p.h.Hosts)
+		text(img, title, (chartWidth-textWidth(title))/2, p.top+12, false)
+		for _, t := range p.yTicks {
+			fill(img, marginLeft, p.y(t), marginLeft+p.plotW, p.y(t)+1, grid)
+			text(img, strconv.Itoa(t), marginLeft-5, p.y(t)-2, true)
+		}
+		every := p.labelEvery()
+		for i, b := range p.h.Buckets {
+			x1, x2 := p.barX(i)
+			fill(img, x1, p.y(b.Count), x2-1, base, bar)
+			if i%every == 0 {
+				text(img, strconv.FormatFloat(b.Low, 'g', -1, 64), x1, base+6, false)
+			}
+		}
+		fill(img, marginLeft, base, marginLeft+p.plotW, base+1, black)
+		label := p.h.Metric + ", " + p.h.Unit
+		text(img, label, (chartWidth-textWidth(label))/2, base+22, false)
+	}
+	return png.Encode(w, img)
+}
+
+func fill(img *image.RGBA, x1, y1, x2, y2 int, c color.RGBA) {
+	for x := x1; x < x2; x++ {
+		for y := y1; y < y2; y++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// glyphs is a 3x5 pixel font for tick values, titles and labels, letters
+// are drawn upper case.
+var glyphs = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", "..#", "..#", "..#"},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'.': {"...", "...", "...", "...", ".#."},
+	'-': {"...", "...", "###", "...", "..."},
+	'+': {"...", ".#.", "###", ".#.", "..."},
+	' ': {"...", "...", "...", "...", "..."},
+	',': {"...", "...", "...", ".#.", "#.."},
+	':': {"...", ".#.", "...", ".#.", "..."},
+	'_': {"...", "...", "...", "...", "###"},
+	'/': {"..#", "..#", ".#.", "#..", "#.."},
+	'%': {"#.#", "..#", ".#.", "#..", "#.#"},
+	'(': {".#.", "#..", "#..", "#..", ".#."},
+	')': {".#.", "..#", "..#", "..#", ".#."},
+	'A': {".#.", "#.#", "###", "#.#", "#.#"},
+	'B': {"##.", "#.#", "##.", "#.#", "##."},
+	'C': {".##", "#..", "#..", "#..", ".##"},
+	'D': {"##.", "#.#", "#.#", "#.#", "##."},
+	'E': {"###", "#..", "##.", "#..", "###"},
+	'F': {"###", "#..", "##.", "#..", "#.."},
+	'G': {".##", "#..", "#.#", "#.#", ".##"},
+	'H': {"#.#", "#.#", "###", "#.#", "#.#"},
+	'I': {"###", ".#.", ".#.", ".#.", "###"},
+	'J': {"..#", "..#", "..#", "#.#", ".#."},
+	'K': {"#.#", "#.#", "##.", "#.#", "#.#"},
+	'L': {"#..", "#..", "#..", "#..", "###"},
+	'M': {"#.#", "###", "###", "#.#", "#.#"},
+	'N': {"##.", "#.#", "#.#", "#.#", "#.#"},
+	'O': {".#.", "#.#", "#.#", "#.#", ".#."},
+	'P': {"##.", "#.#", "##.", "#..", "#.."},
+	'Q': {".#.", "#.#", "#.#", "##.", ".##"},
+	'R': {"##.", "#.#", "##.", "#.#", "#.#"},
+	'S': {".##", "#..", ".#.", "..#", "##."},
+	'T': {"###", ".#.", ".#.", ".#.", ".#."},
+	'U': {"#.#", "#.#", "#.#", "#.#", "###"},
+	'V': {"#.#", "#.#", "#.#", "#.#", ".#."},
+	'W': {"#.#", "#.#", "###", "###", "#.#"},
+	'X': {"#.#", "#.#", ".#.", "#.#", "#.#"},
+	'Y': {"#.#", "#.#", ".#.", ".#.", ".#."},
+	'Z': {"###", "..#", ".#.", "#..", "###"},
+}
+
+const glyphScale, glyphAdvance = 2, 8
+
+// textWidth returns width of s drawn by text in pixels.
+func textWidth(s string) int {
+	return len([]rune(s)) * glyphAdvance
+}
+
+// text draws s at x, y scaled twice, right aligned to x if right.
+// Characters the font lacks are left blank.
+func text(img *image.RGBA, s string, x, y int, right bool) {
+	if right {
+		x -= textWidth(s)
+	}
+	for i, r := range []rune(s) {
+		glyph, ok := glyphs[unicode.ToUpper(r)]
+		if !ok {
+			continue
+		}
+		for row, line := range glyph {
+			for col, px := range line {
+				if px == '#' {
+					gx, gy := x+i*glyphAdvance+col*glyphScale, y+row*glyphScale
+					fill(img, gx, gy, gx+glyphScale, gy+glyphScale, black)
+				}
+			}
+		}
+	}
+}
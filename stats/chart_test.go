@@ -0,0 +1,50 @@
+package stats
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// dark counts black pixels in rows [y1, y2) of img.
+func dark(img image.Image, y1, y2 int) int {
+	n := 0
+	for y := y1; y < y2; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			if r, g, b, _ := img.At(x, y).RGBA(); r == 0 && g == 0 && b == 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestWritePNG(t *testing.T) {
+	h := &Histogram{Title: "sas", Metric: "cpu", Unit: "cores", Hosts: 3,
+		Buckets: []Bucket{{0, 10, 1}, {10, 20, 2}}}
+	var buf bytes.Buffer
+	if err := WritePNG(&buf, []*Histogram{h, h}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dy() != 2*panelHeight {
+		t.Errorf("height %d, want %d", img.Bounds().Dy(), 2*panelHeight)
+	}
+	for i := 0; i < 2; i++ {
+		top := i * panelHeight
+		if dark(img, top, top+marginTop) == 0 {
+			t.Errorf("panel %d has no title", i)
+		}
+		if dark(img, top+panelHeight-marginBot+20, top+panelHeight) == 0 {
+			t.Errorf("panel %d has no metric label", i)
+		}
+	}
+
+	if err := WritePNG(&buf, nil); err == nil {
+		t.Error("no error drawing no histograms")
+	}
+}
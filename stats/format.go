@@ -0,0 +1,40 @@
+package stats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteTable prints histograms as text with bars scaled to width characters.
+func WriteTable(w io.Writer, hists []*Histogram, width int) {
+	for _, h := range hists {
+		fmt.Fprintf(w, "%s %s (%s), %d hosts\n", h.Title, h.Metric, h.Unit, h.Hosts)
+		max := h.MaxCount()
+		for _, b := range h.Buckets {
+			bar := 0
+			if max > 0 {
+				bar = b.Count * width / max
+			}
+			fmt.Fprintf(w, "  %8g - %-8g %6d %s\n", b.Low, b.High, b.Count, strings.Repeat("#", bar))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// WriteCSV writes one row per bucket: group, metric, unit, low, high, hosts.
+func WriteCSV(w io.Writer, hists []*Histogram) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"group", "metric", "unit", "low", "high", "hosts"})
+	for _, h := range hists {
+		for _, b := range h.Buckets {
+			cw.Write([]string{h.Title, h.Metric, h.Unit,
+				strconv.FormatFloat(b.Low, 'g', -1, 64), strconv.FormatFloat(b.High, 'g', -1, 64),
+				strconv.Itoa(b.Count)})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
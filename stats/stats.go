@@ -0,0 +1,198 @@
+// Package stats computes distributions of host resources over the cluster,
+// the go version of get_cstate_hist.py.
+package stats
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const gb = 1 << 30
+
+// Metric is a per host value histograms are built of.
+type Metric struct {
+	Name string
+	Unit string
+	// default bucket width
+	Width float64
+	value func(h *Host) float64
+}
+
+var Metrics = []Metric{
+	{"ram_total", "GB", 10, func(h *Host) float64 { return float64(h.RamTotal) / gb }},
+	{"ram_free", "GB", 10, func(h *Host) float64 { return float64(h.RamFree()) / gb }},
+	{"cpu_total", "%", 200, func(h *Host) float64 { return float64(h.CpuTotal) }},
+	{"cpu_free", "%", 200, func(h *Host) float64 { return float64(h.CpuFree()) }},
+}
+
+// FindMetric returns metric by name.
+func FindMetric(name string) (Metric, error) {
+	names := make([]string, 0, len(Metrics))
+	for _, m := range Metrics {
+		if m.Name == name {
+			return m, nil
+		}
+		names = append(names, m.Name)
+	}
+	return Metric{}, fmt.Errorf("unknown metric %s, want one of %s", name, strings.Join(names, ", "))
+}
+
+// Host is total and allocated resources of one host.
+type Host struct {
+	Id       string
+	Location string
+	Health   string
+	// cpu in percents of core
+	CpuTotal  uint64
+	CpuAlloc  uint64
+	RamTotal  uint64
+	RamAlloc  uint64
+	Workloads int
+}
+
+func (h *Host) CpuFree() uint64 {
+	if h.CpuAlloc > h.CpuTotal {
+		return 0
+	}
+	return h.CpuTotal - h.CpuAlloc
+}
+
+func (h *Host) RamFree() uint64 {
+	if h.RamAlloc > h.RamTotal {
+		return 0
+	}
+	return h.RamTotal - h.RamAlloc
+}
+
+// Hosts sums resources of hosts and their workloads.
+func Hosts(cstate *clusterapi.ClusterState) []*Host {
+	result := make([]*Host, 0, len(cstate.Hosts))
+	for _, h := range cstate.Hosts {
+		md := h.Metadata
+		if md == nil {
+			continue
+		}
+		host := &Host{Id: md.Id, Location: Location(md.Location), Health: "unknown", Workloads: len(h.Workloads)}
+		if md.Health != nil {
+			host.Health = md.Health.State.String()
+		}
+		if md.ComputingResources != nil {
+			host.CpuTotal = uint64(md.ComputingResources.CpuPowerPercentsCore)
+			host.RamTotal = md.ComputingResources.RamBytes
+		}
+		for _, wl := range h.Workloads {
+			res := client.WorkloadResources(wl)
+			host.CpuAlloc += uint64(res.CpuPowerPercentsCore)
+			host.RamAlloc += res.RamBytes
+		}
+		result = append(result, host)
+	}
+	return result
+}
+
+// Location returns location as country/city/building or unknown.
+func Location(l *clusterapi.Location) string {
+	if l == nil {
+		return "unknown"
+	}
+	parts := make([]string, 0, 3)
+	for _, p := range []string{l.Country, l.City, l.Building} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return "unknown"
+	}
+	return strings.Join(parts, "/")
+}
+
+// Split groups hosts by "location", "health" or puts all of them into "all".
+func Split(hosts []*Host, by string) (map[string][]*Host, error) {
+	result := make(map[string][]*Host)
+	for _, h := range hosts {
+		var key string
+		switch by {
+		case "", "all":
+			key = "all"
+		case "location":
+			key = h.Location
+		case "health":
+			key = h.Health
+		default:
+			return nil, fmt.Errorf("can not group hosts by %s, want all, location or health", by)
+		}
+		result[key] = append(result[key], h)
+	}
+	return result, nil
+}
+
+type Bucket struct {
+	Low   float64
+	High  float64
+	Count int
+}
+
+// Histogram is distribution of a metric over a set of hosts.
+type Histogram struct {
+	Title   string
+	Metric  string
+	Unit    string
+	Hosts   int
+	Buckets []Bucket
+}
+
+// Build returns histogram of metric over hosts with buckets of width,
+// starting at 0 and ending at the bucket of the largest value.
+func Build(title string, m Metric, width float64, hosts []*Host) *Histogram {
+	if width <= 0 {
+		width = m.Width
+	}
+	h := &Histogram{Title: title, Metric: m.Name, Unit: m.Unit, Hosts: len(hosts)}
+	max := 0.0
+	values := make([]float64, 0, len(hosts))
+	for _, host := range hosts {
+		v := m.value(host)
+		values = append(values, v)
+		max = math.Max(max, v)
+	}
+	n := int(max/width) + 1
+	h.Buckets = make([]Bucket, n)
+	for i := range h.Buckets {
+		h.Buckets[i].Low = float64(i) * width
+		h.Buckets[i].High = float64(i+1) * width
+	}
+	for _, v := range values {
+		h.Buckets[int(v/width)].Count++
+	}
+	return h
+}
+
+// Histograms builds histogram of metric for every group of hosts, sorted by title.
+func Histograms(groups map[string][]*Host, m Metric, width float64) []*Histogram {
+	titles := make([]string, 0, len(groups))
+	for t := range groups {
+		titles = append(titles, t)
+	}
+	sort.Strings(titles)
+	result := make([]*Histogram, 0, len(titles))
+	for _, t := range titles {
+		result = append(result, Build(t, m, width, groups[t]))
+	}
+	return result
+}
+
+// MaxCount returns the largest bucket count.
+func (h *Histogram) MaxCount() int {
+	max := 0
+	for _, b := range h.Buckets {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	return max
+}
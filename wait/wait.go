@@ -0,0 +1,224 @@
+// Package wait follows workloads of a group until they reach target state,
+// reporting what every host is busy with meanwhile.
+package wait
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/feedback"
+	"capi_tools/watch"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	// wanted current state, each workload's target state if empty
+	State string
+	// wait for workloads of this generation, any if empty
+	Generation string
+	// hosts to wait for, all hosts of the group if empty
+	Hosts []string
+	// hosts expected to have no workloads of the group
+	Removed []string
+	// give up after, no limit if 0
+	Timeout time.Duration
+	// progress output, nothing is printed if nil
+	Out io.Writer
+}
+
+// Status is where one host of the group is.
+type Status struct {
+	Host    string
+	State   string
+	Target  string
+	Detail  string
+	Failure string
+	Done    bool
+}
+
+func (s *Status) String() string {
+	line := fmt.Sprintf("%s %s", s.Host, s.State)
+	if s.Target != "" && s.State != s.Target {
+		line += " -> " + s.Target
+	}
+	if s.Failure != "" {
+		return line + ": FAILED " + s.Failure
+	}
+	if s.Detail != "" {
+		line += ": " + s.Detail
+	}
+	return line
+}
+
+// Evaluate returns status of workload against opts.
+func Evaluate(wl *clusterapi.Workload, opts Options) *Status {
+	s := &Status{
+		Host:   client.WorkloadHost(wl),
+		State:  feedback.CurrentState(wl),
+		Target: opts.State,
+	}
+	if s.Target == "" {
+		s.Target = wl.TargetState
+	}
+	if opts.Generation != "" && wl.Generation != opts.Generation {
+		s.Detail = fmt.Sprintf("generation %s, waiting for %s", wl.Generation, opts.Generation)
+		return s
+	}
+
+	s.Failure = Failure(wl)
+	s.Detail = Activity(wl)
+	s.Done = s.Failure == "" && s.State == s.Target
+	return s
+}
+
+// Failure returns reason workload can not reach target state or "".
+func Failure(wl *clusterapi.Workload) string {
+	for _, m := range feedback.All(wl) {
+		if m.FailMessage != nil || m.HookFailure != nil || m.CountLimit != nil || m.TimeLimitViolation != nil {
+			return feedback.Describe(m.FeedbackMessage)
+		}
+	}
+	return ""
+}
+
+// Activity describes what workload is busy with: downloads, checksums, hooks, locks.
+func Activity(wl *clusterapi.Workload) string {
+	parts := make([]string, 0)
+	for _, m := range feedback.All(wl) {
+		if m.Progress != nil || m.ChecksumProgress != nil || m.HookInProgress != nil || m.Lock != nil {
+			parts = append(parts, feedback.Describe(m.FeedbackMessage))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Group follows workloads of group until all are done, one of them fails
+// or timeout expires.
+func Group(c *client.Client, group string, opts Options) error {
+	m := watch.New(c, "", client.GroupFilter(group))
+	start := time.Now()
+	last := ""
+	for {
+		if opts.Timeout > 0 && time.Since(start) > opts.Timeout {
+			return fmt.Errorf("timed out after %s", opts.Timeout)
+		}
+		changed, err := m.Sync()
+		if err != nil {
+			log.Printf("group %s: %v", group, err)
+			time.Sleep(m.Timeout)
+			continue
+		}
+
+		if changed {
+			statuses := Check(group, m.Workloads(), opts)
+			if report := render(statuses); opts.Out != nil && report != last {
+				fmt.Fprintf(opts.Out, "--- %s, %s elapsed\n%s", summary(statuses), time.Since(start).Truncate(time.Second), report)
+				last = report
+			}
+
+			failed := make([]string, 0)
+			pending := 0
+			for _, s := range statuses {
+				if s.Failure != "" {
+					failed = append(failed, fmt.Sprintf("%s: %s", s.Host, s.Failure))
+				} else if !s.Done {
+					pending++
+				}
+			}
+			if len(failed) > 0 {
+				return fmt.Errorf("%d hosts failed: %s", len(failed), strings.Join(failed, "; "))
+			}
+			// no workloads yet means capi has not seen the transition
+			if pending == 0 && len(statuses) > 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// Check evaluates group workloads against opts, returns one status per
+// host, hosts missing workloads are reported as not done.
+func Check(group string, wls []*clusterapi.Workload, opts Options) []*Status {
+	byHost := make(map[string][]*clusterapi.Workload)
+	for _, wl := range wls {
+		if client.WorkloadGroup(wl) == group {
+			byHost[client.WorkloadHost(wl)] = append(byHost[client.WorkloadHost(wl)], wl)
+		}
+	}
+
+	hosts := opts.Hosts
+	if len(hosts) == 0 {
+		for h := range byHost {
+			hosts = append(hosts, h)
+		}
+	}
+	removed := make(map[string]bool)
+	for _, h := range opts.Removed {
+		removed[h] = true
+	}
+
+	statuses := make([]*Status, 0)
+	for _, h := range hosts {
+		if removed[h] {
+			continue
+		}
+		if len(byHost[h]) == 0 {
+			statuses = append(statuses, &Status{Host: h, State: "ABSENT", Target: opts.State})
+			continue
+		}
+		for _, wl := range byHost[h] {
+			// another generation waiting to be removed does not matter
+			if opts.Generation != "" && wl.Generation != opts.Generation && hasGeneration(byHost[h], opts.Generation) {
+				continue
+			}
+			statuses = append(statuses, Evaluate(wl, opts))
+		}
+	}
+	for _, h := range opts.Removed {
+		s := &Status{Host: h, State: "PRESENT", Target: "ABSENT"}
+		if len(byHost[h]) == 0 {
+			s.State, s.Done = "ABSENT", true
+		}
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Host < statuses[j].Host })
+	return statuses
+}
+
+func hasGeneration(wls []*clusterapi.Workload, gen string) bool {
+	for _, wl := range wls {
+		if wl.Generation == gen {
+			return true
+		}
+	}
+	return false
+}
+
+func summary(statuses []*Status) string {
+	done := 0
+	for _, s := range statuses {
+		if s.Done {
+			done++
+		}
+	}
+	return fmt.Sprintf("%d/%d hosts ready", done, len(statuses))
+}
+
+func render(statuses []*Status) string {
+	var b strings.Builder
+	for _, s := range statuses {
+		mark := " "
+		switch {
+		case s.Failure != "":
+			mark = "!"
+		case s.Done:
+			mark = "+"
+		}
+		fmt.Fprintf(&b, "  %s %s\n", mark, s)
+	}
+	return b.String()
+}
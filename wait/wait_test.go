@@ -0,0 +1,117 @@
+package wait
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"capi_tools/fixture"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// brief is "<host> <state> done|failed|pending" per status.
+func brief(statuses []*Status) []string {
+	result := make([]string, 0, len(statuses))
+	for _, s := range statuses {
+		mark := "pending"
+		switch {
+		case s.Failure != "":
+			mark = "failed"
+		case s.Done:
+			mark = "done"
+		}
+		result = append(result, s.Host+" "+s.State+" "+mark)
+	}
+	return result
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		wls  []*clusterapi.Workload
+		opts Options
+		want []string
+	}{
+		{
+			name: "target state of each workload",
+			wls: []*clusterapi.Workload{
+				fixture.Workload("g", "a", fixture.Generation("1"), fixture.Current("ACTIVE")),
+				fixture.Workload("g", "b", fixture.Generation("1"), fixture.Current("PREPARED")),
+				fixture.Workload("other", "c", fixture.Generation("1"), fixture.Current("PREPARED")),
+			},
+			want: []string{"a ACTIVE done", "b PREPARED pending"},
+		},
+		{
+			name: "wanted state",
+			wls:  []*clusterapi.Workload{fixture.Workload("g", "a", fixture.Generation("1"), fixture.Current("PREPARED"))},
+			opts: Options{State: "PREPARED"},
+			want: []string{"a PREPARED done"},
+		},
+		{
+			name: "old generation is not done",
+			wls:  []*clusterapi.Workload{fixture.Workload("g", "a", fixture.Generation("1"), fixture.Current("ACTIVE"))},
+			opts: Options{Generation: "2"},
+			want: []string{"a ACTIVE pending"},
+		},
+		{
+			name: "old generation next to the wanted one is ignored",
+			wls: []*clusterapi.Workload{
+				fixture.Workload("g", "a", fixture.Generation("1"), fixture.Current("ACTIVE")),
+				fixture.Workload("g", "a", fixture.Generation("2"), fixture.Current("ACTIVE")),
+			},
+			opts: Options{Generation: "2"},
+			want: []string{"a ACTIVE done"},
+		},
+		{
+			name: "missing host",
+			wls:  []*clusterapi.Workload{fixture.Workload("g", "a", fixture.Generation("1"), fixture.Current("ACTIVE"))},
+			opts: Options{Hosts: []string{"a", "b"}},
+			want: []string{"a ACTIVE done", "b ABSENT pending"},
+		},
+		{
+			name: "removed hosts",
+			wls:  []*clusterapi.Workload{fixture.Workload("g", "a", fixture.Generation("1"), fixture.Current("ACTIVE"))},
+			opts: Options{Hosts: []string{"a", "b"}, Removed: []string{"a", "b"}},
+			want: []string{"a PRESENT pending", "b ABSENT done"},
+		},
+		{
+			name: "failure",
+			wls:  []*clusterapi.Workload{fixture.Workload("g", "a", fixture.Generation("1"), fixture.Current("PREPARED"), fixture.Failed("no space left"))},
+			want: []string{"a PREPARED failed"},
+		},
+		{
+			name: "no workloads",
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := brief(Check("g", tt.wls, tt.opts)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroup(t *testing.T) {
+	tests := []struct {
+		name string
+		wl   *clusterapi.Workload
+		err  bool
+	}{
+		{"done", fixture.Workload("g", "a", fixture.Current("ACTIVE")), false},
+		{"failed", fixture.Workload("g", "a", fixture.Current("PREPARED"), fixture.Failed("no space left")), true},
+		{"never done", fixture.Workload("g", "a", fixture.Current("PREPARED")), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(fixture.NewCapi(fixture.Host("a", tt.wl), fixture.Host("b")))
+			defer srv.Close()
+			err := Group(client.New(srv.URL), "g", Options{Timeout: 100 * time.Millisecond})
+			if (err != nil) != tt.err {
+				t.Errorf("Group = %v, want error %v", err, tt.err)
+			}
+		})
+	}
+}
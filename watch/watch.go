@@ -0,0 +1,172 @@
+// Package watch keeps a local mirror of cluster state up to date by
+// following state deltas instead of refetching the full state.
+package watch
+
+import (
+	"capi_tools/client"
+	"capi_tools/clusterapi"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Mirror is a local copy of hosts and workloads matching filters.
+type Mirror struct {
+	c              *client.Client
+	hostFilter     string
+	workloadFilter string
+	// how long a delta request waits for changes on server side
+	Timeout time.Duration
+
+	version   *clusterapi.ClusterVersion
+	hosts     map[string]*clusterapi.HostMetadata
+	workloads map[string]*clusterapi.Workload
+}
+
+func New(c *client.Client, hostFilter, workloadFilter string) *Mirror {
+	return &Mirror{
+		c:              c,
+		hostFilter:     hostFilter,
+		workloadFilter: workloadFilter,
+		Timeout:        10 * time.Second,
+	}
+}
+
+// Key identifies workload within cluster: slot and configuration.
+func Key(id *clusterapi.WorkloadId) string {
+	if id == nil {
+		return ""
+	}
+	var slot, conf string
+	if id.Slot != nil {
+		slot = id.Slot.Service + "@" + id.Slot.Host
+	}
+	if id.Configuration != nil {
+		conf = id.Configuration.GroupId + "#" + id.Configuration.GroupStateFingerprint
+	}
+	return slot + "/" + conf
+}
+
+// Sync loads full state on first call, afterwards waits for a delta and
+// applies it. It returns true if anything changed.
+func (m *Mirror) Sync() (bool, error) {
+	if m.version == nil {
+		return true, m.load()
+	}
+
+	delta, err := m.c.GetStateDelta(&clusterapi.GetStateDeltaRequest{
+		FromVersion:        m.version,
+		HostFilter:         m.hostFilter,
+		WorkloadFilter:     m.workloadFilter,
+		WorkloadLowerBound: 1,
+		TimeoutMs:          int32(m.Timeout / time.Millisecond),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get state delta: %v", err)
+	}
+	return m.Apply(delta), nil
+}
+
+func (m *Mirror) load() error {
+	cstate, err := m.c.GetState(&clusterapi.GetStateRequest{
+		HostFilter:     m.hostFilter,
+		WorkloadFilter: m.workloadFilter,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get state: %v", err)
+	}
+	m.Reset(cstate)
+	return nil
+}
+
+// Reset replaces mirror content with full state.
+func (m *Mirror) Reset(cstate *clusterapi.ClusterState) {
+	m.version = cstate.Version
+	m.hosts = make(map[string]*clusterapi.HostMetadata)
+	m.workloads = make(map[string]*clusterapi.Workload)
+	for _, h := range cstate.Hosts {
+		if h.Metadata != nil {
+			m.hosts[h.Metadata.Id] = h.Metadata
+		}
+		for _, wl := range h.Workloads {
+			m.workloads[Key(wl.Id)] = wl
+		}
+	}
+}
+
+// Apply merges delta into the mirror, returns true if anything changed.
+func (m *Mirror) Apply(delta *clusterapi.ClusterStateDelta) bool {
+	changed := false
+	for _, h := range delta.ChangedHosts {
+		m.hosts[h.Id] = h
+		changed = true
+	}
+	for _, h := range delta.FallenOutHosts {
+		m.removeHost(h.Id)
+		changed = true
+	}
+	for _, id := range delta.RemovedHostIds {
+		m.removeHost(id)
+		changed = true
+	}
+	for _, wl := range delta.ChangedWorkloads {
+		m.workloads[Key(wl.Id)] = wl
+		changed = true
+	}
+	for _, wl := range delta.FallenOutWorkloads {
+		delete(m.workloads, Key(wl.Id))
+		changed = true
+	}
+	for _, id := range delta.RemovedWorkloadIds {
+		delete(m.workloads, Key(id))
+		changed = true
+	}
+	if delta.Version != nil {
+		m.version = delta.Version
+	}
+	return changed
+}
+
+func (m *Mirror) removeHost(id string) {
+	delete(m.hosts, id)
+	for k, wl := range m.workloads {
+		if client.WorkloadHost(wl) == id {
+			delete(m.workloads, k)
+		}
+	}
+}
+
+// Workloads returns mirrored workloads sorted by host.
+func (m *Mirror) Workloads() []*clusterapi.Workload {
+	result := make([]*clusterapi.Workload, 0, len(m.workloads))
+	for _, wl := range m.workloads {
+		result = append(result, wl)
+	}
+	sort.Slice(result, func(i, j int) bool { return Key(result[i].Id) < Key(result[j].Id) })
+	return result
+}
+
+// Host returns metadata of mirrored host or nil.
+func (m *Mirror) Host(id string) *clusterapi.HostMetadata {
+	return m.hosts[id]
+}
+
+// State returns mirror content as cluster state, workloads grouped by host.
+func (m *Mirror) State() *clusterapi.ClusterState {
+	byHost := make(map[string][]*clusterapi.Workload)
+	for _, wl := range m.Workloads() {
+		h := client.WorkloadHost(wl)
+		byHost[h] = append(byHost[h], wl)
+	}
+	ids := make([]string, 0, len(m.hosts))
+	for id := range m.hosts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	cstate := &clusterapi.ClusterState{Version: m.version, Hosts: make([]*clusterapi.Host, 0, len(ids))}
+	for _, id := range ids {
+		cstate.Hosts = append(cstate.Hosts, &clusterapi.Host{Metadata: m.hosts[id], Workloads: byHost[id]})
+	}
+	return cstate
+}